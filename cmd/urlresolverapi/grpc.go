@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+)
+
+// serveGRPC starts serving gRPC requests on the given address in the
+// background, returning a func that gracefully stops the server.
+func serveGRPC(gs *grpc.Server, addr string, logger zerolog.Logger) func(context.Context) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("grpc listen error")
+	}
+	go func() {
+		logger.Info().Msgf("grpc listening on %s", addr)
+		if err := gs.Serve(lis); err != nil {
+			logger.Error().Err(err).Msg("grpc serve error")
+		}
+	}()
+
+	return func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			// forcibly close any remaining connections
+			gs.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/mccutchen/safedialer"
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+)
+
+// jobConfig holds the configuration for asynchronous resolve jobs.
+type jobConfig struct {
+	workers        *int
+	queueSize      *int
+	maxURLs        *int
+	urlConcurrency *int
+	ttl            *time.Duration
+
+	callbackSecret   *string
+	callbackAttempts *int
+	callbackBackoff  *time.Duration
+	callbackTimeout  *time.Duration
+}
+
+func registerJobFlags(fs *flag.FlagSet) *jobConfig {
+	return &jobConfig{
+		workers:        fs.Int("job-workers", 4, "Number of asynchronous resolve jobs that may run concurrently"),
+		queueSize:      fs.Int("job-queue-size", 100, "Max number of jobs waiting to run, beyond which new jobs are rejected"),
+		maxURLs:        fs.Int("job-max-urls", 100, "Max number of URLs in a single job"),
+		urlConcurrency: fs.Int("job-url-concurrency", 4, "Number of URLs within a single job that may be resolved concurrently"),
+		ttl:            fs.Duration("job-ttl", 24*time.Hour, "How long job status and results are kept"),
+
+		callbackSecret:   fs.String("job-callback-secret", "", "Secret used to sign job callback requests (enables callbacks)"),
+		callbackAttempts: fs.Int("job-callback-attempts", 5, "Max number of attempts to deliver a job callback"),
+		callbackBackoff:  fs.Duration("job-callback-backoff", 1*time.Second, "Delay before the first retry of a failed job callback, doubled after each attempt"),
+		callbackTimeout:  fs.Duration("job-callback-timeout", 5*time.Second, "Timeout for a single job callback request"),
+	}
+}
+
+// newJobStore stores job state in redis when available, so that any instance
+// can answer status queries.
+func newJobStore(cfg *jobConfig, chain *resolverChain, logger zerolog.Logger) jobs.Store {
+	if chain.redisClient != nil {
+		return jobs.NewRedisStore(chain.redisClient, *cfg.ttl)
+	}
+	logger.Info().Msg("set REDIS_URL to share job state across instances")
+	return jobs.NewMemoryStore(*cfg.ttl)
+}
+
+func newJobRunner(cfg *jobConfig, chain *resolverChain, logger zerolog.Logger) *jobs.Runner {
+	store := newJobStore(cfg, chain, logger)
+
+	if *cfg.callbackSecret == "" {
+		logger.Info().Msg("set JOB_CALLBACK_SECRET to enable job callbacks")
+	}
+
+	// callback URLs are provided by clients, so they get the same protection
+	// against requests to internal addresses as resolved URLs
+	callbackClient := &http.Client{
+		Timeout: *cfg.callbackTimeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Control: safedialer.Control,
+			}).DialContext,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return jobs.NewRunner(chain.resolver, store, jobs.Options{
+		Workers:          *cfg.workers,
+		QueueSize:        *cfg.queueSize,
+		MaxURLs:          *cfg.maxURLs,
+		URLConcurrency:   *cfg.urlConcurrency,
+		MapError:         httphandler.MapError,
+		ErrorCode:        httphandler.ErrorCode,
+		CallbackClient:   callbackClient,
+		CallbackSecret:   *cfg.callbackSecret,
+		CallbackAttempts: *cfg.callbackAttempts,
+		CallbackBackoff:  *cfg.callbackBackoff,
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+)
+
+func TestNewJobStore(t *testing.T) {
+	t.Parallel()
+
+	redisSrv, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(redisSrv.Close)
+
+	testCases := map[string]struct {
+		args      []string
+		wantStore jobs.Store
+	}{
+		"redis": {
+			args:      []string{"-redis-url", "redis://" + redisSrv.Addr()},
+			wantStore: &jobs.RedisStore{},
+		},
+		"memory": {
+			wantStore: &jobs.MemoryStore{},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			resolverCfg := registerResolverFlags(fs)
+			jobCfg := registerJobFlags(fs)
+			assert.NoError(t, fs.Parse(tc.args))
+
+			chain := newResolverChain(resolverCfg, zerolog.Nop())
+			t.Cleanup(func() {
+				for _, shutdown := range chain.shutdownFuncs {
+					_ = shutdown(context.Background())
+				}
+			})
+
+			store := newJobStore(jobCfg, chain, zerolog.Nop())
+			assert.IsType(t, tc.wantStore, store)
+		})
+	}
+}
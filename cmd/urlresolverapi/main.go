@@ -13,21 +13,17 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/go-redis/cache/v8"
-	"github.com/go-redis/redis/v8"
 	beeline "github.com/honeycombio/beeline-go"
 	"github.com/peterbourgon/ff/v3"
 	"github.com/rs/zerolog"
 	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
 
-	"github.com/mccutchen/safedialer"
-	"github.com/mccutchen/urlresolver"
-	"github.com/mccutchen/urlresolver/fakebrowser"
+	"github.com/mccutchen/urlresolverapi/pkg/grpcserver"
+	"github.com/mccutchen/urlresolverapi/pkg/grpcserver/urlresolverpb"
 	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
 	"github.com/mccutchen/urlresolverapi/pkg/httphandler/middleware"
-	"github.com/mccutchen/urlresolverapi/pkg/resolvers/cached"
-	"github.com/mccutchen/urlresolverapi/pkg/resolvers/coalesced"
-	"github.com/mccutchen/urlresolverapi/pkg/tracetransport"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
 )
 
 func main() {
@@ -43,31 +39,49 @@ func main() {
 		}
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		runWarm(os.Args[2:], logger)
+		return
+	}
+	runServer(os.Args[1:], logger)
+}
+
+func runServer(args []string, logger zerolog.Logger) {
 	fs := flag.NewFlagSet("urlresolverapi", flag.ExitOnError)
 	var (
 		port      = fs.Int("port", 8080, "Port to listen on")
 		debugPort = fs.Int("debug-port", 6060, "Port on which to expose pprof/expvar debugging endpoints (disabled if == 0)")
+		grpcPort  = fs.Int("grpc-port", 9090, "Port on which to serve the gRPC API (disabled if == 0)")
+
+		grpcMaxBatchSize     = fs.Int("grpc-max-batch-size", grpcserver.DefaultMaxBatchSize, "Max number of URLs in a single gRPC batch request")
+		grpcBatchConcurrency = fs.Int("grpc-batch-concurrency", grpcserver.DefaultBatchConcurrency, "Number of URLs within a single gRPC batch request that may be resolved concurrently")
 
 		authTokens = fs.String("auth-tokens", "", "Comma-separated list of valid auth tokens in \"client-id:token-value\" format for which rate limiting is disabled")
 		rateLimit  = fs.Float64("rate-limit", 10, "Per-second, per-instance rate limit for anonymous clients (use 0 to disable anonymous requests)")
 		burstLimit = fs.Int("burst-limit", 2, "Allowed bursts over rate limit (if rate limit >= 0)")
 
-		requestTimeout = fs.Duration("request-timeout", 10*time.Second, "Overall timeout on a single resolve request, including any redirects")
-		clientPatience = fs.Duration("client-patience", 1*time.Second, "How long to wait for slow clients to write requests or read responses")
+		defaultCacheControl     = httphandler.DefaultCacheControl()
+		cacheControlOK          = fs.String("cache-control-ok", defaultCacheControl.OK, "Cache-Control header for successful resolve responses")
+		cacheControlTimeout     = fs.String("cache-control-timeout", defaultCacheControl.Timeout, "Cache-Control header for partial resolve responses after an upstream timeout")
+		cacheControlUnsafe      = fs.String("cache-control-unsafe", defaultCacheControl.Unsafe, "Cache-Control header for partial resolve responses for unsafe or blocked URLs")
+		cacheControlError       = fs.String("cache-control-error", defaultCacheControl.Error, "Cache-Control header for partial resolve responses after any other error")
+		cacheControlClientError = fs.String("cache-control-client-error", defaultCacheControl.ClientError, "Cache-Control header for invalid resolve requests")
+		cacheControlOverrides   = fs.String("cache-control-overrides", "", "Semicolon-separated list of per-client Cache-Control overrides in \"client-id[/outcome]=cache-control\" format, where client-id may be * for any authenticated client")
+
+		maxRedirects        = fs.Int("max-redirects", resolveopts.DefaultMaxRedirects, "Max number of redirects a client may ask to follow for a single resolve request")
+		clientResolveLimits = fs.String("client-resolve-limits", "", "Semicolon-separated list of stricter per-client limits on resolve options in \"client-id/limit=value\" format, where limit is timeout or redirects and client-id may be * for any authenticated client")
 
-		redisURL     = fs.String("redis-url", "", "Redis connection URL (enables caching)")
-		redisTimeout = fs.Duration("redis-timeout", 150*time.Millisecond, "Timeout for redis operations (if caching enabled)")
-		cacheTTL     = fs.Duration("cache-ttl", 120*time.Hour, "TTL for cached results (if caching enabled)")
+		clientPatience = fs.Duration("client-patience", 1*time.Second, "How long to wait for slow clients to write requests or read responses")
 
 		honeycombAPIKey      = fs.String("honeycomb-api-key", "", "Honeycomb API key (enables sending telemetry data to honeycomb)")
 		honeycombDataset     = fs.String("honeycomb-dataset", "urlresolverapi", "Honeycomb dataset for telemetry data")
 		honeycombServiceName = fs.String("honeycomb-service-name", "urlresolverapi", "Service name for telemetry data")
 		honeycombSampleRate  = fs.Uint("honeycomb-sample-rate", 1, "Sample rate for telemetry data (1/N events will be submitted)")
 
-		transportIdleConnTTL         = fs.Duration("idle-cx-ttl", 90*time.Second, "TTL for idle connections")
-		transportMaxIdleConnsPerHost = fs.Int("max-idle-cx-per-host", 10, "Max idle connections per host")
+		resolverCfg = registerResolverFlags(fs)
+		jobCfg      = registerJobFlags(fs)
 	)
-	if err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarNoPrefix()); err != nil {
+	if err := ff.Parse(fs, args, ff.WithEnvVarNoPrefix()); err != nil {
 		logger.Fatal().Msgf("error parsing configuration: %s", err)
 	}
 
@@ -76,8 +90,37 @@ func main() {
 		logger.Fatal().Msgf("error parsing auth tokens: %s", err)
 	}
 
+	var cacheControl httphandler.CacheControl
+	for outcome, value := range map[string]string{
+		httphandler.OutcomeOK:          *cacheControlOK,
+		httphandler.OutcomeTimeout:     *cacheControlTimeout,
+		httphandler.OutcomeUnsafe:      *cacheControlUnsafe,
+		httphandler.OutcomeError:       *cacheControlError,
+		httphandler.OutcomeClientError: *cacheControlClientError,
+	} {
+		if err := cacheControl.Set(outcome, value); err != nil {
+			logger.Fatal().Msgf("error parsing cache control for %s responses: %s", outcome, err)
+		}
+	}
+	cacheControlOverrideMap, err := httphandler.ParseCacheControlOverrides(*cacheControlOverrides)
+	if err != nil {
+		logger.Fatal().Msgf("error parsing cache control overrides: %s", err)
+	}
+
+	clientLimits, err := resolveopts.ParseClientLimits(*clientResolveLimits)
+	if err != nil {
+		logger.Fatal().Msgf("error parsing client resolve limits: %s", err)
+	}
+	resolvePolicy := resolveopts.Policy{
+		Limits: resolveopts.Limits{
+			MaxTimeout:   *resolverCfg.requestTimeout,
+			MaxRedirects: *maxRedirects,
+		},
+		Clients: clientLimits,
+	}
+
 	var (
-		shutdownTimeout    = *requestTimeout + *clientPatience
+		shutdownTimeout    = *resolverCfg.requestTimeout + *clientPatience
 		serverReadTimeout  = *clientPatience
 		serverWriteTimeout = shutdownTimeout
 	)
@@ -117,43 +160,22 @@ func main() {
 		logger.Info().Msg("set HONEYCOMB_API_KEY to capture telemetry")
 	}
 
-	// set up transport used by resolver
-	transport := fakebrowser.New(tracetransport.New(&http.Transport{
-		DialContext: (&net.Dialer{
-			Control: safedialer.Control,
-		}).DialContext,
-		IdleConnTimeout:     *transportIdleConnTTL,
-		MaxIdleConnsPerHost: *transportMaxIdleConnsPerHost,
-		MaxIdleConns:        *transportMaxIdleConnsPerHost * 2,
-	}))
-
-	// set up resolver w/ optional redis caching
-	var resolver urlresolver.Interface = urlresolver.New(transport, *requestTimeout)
-	if *redisURL != "" {
-		// set up optional redis cache
-		opt, err := redis.ParseURL(*redisURL)
-		if err == nil {
-			opt.DialTimeout = *redisTimeout * 2
-			opt.ReadTimeout = *redisTimeout
-			opt.WriteTimeout = *redisTimeout
-			redisCache := cache.New(&cache.Options{Redis: redis.NewClient(opt)})
-			resolver = cached.NewResolver(resolver, cached.NewRedisCache(redisCache, *cacheTTL))
-		} else {
-			logger.Error().Err(err).Msg("REDIS_URL invalid, cache disabled")
-		}
-	} else {
-		logger.Info().Msg("set REDIS_URL to enable caching")
-	}
-
-	// ensure that concurrent requests are coalesced, regardless of whether
-	// they're cached or not
-	resolver = coalesced.New(resolver)
+	chain := newResolverChain(resolverCfg, logger)
+	reloadOnSignal(logger, chain.reloaders)
+	jobRunner := newJobRunner(jobCfg, chain, logger)
 
 	// configure per-instance rate limiting
 	rl := rate.NewLimiter(rate.Limit(*rateLimit), *burstLimit)
 
-	mux := http.NewServeMux()
-	mux.Handle("/resolve", httphandler.New(resolver))
+	mux := newMux(
+		httphandler.New(
+			chain.resolver,
+			httphandler.WithExpandResolver(chain.expandResolver),
+			httphandler.WithCacheControl(cacheControl, cacheControlOverrideMap),
+			httphandler.WithResolvePolicy(resolvePolicy),
+		),
+		httphandler.NewJobsHandler(jobRunner),
+	)
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
@@ -165,10 +187,38 @@ func main() {
 		WriteTimeout: serverWriteTimeout,
 	}
 
-	listenAndServeGracefully(srv, shutdownTimeout, logger)
+	// jobs must finish before the resolver chain shuts down, because they may
+	// still produce background cache writes
+	shutdownFuncs := []func(context.Context) error{jobRunner.Shutdown}
+
+	// the gRPC API applies the same auth and rate limiting policy as the
+	// HTTP API
+	if *grpcPort > 0 {
+		gs := grpc.NewServer(
+			grpc.ChainUnaryInterceptor(middleware.UnaryServerInterceptor(authMap, rl, logger)),
+			grpc.ChainStreamInterceptor(middleware.StreamServerInterceptor(authMap, rl, logger)),
+		)
+		urlresolverpb.RegisterURLResolverServer(gs, grpcserver.New(
+			chain.resolver,
+			grpcserver.WithExpandResolver(chain.expandResolver),
+			grpcserver.WithMaxBatchSize(*grpcMaxBatchSize),
+			grpcserver.WithBatchConcurrency(*grpcBatchConcurrency),
+		))
+		grpcAddr := net.JoinHostPort("", strconv.Itoa(*grpcPort))
+		shutdownFuncs = append([]func(context.Context) error{serveGRPC(gs, grpcAddr, logger)}, shutdownFuncs...)
+	} else {
+		logger.Info().Msg("set GRPC_PORT to enable the gRPC API")
+	}
+
+	shutdownFuncs = append(shutdownFuncs, chain.shutdownFuncs...)
+	listenAndServeGracefully(srv, shutdownTimeout, logger, shutdownFuncs...)
 }
 
-func listenAndServeGracefully(srv *http.Server, shutdownTimeout time.Duration, logger zerolog.Logger) {
+// listenAndServeGracefully runs the server until it receives SIGTERM or
+// SIGINT, then shuts it down gracefully. Once the server has stopped, any
+// given shutdown funcs are called to allow background work to finish before
+// exiting.
+func listenAndServeGracefully(srv *http.Server, shutdownTimeout time.Duration, logger zerolog.Logger, shutdownFuncs ...func(context.Context) error) {
 	// exitCh will be closed when it is safe to exit, after the server has had
 	// a chance to shut down gracefully
 	exitCh := make(chan struct{})
@@ -187,6 +237,16 @@ func listenAndServeGracefully(srv *http.Server, shutdownTimeout time.Duration, l
 			logger.Error().Err(err).Msg("shutdown error")
 		}
 
+		// now that no new requests will be handled, wait for any background
+		// work to finish
+		bgCtx, bgCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer bgCancel()
+		for _, f := range shutdownFuncs {
+			if err := f(bgCtx); err != nil {
+				logger.Error().Err(err).Msg("background shutdown error")
+			}
+		}
+
 		// indicate that it is now safe to exit
 		close(exitCh)
 	}()
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+)
+
+// reloader is implemented by configuration that can be reloaded at runtime.
+type reloader interface {
+	Reload() error
+}
+
+// namedReloader attaches a name to a reloader for logging purposes.
+type namedReloader struct {
+	name string
+	reloader
+}
+
+// reloadOnSignal reloads the given configuration every time the process
+// receives SIGHUP. Errors are logged and the existing configuration is kept.
+func reloadOnSignal(logger zerolog.Logger, reloaders []reloader) {
+	if len(reloaders) == 0 {
+		return
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			for _, r := range reloaders {
+				name := "config"
+				if nr, ok := r.(namedReloader); ok {
+					name = nr.name
+				}
+				if err := r.Reload(); err != nil {
+					logger.Error().Err(err).Msgf("error reloading %s", name)
+					continue
+				}
+				logger.Info().Msgf("reloaded %s", name)
+			}
+		}
+	}()
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+
+	"github.com/mccutchen/safedialer"
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolver/fakebrowser"
+	"github.com/mccutchen/urlresolverapi/pkg/canonical"
+	"github.com/mccutchen/urlresolverapi/pkg/dnscache"
+	"github.com/mccutchen/urlresolverapi/pkg/domainrules"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/hostpolicy"
+	"github.com/mccutchen/urlresolverapi/pkg/interstitial"
+	"github.com/mccutchen/urlresolverapi/pkg/pageinfo"
+	"github.com/mccutchen/urlresolverapi/pkg/parampolicy"
+	"github.com/mccutchen/urlresolverapi/pkg/pinning"
+	"github.com/mccutchen/urlresolverapi/pkg/proxypool"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resolvers/cached"
+	"github.com/mccutchen/urlresolverapi/pkg/resolvers/coalesced"
+	"github.com/mccutchen/urlresolverapi/pkg/resolvers/expand"
+	"github.com/mccutchen/urlresolverapi/pkg/retrytransport"
+	"github.com/mccutchen/urlresolverapi/pkg/robots"
+	"github.com/mccutchen/urlresolverapi/pkg/tracetransport"
+)
+
+// resolverConfig holds the configuration needed to build the resolver chain,
+// which is shared by the server and the warm subcommand.
+type resolverConfig struct {
+	requestTimeout *time.Duration
+
+	redisURL     *string
+	redisTimeout *time.Duration
+	cacheTTL     *time.Duration
+
+	breakerErrorRate   *float64
+	breakerMinRequests *int
+	breakerSlow        *time.Duration
+	breakerWindow      *time.Duration
+	breakerOpenTimeout *time.Duration
+	breakerProbes      *int
+
+	cacheWriteWorkers   *int
+	cacheWriteQueueSize *int
+
+	transportIdleConnTTL         *time.Duration
+	transportMaxIdleConnsPerHost *int
+
+	upstreamMaxRetries      *int
+	upstreamRetryBackoff    *time.Duration
+	upstreamHedgePercentile *float64
+	upstreamHedgeMinDelay   *time.Duration
+
+	dnsCacheMinTTL      *time.Duration
+	dnsCacheMaxTTL      *time.Duration
+	dnsCacheNegativeTTL *time.Duration
+	dnsServers          *string
+
+	egressProxies               *string
+	egressProxyStrategy         *string
+	egressProxyFailureThreshold *int
+	egressProxyCooldown         *time.Duration
+
+	hostPolicyFile        *string
+	domainRulesFile       *string
+	interstitialRulesFile *string
+	canonicalRulesFile    *string
+	paramPolicyFile       *string
+
+	robotsUserAgent *string
+	robotsTTL       *time.Duration
+	robotsErrorTTL  *time.Duration
+
+	shortenerDomains *string
+}
+
+func registerResolverFlags(fs *flag.FlagSet) *resolverConfig {
+	return &resolverConfig{
+		requestTimeout: fs.Duration("request-timeout", 10*time.Second, "Overall timeout on a single resolve request, including any redirects"),
+
+		redisURL:     fs.String("redis-url", "", "Redis connection URL (enables caching)"),
+		redisTimeout: fs.Duration("redis-timeout", 150*time.Millisecond, "Timeout for redis operations (if caching enabled)"),
+		cacheTTL:     fs.Duration("cache-ttl", 120*time.Hour, "TTL for cached results (if caching enabled)"),
+
+		breakerErrorRate:   fs.Float64("redis-breaker-error-rate", 0.5, "Fraction of failed or slow redis operations that trips the cache circuit breaker (disabled if <= 0)"),
+		breakerMinRequests: fs.Int("redis-breaker-min-requests", 20, "Minimum number of redis operations per window before the cache circuit breaker may trip"),
+		breakerSlow:        fs.Duration("redis-breaker-slow-threshold", 100*time.Millisecond, "Redis operations slower than this count as failures for the cache circuit breaker (disabled if == 0)"),
+		breakerWindow:      fs.Duration("redis-breaker-window", 10*time.Second, "Interval over which the cache circuit breaker's error rate is calculated"),
+		breakerOpenTimeout: fs.Duration("redis-breaker-open-timeout", 30*time.Second, "How long the cache circuit breaker skips redis before probing it again"),
+		breakerProbes:      fs.Int("redis-breaker-probes", 3, "Consecutive successful probes required to close the cache circuit breaker"),
+
+		cacheWriteWorkers:   fs.Int("cache-write-workers", 0, "Number of background workers writing results to the cache (writes are synchronous if == 0)"),
+		cacheWriteQueueSize: fs.Int("cache-write-queue-size", 1000, "Max number of pending background cache writes, beyond which writes are dropped (if cache write workers > 0)"),
+
+		transportIdleConnTTL:         fs.Duration("idle-cx-ttl", 90*time.Second, "TTL for idle connections"),
+		transportMaxIdleConnsPerHost: fs.Int("max-idle-cx-per-host", 10, "Max idle connections per host"),
+
+		upstreamMaxRetries:      fs.Int("upstream-max-retries", retrytransport.DefaultMaxRetries, "Max retries of upstream requests failing with connection errors or 502/503/504 responses (disabled if == 0)"),
+		upstreamRetryBackoff:    fs.Duration("upstream-retry-backoff", retrytransport.DefaultBackoff, "Initial backoff before retrying a failed upstream request, doubled for each subsequent retry"),
+		upstreamHedgePercentile: fs.Float64("upstream-hedge-percentile", 0, "Percentile of recent upstream latencies (e.g. 0.95) after which a hedged duplicate request is sent (disabled if == 0)"),
+		upstreamHedgeMinDelay:   fs.Duration("upstream-hedge-min-delay", retrytransport.DefaultHedgeMinDelay, "Minimum delay before sending a hedged upstream request"),
+
+		dnsCacheMinTTL:      fs.Duration("dns-cache-min-ttl", dnscache.DefaultMinTTL, "Minimum time for which DNS lookups are cached, regardless of record TTLs"),
+		dnsCacheMaxTTL:      fs.Duration("dns-cache-max-ttl", dnscache.DefaultMaxTTL, "Maximum time for which DNS lookups are cached, regardless of record TTLs (DNS caching disabled if == 0)"),
+		dnsCacheNegativeTTL: fs.Duration("dns-cache-negative-ttl", dnscache.DefaultNegativeTTL, "Time for which lookups of nonexistent hosts are cached"),
+		dnsServers:          fs.String("dns-servers", "", "Comma-separated list of DNS servers in host:port form used by the DNS cache (defaults to the nameservers in /etc/resolv.conf)"),
+
+		egressProxies:               fs.String("egress-proxies", "", "Comma-separated list of http:// (CONNECT) or socks5:// proxy URLs through which upstream requests are made (direct if empty)"),
+		egressProxyStrategy:         fs.String("egress-proxy-strategy", string(proxypool.StrategyRoundRobin), "How egress proxies are chosen: round-robin, sticky (per destination host), or weighted (by recent success rate)"),
+		egressProxyFailureThreshold: fs.Int("egress-proxy-failure-threshold", proxypool.DefaultFailureThreshold, "Consecutive failures after which an egress proxy is marked unhealthy"),
+		egressProxyCooldown:         fs.Duration("egress-proxy-cooldown", proxypool.DefaultCooldown, "How long an unhealthy egress proxy is avoided before it is tried again"),
+
+		hostPolicyFile:        fs.String("host-policy-file", "", "Path to a file of allow/deny rules restricting which hosts may be fetched (reloaded on SIGHUP)"),
+		domainRulesFile:       fs.String("domain-rules-file", "", "Path to a file of per-domain rules overriding timeouts, headers, cookies, and title fetching (reloaded on SIGHUP)"),
+		interstitialRulesFile: fs.String("interstitial-rules-file", "", "Path to a file of rules detecting cookie consent walls and other interstitials, and how to bypass them (reloaded on SIGHUP)"),
+		canonicalRulesFile:    fs.String("canonical-rules-file", "", "Path to a file of rules mapping AMP and mobile URLs to canonical URLs when pages have no canonical link (reloaded on SIGHUP)"),
+		paramPolicyFile:       fs.String("param-policy-file", "", "Path to a file of global and per-domain rules for stripping tracking query parameters from URLs (reloaded on SIGHUP)"),
+
+		robotsUserAgent: fs.String("robots-user-agent", "", "User agent whose robots.txt rules must allow fetching the final page for its title (robots.txt compliance disabled if empty)"),
+		robotsTTL:       fs.Duration("robots-cache-ttl", robots.DefaultTTL, "Time for which fetched or missing robots.txt files are cached"),
+		robotsErrorTTL:  fs.Duration("robots-error-ttl", robots.DefaultErrorTTL, "Time for which unreachable robots.txt files are cached, disallowing every page on the host"),
+
+		shortenerDomains: fs.String("shortener-domains", strings.Join(expand.DefaultShorteners, ","), "Comma-separated list of known URL shortener domains followed by mode=expand requests"),
+	}
+}
+
+// resolverChain is the fully configured resolver along with the pieces
+// that callers may need direct access to.
+type resolverChain struct {
+	resolver urlresolver.Interface
+
+	// expandResolver only follows redirects through known URL shorteners
+	expandResolver urlresolver.Interface
+
+	// cacheEnabled indicates whether results are cached
+	cacheEnabled bool
+
+	// redisClient is the redis client used for caching, if enabled, which
+	// may be shared with other features needing shared state
+	redisClient *redis.Client
+
+	// shutdownFuncs should be called before exiting, to allow any
+	// background work to finish
+	shutdownFuncs []func(context.Context) error
+
+	// reloaders hold configuration that may be reloaded at runtime
+	reloaders []reloader
+}
+
+func newResolverChain(cfg *resolverConfig, logger zerolog.Logger) *resolverChain {
+	chain := &resolverChain{}
+
+	// set up optional host policy
+	var policy *hostpolicy.Policy
+	if *cfg.hostPolicyFile != "" {
+		var err error
+		policy, err = hostpolicy.Load(*cfg.hostPolicyFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error loading host policy")
+		}
+		chain.reloaders = append(chain.reloaders, namedReloader{"host policy", policy})
+	}
+
+	// set up optional per-domain rules
+	var rules *domainrules.Rules
+	if *cfg.domainRulesFile != "" {
+		var err error
+		rules, err = domainrules.Load(*cfg.domainRulesFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error loading domain rules")
+		}
+		chain.reloaders = append(chain.reloaders, namedReloader{"domain rules", rules})
+	}
+
+	// set up optional interstitial detection
+	var interstitials *interstitial.Rules
+	if *cfg.interstitialRulesFile != "" {
+		var err error
+		interstitials, err = interstitial.Load(*cfg.interstitialRulesFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error loading interstitial rules")
+		}
+		chain.reloaders = append(chain.reloaders, namedReloader{"interstitial rules", interstitials})
+	}
+
+	// AMP and mobile URLs are always mapped to canonical URLs, with
+	// optional rules for pages without canonical links
+	canonicalRules := canonical.New()
+	if *cfg.canonicalRulesFile != "" {
+		var err error
+		canonicalRules, err = canonical.Load(*cfg.canonicalRulesFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error loading canonical rules")
+		}
+		chain.reloaders = append(chain.reloaders, namedReloader{"canonical rules", canonicalRules})
+	}
+
+	// set up optional query parameter policy
+	var params *parampolicy.Policy
+	if *cfg.paramPolicyFile != "" {
+		var err error
+		params, err = parampolicy.Load(*cfg.paramPolicyFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("error loading param policy")
+		}
+		chain.reloaders = append(chain.reloaders, namedReloader{"param policy", params})
+	}
+
+	// set up optional DNS cache
+	var lookupResolver pinning.Resolver = net.DefaultResolver
+	if *cfg.dnsCacheMaxTTL > 0 {
+		var servers []string
+		if *cfg.dnsServers != "" {
+			servers = strings.Split(*cfg.dnsServers, ",")
+		}
+		lookupResolver = dnscache.New(dnscache.Options{
+			MinTTL:      *cfg.dnsCacheMinTTL,
+			MaxTTL:      *cfg.dnsCacheMaxTTL,
+			NegativeTTL: *cfg.dnsCacheNegativeTTL,
+			Servers:     servers,
+		})
+	}
+
+	// every connection, direct or proxied, is pinned to addresses validated
+	// by safedialer, and hosts resolving to any unsafe address are refused
+	dialContext := pinning.NewDialer(lookupResolver, safedialer.Control).DialContext
+
+	// set up optional egress proxies, which must enforce the same
+	// restrictions on destination addresses as direct connections
+	if *cfg.egressProxies != "" {
+		strategy, err := proxypool.ParseStrategy(*cfg.egressProxyStrategy)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("invalid egress proxy strategy")
+		}
+		pool, err := proxypool.New(strings.Split(*cfg.egressProxies, ","), proxypool.Options{
+			Strategy:         strategy,
+			FailureThreshold: *cfg.egressProxyFailureThreshold,
+			Cooldown:         *cfg.egressProxyCooldown,
+			Control:          safedialer.Control,
+			Resolver:         lookupResolver,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("invalid egress proxies")
+		}
+		expvar.Publish("egress_proxies", expvar.Func(func() any { return pool.Stats() }))
+		dialContext = pool.DialContext
+	}
+
+	// set up transport used by resolver
+	upstream := retrytransport.New(tracetransport.New(&http.Transport{
+		DialContext:         dialContext,
+		IdleConnTimeout:     *cfg.transportIdleConnTTL,
+		MaxIdleConnsPerHost: *cfg.transportMaxIdleConnsPerHost,
+		MaxIdleConns:        *cfg.transportMaxIdleConnsPerHost * 2,
+	}), retrytransport.Options{
+		MaxRetries:      *cfg.upstreamMaxRetries,
+		Backoff:         *cfg.upstreamRetryBackoff,
+		HedgePercentile: *cfg.upstreamHedgePercentile,
+		HedgeMinDelay:   *cfg.upstreamHedgeMinDelay,
+	})
+	var transport http.RoundTripper = upstream
+	if rules != nil {
+		transport = domainrules.NewTransport(rules, transport)
+	}
+
+	// finishTransport applies the same per-request options, browser
+	// headers, and host policy to every transport
+	finishTransport := func(transport http.RoundTripper) http.RoundTripper {
+		transport = fakebrowser.New(resolveopts.NewTransport(transport))
+		if policy != nil {
+			transport = hostpolicy.NewTransport(policy, transport)
+		}
+		return transport
+	}
+
+	// expand mode never fetches titles, so it ignores robots.txt
+	expandTransport := finishTransport(transport)
+
+	// send the cookies and headers of any interstitial rule being retried
+	if interstitials != nil {
+		transport = interstitial.NewTransport(transport)
+	}
+
+	// set up optional robots.txt compliance, fetching robots.txt files
+	// directly upstream, subject only to the host policy
+	var robotsChecker *robots.Checker
+	if *cfg.robotsUserAgent != "" {
+		var robotsTransport http.RoundTripper = upstream
+		if policy != nil {
+			robotsTransport = hostpolicy.NewTransport(policy, robotsTransport)
+		}
+		var err error
+		robotsChecker, err = robots.New(robotsTransport, robots.Options{
+			UserAgent: *cfg.robotsUserAgent,
+			TTL:       *cfg.robotsTTL,
+			ErrorTTL:  *cfg.robotsErrorTTL,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("invalid robots.txt options")
+		}
+		transport = robots.NewTransport(robotsChecker, transport)
+	}
+
+	// identify the content type of every final response, which may need a
+	// fallback title, and find the canonical link of every final HTML page
+	transport = pageinfo.NewTransport(canonical.NewTransport(finishTransport(transport)), pageinfo.Options{})
+
+	// set up optional redis cache
+	var resultCache cached.Cache
+	if *cfg.redisURL != "" {
+		opt, err := redis.ParseURL(*cfg.redisURL)
+		if err == nil {
+			opt.DialTimeout = *cfg.redisTimeout * 2
+			opt.ReadTimeout = *cfg.redisTimeout
+			opt.WriteTimeout = *cfg.redisTimeout
+			chain.redisClient = redis.NewClient(opt)
+			redisCache := cache.New(&cache.Options{Redis: chain.redisClient})
+			resultCache = cached.NewRedisCache(redisCache, *cfg.cacheTTL)
+			if *cfg.breakerErrorRate > 0 {
+				resultCache = cached.NewBreakerCache(resultCache, cached.BreakerOptions{
+					ErrorRate:      *cfg.breakerErrorRate,
+					MinRequests:    *cfg.breakerMinRequests,
+					SlowThreshold:  *cfg.breakerSlow,
+					Window:         *cfg.breakerWindow,
+					OpenTimeout:    *cfg.breakerOpenTimeout,
+					HalfOpenProbes: *cfg.breakerProbes,
+				}, logger)
+			}
+			if *cfg.cacheWriteWorkers > 0 {
+				asyncCache := cached.NewAsyncCache(resultCache, *cfg.cacheWriteWorkers, *cfg.cacheWriteQueueSize)
+				expvar.Publish("cache_writes", expvar.Func(func() any { return asyncCache.Stats() }))
+				chain.shutdownFuncs = append(chain.shutdownFuncs, asyncCache.Shutdown)
+				resultCache = asyncCache
+			}
+			chain.cacheEnabled = true
+		} else {
+			logger.Error().Err(err).Msg("REDIS_URL invalid, cache disabled")
+		}
+	} else {
+		logger.Info().Msg("set REDIS_URL to enable caching")
+	}
+
+	// withOptions applies the same domain rules and per-request options to
+	// every resolver
+	withOptions := func(resolver urlresolver.Interface) urlresolver.Interface {
+		if rules != nil {
+			resolver = domainrules.NewResolver(rules, resolver)
+		}
+		return resolveopts.NewResolver(resolver)
+	}
+
+	// wrap applies the same optional caching, request coalescing, and host
+	// policy to every resolver
+	wrap := func(resolver urlresolver.Interface, resultCache cached.Cache) urlresolver.Interface {
+		if resultCache != nil {
+			resolver = cached.NewResolver(resolver, resultCache)
+		}
+
+		// ensure that concurrent requests are coalesced, regardless of
+		// whether they're cached or not
+		resolver = coalesced.New(resolver)
+
+		// enforce host policy before resolving and on every result,
+		// including cached results
+		if policy != nil {
+			resolver = hostpolicy.NewResolver(policy, resolver)
+		}
+
+		// strip query parameters before the URL is checked, coalesced or
+		// cached, and from every result, including cached results
+		if params != nil {
+			resolver = parampolicy.NewResolver(params, resolver)
+		}
+		return resolver
+	}
+
+	resolver := withOptions(pageinfo.NewResolver(urlresolver.New(transport, *cfg.requestTimeout)))
+
+	// interstitials are detected before caching, and any retry gets its own
+	// per-request options (e.g. its own redirect limit)
+	if interstitials != nil {
+		resolver = interstitial.NewResolver(interstitials, resolver, *cfg.requestTimeout)
+	}
+
+	// AMP and mobile URLs are mapped to canonical URLs before caching, while
+	// the final page's canonical link is still available
+	resolver = canonical.NewResolver(canonicalRules, resolver)
+	chain.resolver = wrap(resolver, resultCache)
+
+	// robots.txt is checked for every result, including cached results
+	if robotsChecker != nil {
+		chain.resolver = robots.NewResolver(robotsChecker, chain.resolver)
+	}
+
+	// expand mode results are cached separately from full results
+	shorteners, err := hostmatch.ParseList(strings.Split(*cfg.shortenerDomains, ","))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("invalid shortener domains")
+	}
+	var expandCache cached.Cache
+	if resultCache != nil {
+		expandCache = cached.NewNamespacedCache(resultCache, "expand")
+	}
+	expandResolver := withOptions(expand.New(expandTransport, shorteners, *cfg.requestTimeout))
+	chain.expandResolver = wrap(canonical.NewResolver(canonicalRules, expandResolver), expandCache)
+
+	return chain
+}
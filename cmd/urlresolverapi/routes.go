@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
+)
+
+// newMux routes requests to the API handlers. The API is mounted under a
+// version prefix, and also at the root for backwards compatibility with
+// clients predating versioning.
+func newMux(resolveHandler http.Handler, jobsHandler *httphandler.JobsHandler) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, prefix := range []string{httphandler.APIVersionPrefix, ""} {
+		mux.Handle(prefix+"/resolve", resolveHandler)
+		mux.HandleFunc("POST "+prefix+"/jobs", jobsHandler.Create)
+		mux.HandleFunc("GET "+prefix+"/jobs/{id}", jobsHandler.Status)
+	}
+	mux.Handle("/openapi.json", httphandler.OpenAPIHandler())
+	return mux
+}
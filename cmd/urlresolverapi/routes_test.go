@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+)
+
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+
+	resolveHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("resolve")) //nolint:errcheck
+	})
+	runner := jobs.NewRunner(nil, jobs.NewMemoryStore(time.Hour), jobs.Options{})
+	t.Cleanup(func() { _ = runner.Shutdown(context.Background()) })
+	mux := newMux(resolveHandler, httphandler.NewJobsHandler(runner))
+
+	testCases := map[string]struct {
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		"versioned resolve":      {"GET", "/v1/resolve?url=https://example.com", http.StatusOK, "resolve"},
+		"unversioned resolve":    {"GET", "/resolve?url=https://example.com", http.StatusOK, "resolve"},
+		"versioned resolve post": {"POST", "/v1/resolve", http.StatusOK, "resolve"},
+		"versioned job status":   {"GET", "/v1/jobs/abc", http.StatusNotFound, "not_found"},
+		"unversioned job status": {"GET", "/jobs/abc", http.StatusNotFound, "not_found"},
+		"versioned job create":   {"POST", "/v1/jobs", http.StatusBadRequest, "invalid_request"},
+		"openapi spec":           {"GET", "/openapi.json", http.StatusOK, `"openapi"`},
+		"unknown version":        {"GET", "/v2/resolve", http.StatusNotFound, ""},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader("")))
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Contains(t, w.Body.String(), tc.wantBody)
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/peterbourgon/ff/v3"
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+// runWarm implements the warm subcommand, which pre-populates the cache by
+// resolving URLs read from files, stdin, or the server's own request logs.
+func runWarm(args []string, logger zerolog.Logger) {
+	fs := flag.NewFlagSet("urlresolverapi warm", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: urlresolverapi warm [flags] [file ...]\n\n")
+		fmt.Fprintf(fs.Output(), "Resolves URLs read from the given files (or stdin, if no files or \"-\" are given)\n")
+		fmt.Fprintf(fs.Output(), "to warm the cache. Input may be plain text with one URL per line or the server's\n")
+		fmt.Fprintf(fs.Output(), "own JSON request logs.\n\n")
+		fs.PrintDefaults()
+	}
+	var (
+		warmRate        = fs.Float64("warm-rate", 10, "Max number of URLs to resolve per second")
+		warmConcurrency = fs.Int("warm-concurrency", 4, "Max number of URLs to resolve concurrently")
+
+		resolverCfg = registerResolverFlags(fs)
+	)
+	if err := ff.Parse(fs, args, ff.WithEnvVarNoPrefix()); err != nil {
+		logger.Fatal().Msgf("error parsing configuration: %s", err)
+	}
+
+	chain := newResolverChain(resolverCfg, logger)
+	if !chain.cacheEnabled {
+		logger.Fatal().Msg("cache warming requires caching to be enabled via REDIS_URL")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	reqs := make(chan warmRequest)
+	go func() {
+		defer close(reqs)
+		if err := readWarmInputs(ctx, fs.Args(), reqs); err != nil {
+			logger.Error().Err(err).Msg("error reading input")
+		}
+	}()
+
+	var (
+		limiter = rate.NewLimiter(rate.Limit(*warmRate), 1)
+		stats   warmStats
+		wg      sync.WaitGroup
+		start   = time.Now()
+	)
+	for i := 0; i < *warmConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range reqs {
+				if err := limiter.Wait(ctx); err != nil {
+					return
+				}
+				stats.record(warmOne(ctx, chain, req, logger))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// give any background cache writes a chance to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *resolverCfg.requestTimeout)
+	defer cancel()
+	for _, f := range chain.shutdownFuncs {
+		if err := f(shutdownCtx); err != nil {
+			logger.Error().Err(err).Msg("background shutdown error")
+		}
+	}
+
+	logger.Info().
+		Int64("hits", stats.hits.Load()).
+		Int64("misses", stats.misses.Load()).
+		Int64("errors", stats.errors.Load()).
+		Dur("elapsed", time.Since(start)).
+		Msg("cache warming finished")
+}
+
+type warmOutcome int
+
+const (
+	warmHit warmOutcome = iota
+	warmMiss
+	warmError
+)
+
+type warmStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+func (s *warmStats) record(outcome warmOutcome) {
+	switch outcome {
+	case warmHit:
+		s.hits.Add(1)
+	case warmMiss:
+		s.misses.Add(1)
+	default:
+		s.errors.Add(1)
+	}
+}
+
+// warmRequest is a single resolve request to be replayed to warm the cache.
+type warmRequest struct {
+	URL  string
+	Mode string
+	Opts resolveopts.Options
+}
+
+// key identifies the cache entry the request warms, so that only distinct
+// entries are warmed.
+func (r warmRequest) key() string {
+	return r.Mode + " " + resolveopts.CacheKey(resolveopts.NewContext(context.Background(), r.Opts), r.URL)
+}
+
+func warmOne(ctx context.Context, chain *resolverChain, req warmRequest, logger zerolog.Logger) warmOutcome {
+	resolver := chain.resolver
+	if req.Mode == httphandler.ModeExpand {
+		resolver = chain.expandResolver
+	}
+	ctx, rec := resultmeta.NewContext(resolveopts.NewContext(ctx, req.Opts))
+	if _, err := resolver.Resolve(ctx, req.URL); err != nil {
+		logger.Debug().Err(err).Str("url", req.URL).Str("mode", req.Mode).Msg("error warming url")
+		return warmError
+	}
+	if rec.Meta().CacheResult == resultmeta.CacheHit {
+		return warmHit
+	}
+	return warmMiss
+}
+
+// readWarmInputs reads requests from each of the given paths (or stdin, if no
+// paths or "-" are given) and sends each distinct request to the reqs
+// channel.
+func readWarmInputs(ctx context.Context, paths []string, reqs chan<- warmRequest) error {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	seen := make(map[string]struct{})
+	for _, path := range paths {
+		if err := readWarmFile(ctx, path, seen, reqs); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func readWarmFile(ctx context.Context, path string, seen map[string]struct{}, reqs chan<- warmRequest) error {
+	if path == "-" {
+		return readWarmRequests(ctx, os.Stdin, seen, reqs)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readWarmRequests(ctx, f, seen, reqs)
+}
+
+func readWarmRequests(ctx context.Context, r io.Reader, seen map[string]struct{}, reqs chan<- warmRequest) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		req, ok := parseWarmLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		key := req.key()
+		if _, found := seen[key]; found {
+			continue
+		}
+		seen[key] = struct{}{}
+		select {
+		case reqs <- req:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+// parseWarmLine extracts the request to replay from a single line of input,
+// which may be either a URL optionally followed by resolve arguments in
+// "name=value" format (e.g. "https://t.co/abc mode=expand fetch_title=false")
+// or a JSON request log record whose url field contains a GET request to the
+// resolve endpoint.
+func parseWarmLine(line string) (warmRequest, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return warmRequest{}, false
+	}
+
+	var args url.Values
+	if strings.HasPrefix(line, "{") {
+		var rec struct {
+			Method string `json:"method"`
+			URL    string `json:"url"`
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Method != http.MethodGet {
+			return warmRequest{}, false
+		}
+		reqURL, err := url.Parse(rec.URL)
+		if err != nil || !strings.HasSuffix(reqURL.Path, "/resolve") {
+			return warmRequest{}, false
+		}
+		args = reqURL.Query()
+	} else {
+		fields := strings.Fields(line)
+		var err error
+		if args, err = url.ParseQuery(strings.Join(fields[1:], "&")); err != nil {
+			return warmRequest{}, false
+		}
+		args.Set("url", fields[0])
+	}
+	return parseWarmArgs(args)
+}
+
+// parseWarmArgs parses a request from resolve endpoint arguments, skipping
+// requests the server would reject.
+func parseWarmArgs(args url.Values) (warmRequest, bool) {
+	req := warmRequest{URL: args.Get("url"), Mode: args.Get("mode")}
+	if !httphandler.IsValidURL(req.URL) {
+		return warmRequest{}, false
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = httphandler.ModeFull
+	case httphandler.ModeFull, httphandler.ModeExpand:
+	default:
+		return warmRequest{}, false
+	}
+
+	optsReq, err := resolveopts.ParseRequest(args.Get("timeout"), args.Get("max_redirects"), args.Get("fetch_title"), args.Get("user_agent"))
+	if err != nil {
+		return warmRequest{}, false
+	}
+	if req.Opts, err = (resolveopts.Limits{}).Apply(optsReq); err != nil {
+		return warmRequest{}, false
+	}
+	return req, true
+}
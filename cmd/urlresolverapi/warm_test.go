@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+)
+
+func TestParseWarmLine(t *testing.T) {
+	t.Parallel()
+
+	noTitle := resolveopts.Default()
+	noTitle.FetchTitle = false
+
+	botTimeout := resolveopts.Default()
+	botTimeout.UserAgent = resolveopts.UserAgentBot
+	botTimeout.Timeout = 2 * time.Second
+	botTimeout.MaxRedirects = 3
+
+	testCases := map[string]struct {
+		line   string
+		want   warmRequest
+		wantOK bool
+	}{
+		"bare url": {
+			line:   "  https://t.co/abc123  ",
+			want:   warmRequest{URL: "https://t.co/abc123", Mode: httphandler.ModeFull, Opts: resolveopts.Default()},
+			wantOK: true,
+		},
+		"url with args": {
+			line:   "https://t.co/abc123  mode=expand fetch_title=false",
+			want:   warmRequest{URL: "https://t.co/abc123", Mode: httphandler.ModeExpand, Opts: noTitle},
+			wantOK: true,
+		},
+		"url with invalid arg": {
+			line: "https://t.co/abc123 fetch_title=maybe",
+		},
+		"url with invalid mode": {
+			line: "https://t.co/abc123 mode=shorten",
+		},
+		"request log record": {
+			line:   `{"level":"info","status":200,"url":"/resolve?url=https%3A%2F%2Fbit.ly%2Fxyz%3Fa%3D1","method":"GET"}`,
+			want:   warmRequest{URL: "https://bit.ly/xyz?a=1", Mode: httphandler.ModeFull, Opts: resolveopts.Default()},
+			wantOK: true,
+		},
+		"request log record with args": {
+			line:   `{"level":"info","status":200,"url":"/v1/resolve?url=https%3A%2F%2Fbit.ly%2Fxyz&user_agent=bot&timeout=2s&max_redirects=3","method":"GET"}`,
+			want:   warmRequest{URL: "https://bit.ly/xyz", Mode: httphandler.ModeFull, Opts: botTimeout},
+			wantOK: true,
+		},
+		"request log record with invalid mode": {
+			line: `{"level":"info","status":400,"url":"/v1/resolve?url=https%3A%2F%2Fbit.ly%2Fxyz&mode=shorten","method":"GET"}`,
+		},
+		"request log record without url arg": {
+			line: `{"level":"info","status":200,"url":"/resolve","method":"GET"}`,
+		},
+		"request log record for another endpoint": {
+			line: `{"level":"info","status":200,"url":"/healthz?url=https%3A%2F%2Fbit.ly%2Fxyz","method":"GET"}`,
+		},
+		"request log record for jobs endpoint": {
+			line: `{"level":"info","status":202,"url":"/v1/jobs?url=https%3A%2F%2Fbit.ly%2Fxyz","method":"POST"}`,
+		},
+		"request log record for non-GET request": {
+			line: `{"level":"info","status":200,"url":"/v1/resolve?url=https%3A%2F%2Fbit.ly%2Fxyz","method":"POST"}`,
+		},
+		"request log record without method": {
+			line: `{"level":"info","status":200,"url":"/v1/resolve?url=https%3A%2F%2Fbit.ly%2Fxyz"}`,
+		},
+		"invalid json": {
+			line: `{"url":`,
+		},
+		"blank line": {
+			line: "   ",
+		},
+		"comment": {
+			line: "# https://t.co/abc123",
+		},
+		"relative url": {
+			line: "/foo/bar",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := parseWarmLine(tc.line)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestReadWarmRequests(t *testing.T) {
+	t.Parallel()
+
+	input := strings.Join([]string{
+		"https://t.co/a",
+		`{"method":"GET","url":"/resolve?url=https%3A%2F%2Ft.co%2Fb"}`,
+		"not a url",
+		"https://t.co/a",
+		`{"method":"GET","url":"/resolve?url=https%3A%2F%2Ft.co%2Fa"}`,
+		"https://t.co/a timeout=1s",
+		"https://t.co/a fetch_title=false",
+		"https://t.co/a mode=expand",
+	}, "\n")
+
+	reqs := make(chan warmRequest, 10)
+	err := readWarmRequests(context.Background(), strings.NewReader(input), map[string]struct{}{}, reqs)
+	close(reqs)
+	assert.NoError(t, err)
+
+	var got []string
+	for req := range reqs {
+		got = append(got, req.key())
+	}
+	assert.Equal(t, []string{
+		"full https://t.co/a",
+		"full https://t.co/b",
+		"full https://t.co/a#notitle",
+		"expand https://t.co/a",
+	}, got, "requests for the same cache entry should be skipped")
+}
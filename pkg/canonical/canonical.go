@@ -0,0 +1,338 @@
+/*
+Package canonical maps AMP and mobile variants of pages (e.g. amp.example.com,
+example.com/amp/article, Google AMP cache URLs, and m.example.com) to the
+canonical URLs of the same pages, so that the variants of a page resolve to a
+single URL.
+
+Resolver maps each resolved URL in the following order:
+
+ 1. Google AMP cache URLs are decoded into the publisher URLs they serve
+ 2. if the final page was fetched, the URL looks like a variant, and the page
+    has a <link rel="canonical"> on the same site, the link is used
+ 3. otherwise, the first Rule matching the URL's host is applied
+
+Transport finds canonical links in the final response of each resolve:
+
+	transport = canonical.NewTransport(transport)
+	resolver := canonical.NewResolver(rules, urlresolver.New(transport, timeout))
+
+Rules are loaded from a file containing one directive per line, where each
+directive is a hostmatch pattern followed by a directive name and value:
+
+	# mobile and AMP hosts
+	m.example.com        host                www.example.com
+	amp.example.org      strip-host-prefix   amp
+
+	# AMP paths and query parameters
+	news.example         strip-path-segment  amp
+	news.example         strip-query         outputType
+	bbc.co.uk            strip-path-suffix   .amp
+
+The supported directives are:
+
+	host <host>                 replace the host
+	strip-host-prefix <label>   remove a leading label from the host
+	strip-path-segment <value>  remove path segments equal to value
+	strip-path-suffix <suffix>  remove a suffix from the path
+	strip-query <name>          remove a query parameter
+
+Every directive other than host may be given more than once. Directives for
+the same pattern are combined into a single Rule, and a host is governed by
+the first pattern in the file that matches it.
+*/
+package canonical
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/rulefile"
+)
+
+// ErrInvalidDirective is returned when a rules file contains an invalid
+// directive.
+var ErrInvalidDirective = errors.New("invalid directive")
+
+// Rule rewrites URLs on matching hosts into their canonical form.
+type Rule struct {
+	// Pattern determines which hosts the rule applies to.
+	Pattern hostmatch.Pattern
+
+	// Host replaces the host, if non-empty.
+	Host string
+
+	// StripHostPrefixes are leading host labels to remove, e.g. "m".
+	StripHostPrefixes []string
+
+	// StripPathSegments are path segments to remove, e.g. "amp".
+	StripPathSegments []string
+
+	// StripPathSuffixes are path suffixes to remove, e.g. ".amp".
+	StripPathSuffixes []string
+
+	// StripQuery are query parameters to remove, e.g. "outputType".
+	StripQuery []string
+}
+
+// Rewrite returns a rewritten copy of the given URL.
+func (r Rule) Rewrite(u *url.URL) *url.URL {
+	rewritten := *u
+	if r.Host != "" {
+		rewritten.Host = r.Host
+	}
+	for _, prefix := range r.StripHostPrefixes {
+		host := rewritten.Hostname()
+		// never strip a host down to a bare top level domain
+		if rest, ok := cutPrefixFold(host, prefix+"."); ok && strings.Contains(rest, ".") {
+			rewritten.Host = strings.Replace(rewritten.Host, host, rest, 1)
+		}
+	}
+
+	path := rewritten.Path
+	if len(r.StripPathSegments) > 0 {
+		segments := strings.Split(path, "/")
+		kept := segments[:0]
+		for i, segment := range segments {
+			if i == 0 || !containsFold(r.StripPathSegments, segment) {
+				kept = append(kept, segment)
+			}
+		}
+		path = strings.Join(kept, "/")
+	}
+	for _, suffix := range r.StripPathSuffixes {
+		if rest, ok := cutSuffixFold(path, suffix); ok {
+			path = rest
+		}
+	}
+	if path == "" && rewritten.Path != "" {
+		path = "/"
+	}
+	if path != rewritten.Path {
+		rewritten.Path = path
+		rewritten.RawPath = ""
+	}
+
+	if len(r.StripQuery) > 0 && rewritten.RawQuery != "" {
+		query := rewritten.Query()
+		for _, name := range r.StripQuery {
+			query.Del(name)
+		}
+		rewritten.RawQuery = query.Encode()
+	}
+	return &rewritten
+}
+
+// Rules is a set of Rules that may be reloaded at runtime.
+type Rules struct {
+	file *rulefile.File[[]Rule]
+}
+
+// New creates a new Rules from the given rules.
+func New(rules ...Rule) *Rules {
+	return &Rules{
+		file: rulefile.Static(rules),
+	}
+}
+
+// Load creates a new Rules from the directives in the file at path. The
+// rules may be reloaded later via Reload.
+func Load(path string) (*Rules, error) {
+	file, err := rulefile.Load(path, parseRules)
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{file: file}, nil
+}
+
+// Reload re-reads the rules from the file they were loaded from. If the
+// file cannot be read or parsed, the existing rules are kept.
+func (r *Rules) Reload() error {
+	return r.file.Reload()
+}
+
+// Find returns the first Rule whose pattern matches the given host.
+func (r *Rules) Find(host string) (Rule, bool) {
+	for _, rule := range r.file.Rules() {
+		if rule.Pattern.Match(host) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Rewrite rewrites the given URL according to the first Rule matching its
+// host, reporting whether the URL was changed.
+func (r *Rules) Rewrite(u *url.URL) (*url.URL, bool) {
+	rule, found := r.Find(u.Hostname())
+	if !found {
+		return u, false
+	}
+	rewritten := rule.Rewrite(u)
+	return rewritten, rewritten.String() != u.String()
+}
+
+func parseRules(r io.Reader) ([]Rule, error) {
+	var (
+		rules   []Rule
+		indexes = make(map[string]int)
+	)
+	err := rulefile.ScanDirectives(r, func(d rulefile.Directive) error {
+		// unlike other rule files, every canonical directive takes a
+		// single value
+		if strings.ContainsAny(d.Value, " \t") {
+			return fmt.Errorf("%w: %s value must not contain spaces: %q", ErrInvalidDirective, d.Name, d.Value)
+		}
+		pattern, err := hostmatch.Parse(d.Key)
+		if err != nil {
+			return err
+		}
+		idx, found := indexes[pattern.String()]
+		if !found {
+			idx = len(rules)
+			indexes[pattern.String()] = idx
+			rules = append(rules, Rule{Pattern: pattern})
+		}
+		return applyDirective(&rules[idx], d.Name, d.Value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func applyDirective(rule *Rule, name string, value string) error {
+	switch name {
+	case "host":
+		u, err := url.Parse("//" + value)
+		if err != nil || u.Host != value || u.Hostname() == "" {
+			return fmt.Errorf("%w: invalid host %q", ErrInvalidDirective, value)
+		}
+		rule.Host = value
+	case "strip-host-prefix":
+		label := strings.TrimSuffix(value, ".")
+		if label == "" || strings.Contains(label, ".") {
+			return fmt.Errorf("%w: host prefix must be a single label: %q", ErrInvalidDirective, value)
+		}
+		rule.StripHostPrefixes = append(rule.StripHostPrefixes, label)
+	case "strip-path-segment":
+		if strings.Contains(value, "/") {
+			return fmt.Errorf("%w: path segment must not contain \"/\": %q", ErrInvalidDirective, value)
+		}
+		rule.StripPathSegments = append(rule.StripPathSegments, value)
+	case "strip-path-suffix":
+		rule.StripPathSuffixes = append(rule.StripPathSuffixes, value)
+	case "strip-query":
+		rule.StripQuery = append(rule.StripQuery, value)
+	default:
+		return fmt.Errorf("%w: unknown directive %q", ErrInvalidDirective, name)
+	}
+	return nil
+}
+
+// variantHostLabels are leading host labels that identify AMP and mobile
+// hosts.
+var variantHostLabels = []string{"amp", "m", "mobile"}
+
+// looksLikeVariant reports whether a URL looks like the AMP or mobile
+// variant of a page, in which case its canonical link may be trusted.
+func looksLikeVariant(u *url.URL) bool {
+	labels := strings.Split(u.Hostname(), ".")
+	if len(labels) > 2 && containsFold(variantHostLabels, labels[0]) {
+		return true
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if strings.EqualFold(segment, "amp") {
+			return true
+		}
+	}
+	path := strings.ToLower(u.Path)
+	if strings.HasSuffix(path, ".amp") || strings.HasSuffix(path, ".amp.html") {
+		return true
+	}
+	query := u.Query()
+	return query.Has("amp") || strings.EqualFold(query.Get("outputType"), "amp")
+}
+
+// googleHostRe matches Google hosts that serve AMP pages under /amp/.
+var googleHostRe = regexp.MustCompile(`^(www\.)?google\.[a-z]{2,3}(\.[a-z]{2})?$`)
+
+// decodeAMPCache decodes a Google AMP cache URL into the URL of the
+// publisher's page it serves, e.g.
+//
+//	https://www-example-com.cdn.ampproject.org/c/s/www.example.com/amp/article
+//	https://www.google.com/amp/s/www.example.com/amp/article
+//
+// are both decoded into https://www.example.com/amp/article.
+func decodeAMPCache(u *url.URL) (*url.URL, bool) {
+	host := strings.ToLower(u.Hostname())
+	path := strings.TrimPrefix(u.EscapedPath(), "/")
+
+	var rest string
+	switch {
+	case strings.HasSuffix(host, ".cdn.ampproject.org"):
+		// the first segment identifies the kind of content, e.g. "c" for
+		// documents and "v" for the viewer
+		kind, r, ok := strings.Cut(path, "/")
+		if !ok || len(kind) != 1 {
+			return nil, false
+		}
+		rest = r
+	case googleHostRe.MatchString(host):
+		r, ok := strings.CutPrefix(path, "amp/")
+		if !ok {
+			return nil, false
+		}
+		rest = r
+	default:
+		return nil, false
+	}
+
+	// an "s" segment indicates that the page is served over https
+	scheme := "http"
+	if r, ok := strings.CutPrefix(rest, "s/"); ok {
+		scheme = "https"
+		rest = r
+	}
+	decoded, err := url.Parse(scheme + "://" + rest)
+	if err != nil || decoded.Hostname() == "" {
+		return nil, false
+	}
+
+	// the cache adds its own query parameters
+	query := u.Query()
+	for name := range query {
+		if strings.HasPrefix(name, "amp_") || name == "usqp" || name == "aoh" {
+			query.Del(name)
+		}
+	}
+	decoded.RawQuery = query.Encode()
+	return decoded, true
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+func cutSuffixFold(s, suffix string) (string, bool) {
+	if len(s) < len(suffix) || !strings.EqualFold(s[len(s)-len(suffix):], suffix) {
+		return s, false
+	}
+	return s[:len(s)-len(suffix)], true
+}
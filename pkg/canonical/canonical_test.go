@@ -0,0 +1,390 @@
+//nolint:errcheck
+package canonical
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+func writeRules(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "canonical.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestRewrite(t *testing.T) {
+	t.Parallel()
+
+	rules, err := Load(writeRules(t, `
+# comments and blank lines are ignored
+
+m.example.com     host                www.example.com
+*.example.org     strip-host-prefix   amp
+*.example.org     strip-host-prefix   m.
+news.example      strip-path-segment  amp
+news.example      strip-query         outputType
+news.example      strip-query         amp
+bbc.co.uk         strip-path-suffix   .amp
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := map[string]struct {
+		given string
+		want  string
+	}{
+		"host replaced": {
+			given: "https://m.example.com/article?id=1",
+			want:  "https://www.example.com/article?id=1",
+		},
+		"host prefix stripped": {
+			given: "https://amp.news.example.org/article",
+			want:  "https://news.example.org/article",
+		},
+		"host prefix stripped case insensitively": {
+			given: "https://M.Example.org/article",
+			want:  "https://Example.org/article",
+		},
+		"host prefix stripped down to registrable domain": {
+			given: "https://amp.example.org/article",
+			want:  "https://example.org/article",
+		},
+		"leading path segment stripped": {
+			given: "https://news.example/amp/2024/article",
+			want:  "https://news.example/2024/article",
+		},
+		"trailing path segment stripped": {
+			given: "https://www.news.example/2024/article/amp/",
+			want:  "https://www.news.example/2024/article/",
+		},
+		"only path segment stripped": {
+			given: "https://news.example/amp",
+			want:  "https://news.example/",
+		},
+		"query parameters stripped": {
+			given: "https://news.example/article?amp=1&id=2&outputType=amp",
+			want:  "https://news.example/article?id=2",
+		},
+		"path suffix stripped": {
+			given: "https://www.bbc.co.uk/news/world-123.amp",
+			want:  "https://www.bbc.co.uk/news/world-123",
+		},
+		"no matching rule": {
+			given: "https://amp.other.example/article/amp",
+			want:  "https://amp.other.example/article/amp",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			u := mustParseURL(t, tc.given)
+			rewritten, changed := rules.Rewrite(u)
+			assert.Equal(t, tc.want, rewritten.String())
+			assert.Equal(t, tc.want != tc.given, changed)
+			assert.Equal(t, tc.given, u.String(), "given URL must not be modified")
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"missing value":      "m.example.com host",
+		"extra value":        "m.example.com host www.example.com example.com",
+		"unknown directive":  "m.example.com redirect www.example.com",
+		"invalid pattern":    "[m.example.com host www.example.com",
+		"invalid host":       "m.example.com host https://www.example.com/",
+		"multi-label prefix": "example.com strip-host-prefix amp.m",
+		"segment with slash": "example.com strip-path-segment amp/v2",
+	}
+	for name, rules := range testCases {
+		rules := rules
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Load(writeRules(t, rules))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	path := writeRules(t, "m.example.com host www.example.com\n")
+	rules, err := Load(path)
+	assert.NoError(t, err)
+
+	u := mustParseURL(t, "https://m.example.org/")
+	_, changed := rules.Rewrite(u)
+	assert.False(t, changed)
+
+	os.WriteFile(path, []byte("m.example.org host www.example.org\n"), 0o600)
+	assert.NoError(t, rules.Reload())
+	_, changed = rules.Rewrite(u)
+	assert.True(t, changed)
+
+	// invalid rules are not applied
+	os.WriteFile(path, []byte("m.example.org host\n"), 0o600)
+	assert.Error(t, rules.Reload())
+	_, changed = rules.Rewrite(u)
+	assert.True(t, changed)
+}
+
+func TestDecodeAMPCache(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		given string
+		want  string
+	}{
+		"amp cache document": {
+			given: "https://www-example-com.cdn.ampproject.org/c/s/www.example.com/amp/article?id=1&amp_js_v=0.1&usqp=mq331AQ",
+			want:  "https://www.example.com/amp/article?id=1",
+		},
+		"amp cache viewer over http": {
+			given: "https://example-com.cdn.ampproject.org/v/example.com/article.amp.html",
+			want:  "http://example.com/article.amp.html",
+		},
+		"google amp viewer": {
+			given: "https://www.google.com/amp/s/www.example.co.uk/news/article.amp",
+			want:  "https://www.example.co.uk/news/article.amp",
+		},
+		"escaped path": {
+			given: "https://www.google.co.uk/amp/s/example.com/caf%C3%A9%2Fbar",
+			want:  "https://example.com/caf%C3%A9%2Fbar",
+		},
+		"not an amp cache": {
+			given: "https://www.example.com/amp/s/www.example.com/article",
+		},
+		"google without amp path": {
+			given: "https://www.google.com/search?q=amp",
+		},
+		"amp cache without page": {
+			given: "https://www-example-com.cdn.ampproject.org/c/s/",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			decoded, ok := decodeAMPCache(mustParseURL(t, tc.given))
+			assert.Equal(t, tc.want != "", ok)
+			if ok {
+				assert.Equal(t, tc.want, decoded.String())
+			}
+		})
+	}
+}
+
+func TestLooksLikeVariant(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]bool{
+		"https://amp.example.com/article":                true,
+		"https://m.example.co.uk/article":                true,
+		"https://mobile.example.com/":                    true,
+		"https://www.example.com/amp/article":            true,
+		"https://www.example.com/article/AMP":            true,
+		"https://www.example.com/article.amp":            true,
+		"https://www.example.com/article.amp.html":       true,
+		"https://www.example.com/article?amp":            true,
+		"https://www.example.com/article?outputType=amp": true,
+		"https://m.com/article":                          false,
+		"https://www.example.com/amplifier":              false,
+		"https://www.example.com/article":                false,
+		"https://example.com/article?utm_medium=amp":     false,
+	}
+	for given, want := range testCases {
+		given, want := given, want
+		t.Run(given, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, want, looksLikeVariant(mustParseURL(t, given)))
+		})
+	}
+}
+
+func TestFindCanonicalLink(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		html string
+		want string
+	}{
+		"in head": {
+			html: `<html><head><title>t</title><link rel="canonical" href=" https://example.com/a "></head>`,
+			want: "https://example.com/a",
+		},
+		"rel with multiple values": {
+			html: `<link rel="Canonical alternate" href="/a"/>`,
+			want: "/a",
+		},
+		"first link wins": {
+			html: `<link rel=stylesheet href=/s.css><link rel=canonical href=/a><link rel=canonical href=/b>`,
+			want: "/a",
+		},
+		"no href": {
+			html: `<link rel=canonical><link rel=canonical href=/b>`,
+			want: "/b",
+		},
+		"after head": {
+			html: `<head></head><link rel=canonical href=/a>`,
+		},
+		"in body": {
+			html: `<body><link rel=canonical href=/a>`,
+		},
+		"none": {
+			html: `<title>t</title>`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, findCanonicalLink(strings.NewReader(tc.html)))
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	// AMP pages with canonical links
+	mux.HandleFunc("/amp/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<head><title>AMP article</title><link rel="canonical" href="/article?utm_source=amp"></head>`))
+	})
+	mux.HandleFunc("/amp/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<head><title>AMP article</title><link rel="canonical" href="https://other.example/article"></head>`))
+	})
+	mux.HandleFunc("/amp/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/amp/article", http.StatusFound)
+	})
+	// an ordinary page, whose canonical link is not trusted
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<head><title>Article</title><link rel="canonical" href="/"></head>`))
+	})
+	// an AMP page without a canonical link
+	mux.HandleFunc("/news/amp", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>News</title>`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	rules := New(Rule{
+		Pattern:           hostmatch.MustParse(mustParseURL(t, srv.URL).Hostname()),
+		StripPathSegments: []string{"amp"},
+	})
+	resolver := NewResolver(rules, urlresolver.New(NewTransport(http.DefaultTransport), 0))
+
+	testCases := map[string]struct {
+		path             string
+		wantResult       urlresolver.Result
+		wantOriginalPath string
+	}{
+		"canonical link": {
+			path: "/amp/article",
+			wantResult: urlresolver.Result{
+				ResolvedURL: srv.URL + "/article",
+				Title:       "AMP article",
+			},
+			wantOriginalPath: "/amp/article",
+		},
+		"canonical link after redirect": {
+			path: "/amp/redirect",
+			wantResult: urlresolver.Result{
+				ResolvedURL:      srv.URL + "/article",
+				Title:            "AMP article",
+				IntermediateURLs: []string{srv.URL + "/amp/redirect"},
+			},
+			wantOriginalPath: "/amp/article",
+		},
+		"canonical link on another site falls back to rule": {
+			path: "/amp/elsewhere",
+			wantResult: urlresolver.Result{
+				ResolvedURL: srv.URL + "/elsewhere",
+				Title:       "AMP article",
+			},
+			wantOriginalPath: "/amp/elsewhere",
+		},
+		"no canonical link falls back to rule": {
+			path: "/news/amp",
+			wantResult: urlresolver.Result{
+				ResolvedURL: srv.URL + "/news",
+				Title:       "News",
+			},
+			wantOriginalPath: "/news/amp",
+		},
+		"not a variant": {
+			path: "/article",
+			wantResult: urlresolver.Result{
+				ResolvedURL: srv.URL + "/article",
+				Title:       "Article",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		ctx, rec := resultmeta.NewContext(context.Background())
+		result, err := resolver.Resolve(ctx, srv.URL+tc.path)
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.wantResult, result, name)
+		if tc.wantOriginalPath != "" {
+			assert.Equal(t, srv.URL+tc.wantOriginalPath, rec.Meta().OriginalResolvedURL, name)
+		} else {
+			assert.Equal(t, "", rec.Meta().OriginalResolvedURL, name)
+		}
+	}
+
+	t.Run("amp cache", func(t *testing.T) {
+		t.Parallel()
+
+		resolver := NewResolver(New(), nil)
+		cacheURL := "https://www-example-com.cdn.ampproject.org/c/s/www.example.com/amp/article"
+
+		got, source := resolver.canonicalize(cacheURL, link{})
+		assert.Equal(t, "https://www.example.com/amp/article", got)
+		assert.Equal(t, sourceAMPCache, source)
+
+		got, source = resolver.canonicalize(cacheURL, link{pageURL: cacheURL, href: "https://www.example.com/article"})
+		assert.Equal(t, "https://www.example.com/article", got)
+		assert.Equal(t, sourceLink, source)
+
+		// links found in other pages (e.g. a skipped interstitial) are
+		// ignored
+		got, _ = resolver.canonicalize(cacheURL, link{pageURL: "https://consent.example.com/", href: "https://www.example.com/article"})
+		assert.Equal(t, "https://www.example.com/amp/article", got)
+	})
+}
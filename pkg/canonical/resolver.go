@@ -0,0 +1,127 @@
+package canonical
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+// Sources of a canonical URL, for instrumentation purposes.
+const (
+	sourceAMPCache = "amp_cache"
+	sourceLink     = "link"
+	sourceRule     = "rule"
+)
+
+// Resolver is a urlresolver.Interface implementation that maps the resolved
+// URLs of AMP and mobile variants of pages to their canonical URLs, recording
+// the original resolved URL in the context's resultmeta.Recorder.
+type Resolver struct {
+	rules    *Rules
+	resolver urlresolver.Interface
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(rules *Rules, resolver urlresolver.Interface) *Resolver {
+	return &Resolver{
+		rules:    rules,
+		resolver: resolver,
+	}
+}
+
+// Resolve resolves a URL, mapping the result to its canonical URL.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	holder := &linkHolder{}
+	result, err := r.resolver.Resolve(context.WithValue(ctx, linkHolderKey, holder), givenURL)
+	if err != nil {
+		return result, err
+	}
+
+	canonicalURL, source := r.canonicalize(result.ResolvedURL, holder.get())
+	if canonicalURL == "" || canonicalURL == result.ResolvedURL {
+		return result, nil
+	}
+	beeline.AddField(ctx, "canonical.source", source)
+	beeline.AddField(ctx, "canonical.original_url", result.ResolvedURL)
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.OriginalResolvedURL = result.ResolvedURL })
+	result.ResolvedURL = canonicalURL
+	return result, nil
+}
+
+// canonicalize returns the canonical URL for a resolved URL, given the
+// canonical link found in the final page, along with its source. It
+// returns an empty string if the URL has no known canonical URL.
+func (r *Resolver) canonicalize(resolvedURL string, l link) (string, string) {
+	u, err := url.Parse(resolvedURL)
+	if err != nil {
+		return "", ""
+	}
+
+	var source string
+	if decoded, ok := decodeAMPCache(u); ok {
+		u = decoded
+		source = sourceAMPCache
+	}
+	rewritten, rewrite := r.rules.Rewrite(u)
+
+	// the link must have been found in the page at the resolved URL, rather
+	// than e.g. an interstitial that was skipped
+	if l.href != "" && l.pageURL == resolvedURL && (source != "" || rewrite || looksLikeVariant(u)) {
+		if linkURL, err := url.Parse(l.href); err == nil && hostmatch.SameSite(linkURL.Hostname(), u.Hostname()) {
+			if canonicalURL := urlresolver.Canonicalize(linkURL); canonicalURL != resolvedURL {
+				return canonicalURL, sourceLink
+			}
+		}
+	}
+
+	if rewrite {
+		u = rewritten
+		source = sourceRule
+	}
+	if source == "" {
+		return "", ""
+	}
+	return urlresolver.Canonicalize(u), source
+}
+
+// link is the canonical link found in the final page of a resolve.
+type link struct {
+	pageURL string
+	href    string
+}
+
+// linkHolder holds the link for the most recent final response made while
+// resolving a single URL.
+type linkHolder struct {
+	mu   sync.Mutex
+	link link
+}
+
+func (h *linkHolder) set(l link) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.link = l
+}
+
+func (h *linkHolder) get() link {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.link
+}
+
+type linkHolderKeyType int
+
+const linkHolderKey = linkHolderKeyType(1)
+
+// linkHolderFrom returns the linkHolder for the URL being resolved, or nil
+// if the request is not being made on behalf of a Resolver.
+func linkHolderFrom(ctx context.Context) *linkHolder {
+	holder, _ := ctx.Value(linkHolderKey).(*linkHolder)
+	return holder
+}
@@ -0,0 +1,112 @@
+package canonical
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/honeycombio/beeline-go"
+	"golang.org/x/net/html"
+
+	"github.com/mccutchen/urlresolver"
+
+	"github.com/mccutchen/urlresolverapi/pkg/response"
+)
+
+// maxHeadBytes limits how much of an HTML page is searched for a canonical
+// link, which belongs in the page's <head>.
+const maxHeadBytes = 256 << 10
+
+// Transport is an http.RoundTripper that finds the canonical link of every
+// final (i.e. non-redirect) HTML response made on behalf of a Resolver.
+//
+// The bytes read while searching for the link are replayed to the caller,
+// so titles may be extracted from the response as usual.
+type Transport struct {
+	transport http.RoundTripper
+}
+
+// NewTransport creates a new Transport.
+func NewTransport(transport http.RoundTripper) *Transport {
+	return &Transport{
+		transport: transport,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || response.IsRedirect(resp.StatusCode) {
+		return resp, err
+	}
+	holder := linkHolderFrom(req.Context())
+	if holder == nil {
+		return resp, nil
+	}
+
+	// a body that was never fetched (e.g. for a HEAD request, or because
+	// the title is not wanted) has no link to find
+	l := link{pageURL: urlresolver.Canonicalize(req.URL)}
+	if resp.Body != http.NoBody && isHTML(resp.Header.Get("Content-Type")) {
+		var buf bytes.Buffer
+		href := findCanonicalLink(io.TeeReader(io.LimitReader(resp.Body, maxHeadBytes), &buf))
+		resp.Body = readCloser{Reader: io.MultiReader(&buf, resp.Body), Closer: resp.Body}
+		if u, err := req.URL.Parse(href); href != "" && err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			l.href = u.String()
+			beeline.AddField(req.Context(), "canonical.link", l.href)
+		}
+	}
+	holder.set(l)
+	return resp, nil
+}
+
+// findCanonicalLink returns the href of the first <link rel="canonical">
+// in the <head> of an HTML document, if any.
+func findCanonicalLink(r io.Reader) string {
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return ""
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				return ""
+			case "link":
+				var rel, href string
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "rel":
+						rel = string(val)
+					case "href":
+						href = strings.TrimSpace(string(val))
+					}
+				}
+				if href != "" && containsFold(strings.Fields(rel), "canonical") {
+					return href
+				}
+			}
+		}
+	}
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// readCloser combines a reader wrapping a response body with the body's
+// Close method.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,379 @@
+/*
+Package client provides a client for a remote urlresolverapi instance, which
+implements urlresolver.Interface and so may be used as a drop-in replacement
+for an in-process resolver:
+
+	c := client.New("https://api.urlresolver.com", client.WithToken(token))
+	result, err := c.Resolve(ctx, "https://t.co/1AuEh8FMK0?amp=1")
+
+As with an in-process resolver, an error does not necessarily mean that the
+result is empty: when the API returns a partial result (i.e. a 203
+Non-Authoritative Information response), both the partial result and an error
+are returned. Errors reported by the API are mapped back onto the errors
+defined in this package (e.g. ErrRequestTimeout), so they may be checked with
+errors.Is. Rejected requests are reported as an *APIError carrying the
+problem code and request ID from the response.
+
+Requests that are rate limited or that fail with a server error are retried
+with exponential backoff, honoring any Retry-After header.
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
+)
+
+// Errors returned along with partial results, matching the errors reported
+// by the API.
+var (
+	ErrBlockedURL     = errors.New("blocked URL")
+	ErrRequestTimeout = errors.New("request timeout")
+	ErrResolveError   = errors.New("resolve error")
+	ErrUnsafeURL      = errors.New("unsafe URL")
+)
+
+// Errors returned for failed requests.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServerError  = errors.New("server error")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Resolve modes.
+const (
+	ModeFull   = "full"
+	ModeExpand = "expand"
+)
+
+// Defaults for a Client.
+const (
+	DefaultMaxRetries       = 3
+	DefaultRetryBackoff     = 250 * time.Millisecond
+	DefaultMaxRetryWait     = 10 * time.Second
+	DefaultBatchConcurrency = 4
+)
+
+// Option customizes a Client.
+type Option func(*Client)
+
+// WithToken sets the auth token sent with every request.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to make requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithMode sets the resolve mode (e.g. ModeExpand) used for every request.
+func WithMode(mode string) Option {
+	return func(c *Client) {
+		c.mode = mode
+	}
+}
+
+// WithMaxRetries sets the max number of times a failed request is retried.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// WithRetryBackoff sets the delay before the first retry of a failed
+// request, which doubles after each subsequent attempt.
+func WithRetryBackoff(d time.Duration) Option {
+	return func(c *Client) {
+		c.retryBackoff = d
+	}
+}
+
+// WithMaxRetryWait sets the max delay before a retry, including delays
+// requested by the server via Retry-After. Requests asking for longer delays
+// are not retried.
+func WithMaxRetryWait(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetryWait = d
+	}
+}
+
+// WithBatchConcurrency sets the number of requests ResolveBatch may make
+// concurrently.
+func WithBatchConcurrency(n int) Option {
+	return func(c *Client) {
+		c.batchConcurrency = n
+	}
+}
+
+// Client resolves URLs using a remote urlresolverapi instance.
+type Client struct {
+	baseURL          string
+	token            string
+	mode             string
+	httpClient       *http.Client
+	maxRetries       int
+	retryBackoff     time.Duration
+	maxRetryWait     time.Duration
+	batchConcurrency int
+}
+
+var _ urlresolver.Interface = &Client{} // Client implements urlresolver.Interface
+
+// New creates a new Client for the urlresolverapi instance at the given base
+// URL.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:          strings.TrimSuffix(baseURL, "/"),
+		httpClient:       http.DefaultClient,
+		maxRetries:       DefaultMaxRetries,
+		retryBackoff:     DefaultRetryBackoff,
+		maxRetryWait:     DefaultMaxRetryWait,
+		batchConcurrency: DefaultBatchConcurrency,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.batchConcurrency < 1 {
+		c.batchConcurrency = 1
+	}
+	return c
+}
+
+// Resolve resolves a URL using the remote API.
+func (c *Client) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	params := url.Values{"url": {givenURL}}
+	if c.mode != "" {
+		params.Set("mode", c.mode)
+	}
+	reqURL := c.baseURL + "/v1/resolve?" + params.Encode()
+
+	for attempt := 0; ; attempt++ {
+		result, retry, retryAfter, err := c.do(ctx, reqURL)
+		if !retry || attempt >= c.maxRetries {
+			return result, err
+		}
+
+		wait := c.retryBackoff << attempt
+		if retryAfter > 0 {
+			wait = retryAfter
+		} else {
+			// add jitter to avoid synchronized retries across clients
+			wait += time.Duration(rand.Int64N(int64(wait)/2 + 1))
+		}
+		if wait > c.maxRetryWait {
+			return result, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, ctx.Err()
+		}
+	}
+}
+
+// do makes a single request, returning the result, whether the request may
+// be retried along with any delay requested by the server, and any error.
+func (c *Client) do(ctx context.Context, reqURL string) (result urlresolver.Result, retry bool, retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return urlresolver.Result{}, false, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return urlresolver.Result{}, false, 0, err
+		}
+		return urlresolver.Result{}, true, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return urlresolver.Result{}, true, 0, fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNonAuthoritativeInfo {
+		var rr resolveResponse
+		if err := json.Unmarshal(body, &rr); err != nil {
+			return urlresolver.Result{}, false, 0, fmt.Errorf("error decoding response: %w", err)
+		}
+		result = urlresolver.Result{
+			ResolvedURL:      rr.ResolvedURL,
+			Title:            rr.Title,
+			IntermediateURLs: rr.IntermediateURLs,
+		}
+		if rr.Error != "" || rr.ErrorCode != "" {
+			err = parseError(rr.ErrorCode, rr.Error)
+		}
+		return result, false, 0, err
+	}
+
+	apiErr := newAPIError(resp, body)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return result, true, parseRetryAfter(resp.Header.Get("Retry-After")), apiErr
+	default:
+		return result, false, 0, apiErr
+	}
+}
+
+// resolveResponse is the subset of the API's resolve response used by the
+// client.
+type resolveResponse struct {
+	ResolvedURL      string   `json:"resolved_url"`
+	Title            string   `json:"title"`
+	IntermediateURLs []string `json:"intermediate_urls"`
+	Error            string   `json:"error"`
+	ErrorCode        string   `json:"error_code"`
+}
+
+// APIError describes a request rejected by the API, as reported in an RFC
+// 7807 problem details response. It wraps one of ErrBadRequest,
+// ErrRateLimited, ErrServerError, or ErrUnauthorized.
+type APIError struct {
+	Status    int
+	Code      string
+	Detail    string
+	RequestID string
+
+	err error
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	var p problem.Problem
+	_ = json.Unmarshal(body, &p)
+
+	apiErr := &APIError{
+		Status:    resp.StatusCode,
+		Code:      p.Code,
+		Detail:    p.Detail,
+		RequestID: p.RequestID,
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get(requestid.Header)
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.err = ErrRateLimited
+	case resp.StatusCode >= 500:
+		apiErr.err = ErrServerError
+	case resp.StatusCode == http.StatusForbidden:
+		apiErr.err = ErrUnauthorized
+	default:
+		apiErr.err = ErrBadRequest
+	}
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	msg := e.err.Error()
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// BatchResult is the result of resolving a single URL via ResolveBatch.
+type BatchResult struct {
+	GivenURL string
+	Result   urlresolver.Result
+	Err      error
+}
+
+// ResolveBatch resolves multiple URLs concurrently, returning the results in
+// the same order as the given URLs.
+func (c *Client) ResolveBatch(ctx context.Context, urls []string) []BatchResult {
+	results := make([]BatchResult, len(urls))
+	sem := make(chan struct{}, c.batchConcurrency)
+	var wg sync.WaitGroup
+	for i, givenURL := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, givenURL string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result, err := c.Resolve(ctx, givenURL)
+			results[i] = BatchResult{GivenURL: givenURL, Result: result, Err: err}
+		}(i, givenURL)
+	}
+	wg.Wait()
+	return results
+}
+
+// parseError maps the error code (or, for older servers, the error string)
+// from a partial result back onto one of the errors defined above.
+func parseError(code string, msg string) error {
+	switch code {
+	case problem.CodeBlockedURL:
+		return ErrBlockedURL
+	case problem.CodeUpstreamTimeout:
+		return ErrRequestTimeout
+	case problem.CodeResolveError:
+		return ErrResolveError
+	case problem.CodeUnsafeURL:
+		return ErrUnsafeURL
+	}
+	for _, err := range []error{
+		ErrBlockedURL,
+		ErrRequestTimeout,
+		ErrResolveError,
+		ErrUnsafeURL,
+	} {
+		if msg == err.Error() {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrResolveError, msg)
+}
+
+// parseRetryAfter parses a Retry-After header given in either seconds or as
+// an HTTP date, returning 0 if the header is missing or invalid.
+func parseRetryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(val); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
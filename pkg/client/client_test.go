@@ -0,0 +1,256 @@
+//nolint:errcheck
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
+)
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		handler    http.HandlerFunc
+		opts       []Option
+		wantResult urlresolver.Result
+		wantErr    error
+		wantCalls  int64
+	}{
+		"ok": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v1/resolve", r.URL.Path)
+				assert.Equal(t, "https://t.co/abc", r.URL.Query().Get("url"))
+				assert.Equal(t, "", r.URL.Query().Get("mode"))
+				w.Write([]byte(`{"given_url": "https://t.co/abc", "resolved_url": "https://example.com/", "title": "title", "intermediate_urls": ["https://t.co/abc"]}`))
+			},
+			wantResult: urlresolver.Result{
+				ResolvedURL:      "https://example.com/",
+				Title:            "title",
+				IntermediateURLs: []string{"https://t.co/abc"},
+			},
+			wantCalls: 1,
+		},
+		"token and mode are sent": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+				assert.Equal(t, "expand", r.URL.Query().Get("mode"))
+				w.Write([]byte(`{"resolved_url": "https://example.com/"}`))
+			},
+			opts:       []Option{WithToken("secret"), WithMode(ModeExpand)},
+			wantResult: urlresolver.Result{ResolvedURL: "https://example.com/"},
+			wantCalls:  1,
+		},
+		"partial result returns typed error": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNonAuthoritativeInfo)
+				w.Write([]byte(`{"resolved_url": "https://example.com/", "error": "request timeout", "error_code": "upstream_timeout"}`))
+			},
+			wantResult: urlresolver.Result{ResolvedURL: "https://example.com/"},
+			wantErr:    ErrRequestTimeout,
+			wantCalls:  1,
+		},
+		"partial result without error code falls back to error string": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNonAuthoritativeInfo)
+				w.Write([]byte(`{"resolved_url": "https://example.com/", "error": "request timeout"}`))
+			},
+			wantResult: urlresolver.Result{ResolvedURL: "https://example.com/"},
+			wantErr:    ErrRequestTimeout,
+			wantCalls:  1,
+		},
+		"unknown partial result error maps to resolve error": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNonAuthoritativeInfo)
+				w.Write([]byte(`{"resolved_url": "https://example.com/", "error": "something new"}`))
+			},
+			wantResult: urlresolver.Result{ResolvedURL: "https://example.com/"},
+			wantErr:    ErrResolveError,
+			wantCalls:  1,
+		},
+		"bad request not retried": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", problem.ContentType)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status": 400, "code": "invalid_url", "detail": "Invalid url"}`))
+			},
+			wantErr:   ErrBadRequest,
+			wantCalls: 1,
+		},
+		"unauthorized not retried": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", problem.ContentType)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"status": 403, "code": "unauthorized"}`))
+			},
+			wantErr:   ErrUnauthorized,
+			wantCalls: 1,
+		},
+		"server errors retried until max retries": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			opts:      []Option{WithMaxRetries(2), WithRetryBackoff(time.Millisecond)},
+			wantErr:   ErrServerError,
+			wantCalls: 3,
+		},
+		"rate limited with retry after too long not retried": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			opts:      []Option{WithMaxRetryWait(time.Second)},
+			wantErr:   ErrRateLimited,
+			wantCalls: 1,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&calls, 1)
+				tc.handler(w, r)
+			}))
+			defer srv.Close()
+
+			result, err := New(srv.URL+"/", tc.opts...).Resolve(context.Background(), "https://t.co/abc")
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantResult, result)
+			assert.Equal(t, tc.wantCalls, atomic.LoadInt64(&calls))
+		})
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Send(w, r.WithContext(requestid.NewContext(r.Context(), "request-id")), http.StatusBadRequest, problem.CodeInvalidURL, "Invalid url")
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL).Resolve(context.Background(), "https://t.co/abc")
+	var apiErr *APIError
+	if !assert.ErrorAs(t, err, &apiErr) {
+		return
+	}
+	assert.ErrorIs(t, err, ErrBadRequest)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+	assert.Equal(t, problem.CodeInvalidURL, apiErr.Code)
+	assert.Equal(t, "Invalid url", apiErr.Detail)
+	assert.Equal(t, "request-id", apiErr.RequestID)
+	assert.Equal(t, "bad request: invalid_url: Invalid url", err.Error())
+}
+
+func TestResolveRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var calls int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"resolved_url": "https://example.com/"}`))
+	}))
+	defer srv.Close()
+
+	// the server's requested delay is used instead of the (much longer)
+	// backoff
+	start := time.Now()
+	result, err := New(srv.URL, WithRetryBackoff(time.Hour), WithMaxRetryWait(2*time.Hour)).Resolve(context.Background(), "https://t.co/abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/", result.ResolvedURL)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&calls))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestResolveContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := New(srv.URL, WithRetryBackoff(time.Second)).Resolve(ctx, "https://t.co/abc")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestResolveBatch(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		givenURL := r.URL.Query().Get("url")
+		if givenURL == "https://error.example/" {
+			w.WriteHeader(http.StatusNonAuthoritativeInfo)
+			w.Write([]byte(`{"resolved_url": "https://error.example/", "error": "unsafe URL", "error_code": "unsafe_url"}`))
+			return
+		}
+		w.Write([]byte(`{"resolved_url": "` + givenURL + `"}`))
+	}))
+	defer srv.Close()
+
+	urls := []string{"https://a.example/", "https://error.example/", "https://c.example/"}
+	results := New(srv.URL, WithBatchConcurrency(2)).ResolveBatch(context.Background(), urls)
+	if !assert.Len(t, results, 3) {
+		return
+	}
+	for i, result := range results {
+		assert.Equal(t, urls[i], result.GivenURL)
+		assert.Equal(t, urls[i], result.Result.ResolvedURL)
+	}
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrUnsafeURL)
+	assert.NoError(t, results[2].Err)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("nonsense"))
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2"))
+	d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.Greater(t, d, 50*time.Second)
+}
+
+// TestServerCompatibility ensures that the client's errors and modes match
+// the server's, without the client depending on the server's packages.
+func TestServerCompatibility(t *testing.T) {
+	t.Parallel()
+
+	for clientErr, serverErr := range map[error]error{
+		ErrBlockedURL:     httphandler.ErrBlockedURL,
+		ErrRequestTimeout: httphandler.ErrRequestTimeout,
+		ErrResolveError:   httphandler.ErrResolveError,
+		ErrUnsafeURL:      httphandler.ErrUnsafeURL,
+	} {
+		assert.Equal(t, serverErr.Error(), clientErr.Error())
+		assert.Equal(t, clientErr, parseError(httphandler.ErrorCode(serverErr), ""))
+	}
+	assert.Equal(t, httphandler.ModeFull, ModeFull)
+	assert.Equal(t, httphandler.ModeExpand, ModeExpand)
+}
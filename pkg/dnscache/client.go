@@ -0,0 +1,246 @@
+package dnscache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// errNotFound indicates that a query found no records, either because the
+// name does not exist or because it has no records of the requested type.
+var errNotFound = errors.New("no such host")
+
+// client is a minimal DNS client that queries recursive resolvers directly,
+// so that the TTLs of the records it finds are available. Unlike the
+// standard library's resolver, it does not consult /etc/hosts or apply
+// search domains.
+type client struct {
+	servers []string
+	timeout time.Duration
+}
+
+// lookup looks up a host's IPv4 and IPv6 addresses concurrently, returning
+// them along with the lowest TTL of the records found.
+func (c *client) lookup(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host}
+	}
+
+	type result struct {
+		addrs []net.IPAddr
+		ttl   time.Duration
+		err   error
+	}
+	qtypes := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make([]chan result, len(qtypes))
+	for i, qtype := range qtypes {
+		results[i] = make(chan result, 1)
+		go func(ch chan<- result, qtype dnsmessage.Type) {
+			addrs, ttl, err := c.query(ctx, name, qtype)
+			ch <- result{addrs, ttl, err}
+		}(results[i], qtype)
+	}
+
+	var (
+		addrs   []net.IPAddr
+		ttl     = time.Duration(math.MaxInt64)
+		lastErr error
+	)
+	for _, ch := range results {
+		res := <-ch
+		if res.err != nil {
+			if !errors.Is(res.err, errNotFound) {
+				lastErr = res.err
+			}
+			continue
+		}
+		addrs = append(addrs, res.addrs...)
+		ttl = min(ttl, res.ttl)
+	}
+
+	switch {
+	case len(addrs) > 0:
+		return addrs, ttl, nil
+	case lastErr != nil:
+		return nil, 0, &net.DNSError{Err: lastErr.Error(), Name: host, IsTemporary: true, IsTimeout: isTimeout(lastErr)}
+	default:
+		return nil, 0, &net.DNSError{Err: errNotFound.Error(), Name: host, IsNotFound: true}
+	}
+}
+
+// query looks up records of the given type, trying each server in turn
+// until one answers.
+func (c *client) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]net.IPAddr, time.Duration, error) {
+	var lastErr error
+	for _, server := range c.servers {
+		resp, err := c.exchange(ctx, server, name, qtype)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		switch resp.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, 0, errNotFound
+		default:
+			lastErr = fmt.Errorf("server %s: %s", server, resp.RCode)
+			continue
+		}
+
+		var (
+			addrs []net.IPAddr
+			ttl   = time.Duration(math.MaxInt64)
+		)
+		for _, answer := range resp.Answers {
+			// the TTLs of any CNAME records also bound the result
+			ttl = min(ttl, time.Duration(answer.Header.TTL)*time.Second)
+			switch body := answer.Body.(type) {
+			case *dnsmessage.AResource:
+				if qtype == dnsmessage.TypeA {
+					addrs = append(addrs, net.IPAddr{IP: net.IPv4(body.A[0], body.A[1], body.A[2], body.A[3])})
+				}
+			case *dnsmessage.AAAAResource:
+				if qtype == dnsmessage.TypeAAAA {
+					addrs = append(addrs, net.IPAddr{IP: net.IP(body.AAAA[:])})
+				}
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, 0, errNotFound
+		}
+		return addrs, ttl, nil
+	}
+	return nil, 0, lastErr
+}
+
+// exchange sends a single query to a server over UDP, falling back to TCP
+// if the response is truncated.
+func (c *client) exchange(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	id := uint16(rand.Uint32())
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	resp, err := c.exchangeUDP(ctx, server, id, packed)
+	if err == nil && resp.Truncated {
+		resp, err = c.exchangeTCP(ctx, server, id, packed)
+	}
+	return resp, err
+}
+
+func (c *client) exchangeUDP(ctx context.Context, server string, id uint16, packed []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var resp dnsmessage.Message
+		// ignore malformed or mismatched responses, which may be spoofed
+		if err := resp.Unpack(buf[:n]); err != nil || resp.ID != id || !resp.Response {
+			continue
+		}
+		return &resp, nil
+	}
+}
+
+func (c *client) exchangeTCP(ctx context.Context, server string, id uint16, packed []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	msg := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(msg, uint16(len(packed)))
+	copy(msg[2:], packed)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if resp.ID != id {
+		return nil, fmt.Errorf("server %s: mismatched response ID", server)
+	}
+	return &resp, nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// systemServers returns the nameservers configured in /etc/resolv.conf,
+// falling back to a resolver on localhost.
+func systemServers() []string {
+	var servers []string
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53", "[::1]:53"}
+	}
+	return servers
+}
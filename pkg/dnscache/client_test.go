@@ -0,0 +1,187 @@
+package dnscache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDNSServer starts a DNS server on UDP and TCP that answers queries
+// using the given handler, returning its address.
+func newDNSServer(t *testing.T, handler func(q dnsmessage.Question, tcp bool) dnsmessage.Message) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	respond := func(buf []byte, tcp bool) []byte {
+		var query dnsmessage.Message
+		if err := query.Unpack(buf); err != nil || len(query.Questions) != 1 {
+			return nil
+		}
+		resp := handler(query.Questions[0], tcp)
+		resp.ID = query.ID
+		resp.Response = true
+		resp.Questions = query.Questions
+		packed, err := resp.Pack()
+		if err != nil {
+			t.Error(err)
+		}
+		return packed
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if packed := respond(buf[:n], false); packed != nil {
+				_, _ = pc.WriteTo(packed, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var length uint16
+			if err := binary.Read(conn, binary.BigEndian, &length); err == nil {
+				buf := make([]byte, length)
+				if _, err := io.ReadFull(conn, buf); err == nil {
+					packed := respond(buf, true)
+					out := make([]byte, 2+len(packed))
+					binary.BigEndian.PutUint16(out, uint16(len(packed)))
+					copy(out[2:], packed)
+					_, _ = conn.Write(out)
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func answer(q dnsmessage.Question, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	typ := q.Type
+	switch body.(type) {
+	case *dnsmessage.CNAMEResource:
+		typ = dnsmessage.TypeCNAME
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: q.Name, Type: typ, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   body,
+	}
+}
+
+func TestClientLookup(t *testing.T) {
+	t.Parallel()
+
+	server := newDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		var msg dnsmessage.Message
+		switch q.Name.String() {
+		case "example.com.":
+			if q.Type == dnsmessage.TypeA {
+				msg.Answers = []dnsmessage.Resource{
+					answer(q, 300, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}}),
+				}
+			} else {
+				msg.Answers = []dnsmessage.Resource{
+					answer(q, 120, &dnsmessage.AAAAResource{AAAA: [16]byte{0x26, 0x06, 0x28, 0x00, 15: 0x01}}),
+				}
+			}
+		case "alias.example.com.":
+			// the TTL of the CNAME record bounds the result
+			if q.Type == dnsmessage.TypeA {
+				msg.Answers = []dnsmessage.Resource{
+					answer(q, 30, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("example.com.")}),
+					answer(q, 300, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}}),
+				}
+			}
+		case "big.example.com.":
+			// truncated over UDP, so the client must retry over TCP
+			if !tcp {
+				msg.Truncated = true
+			} else if q.Type == dnsmessage.TypeA {
+				msg.Answers = []dnsmessage.Resource{
+					answer(q, 60, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 35}}),
+				}
+			}
+		case "broken.example.com.":
+			msg.RCode = dnsmessage.RCodeServerFailure
+		default:
+			msg.RCode = dnsmessage.RCodeNameError
+		}
+		return msg
+	})
+	c := &client{servers: []string{server}, timeout: time.Second}
+
+	addrs, ttl, err := c.lookup(context.Background(), "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, ipAddrs("93.184.216.34", "2606:2800::1"), addrs)
+	assert.Equal(t, 120*time.Second, ttl)
+
+	addrs, ttl, err = c.lookup(context.Background(), "alias.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, ipAddrs("93.184.216.34"), addrs)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	addrs, ttl, err = c.lookup(context.Background(), "big.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, ipAddrs("93.184.216.35"), addrs)
+	assert.Equal(t, 60*time.Second, ttl)
+
+	var dnsErr *net.DNSError
+	_, _, err = c.lookup(context.Background(), "missing.example.com")
+	assert.True(t, errors.As(err, &dnsErr) && dnsErr.IsNotFound, "expected not found error, got %v", err)
+
+	_, _, err = c.lookup(context.Background(), "broken.example.com")
+	assert.True(t, errors.As(err, &dnsErr) && dnsErr.IsTemporary && !dnsErr.IsNotFound, "expected temporary error, got %v", err)
+}
+
+func TestClientFailover(t *testing.T) {
+	t.Parallel()
+
+	// a server that never answers
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	server := newDNSServer(t, func(q dnsmessage.Question, _ bool) dnsmessage.Message {
+		var msg dnsmessage.Message
+		if q.Type == dnsmessage.TypeA {
+			msg.Answers = []dnsmessage.Resource{answer(q, 60, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}})}
+		}
+		return msg
+	})
+
+	c := &client{servers: []string{pc.LocalAddr().String(), server}, timeout: 50 * time.Millisecond}
+	addrs, _, err := c.lookup(context.Background(), "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, ipAddrs("93.184.216.34"), addrs)
+
+	c = &client{servers: []string{pc.LocalAddr().String()}, timeout: 50 * time.Millisecond}
+	_, _, err = c.lookup(context.Background(), "example.com")
+	var dnsErr *net.DNSError
+	assert.True(t, errors.As(err, &dnsErr) && dnsErr.IsTimeout && dnsErr.IsTemporary, "expected timeout error, got %v", err)
+}
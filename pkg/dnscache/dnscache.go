@@ -0,0 +1,241 @@
+/*
+Package dnscache implements an in-process caching DNS resolver, so that
+popular hosts are not looked up again for every new connection.
+
+Unlike the standard library's resolver, lookups are made with a minimal DNS
+client that exposes record TTLs, which are respected within configurable
+bounds. Negative results are also cached, and concurrent lookups of the same
+host are coalesced.
+
+A Cache is typically used as the resolver for a pinning.Dialer, which
+validates every address it returns before connecting:
+
+	cache := dnscache.New(dnscache.Options{})
+	dialer := pinning.NewDialer(cache, safedialer.Control)
+	transport := &http.Transport{DialContext: dialer.DialContext}
+
+Lookups report their progress via the httptrace.ClientTrace DNSStart and
+DNSDone hooks, just like the standard library's resolver, along with any
+LookupTrace in the context.
+*/
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Defaults for Options.
+const (
+	DefaultMinTTL      = 5 * time.Second
+	DefaultMaxTTL      = 5 * time.Minute
+	DefaultNegativeTTL = 30 * time.Second
+	DefaultMaxEntries  = 10000
+	DefaultTimeout     = 2 * time.Second
+)
+
+// Options configure a Cache.
+type Options struct {
+	// MinTTL and MaxTTL bound how long successful lookups are cached,
+	// regardless of the TTLs of the DNS records.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// NegativeTTL is how long lookups of nonexistent hosts are cached.
+	NegativeTTL time.Duration
+
+	// MaxEntries is the maximum number of hosts cached.
+	MaxEntries int
+
+	// Servers are the addresses of the DNS servers to query, in host:port
+	// form. Defaults to the nameservers in /etc/resolv.conf.
+	Servers []string
+
+	// Timeout bounds each query made to a DNS server.
+	Timeout time.Duration
+}
+
+// LookupInfo describes the outcome of a single lookup.
+type LookupInfo struct {
+	// CacheHit indicates whether the result came from the cache.
+	CacheHit bool
+
+	// Coalesced indicates whether the result was shared with another
+	// concurrent lookup of the same host.
+	Coalesced bool
+
+	// TTL is how much longer the result will be cached.
+	TTL time.Duration
+}
+
+// LookupTrace is called with the outcome of every lookup made via a Cache,
+// before the httptrace.ClientTrace DNSDone hook.
+type LookupTrace func(LookupInfo)
+
+// WithLookupTrace returns a new context that will call the given
+// LookupTrace.
+func WithLookupTrace(ctx context.Context, trace LookupTrace) context.Context {
+	return context.WithValue(ctx, lookupTraceKey, trace)
+}
+
+type lookupTraceKeyType int
+
+const lookupTraceKey = lookupTraceKeyType(1)
+
+// lookupFunc looks up a host's IP addresses, returning them along with the
+// TTL of the result.
+type lookupFunc func(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error)
+
+// Cache is a caching DNS resolver.
+type Cache struct {
+	lookup lookupFunc
+	opts   Options
+	group  singleflight.Group
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+// New creates a new Cache.
+func New(opts Options) *Cache {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if len(opts.Servers) == 0 {
+		opts.Servers = systemServers()
+	}
+	client := &client{servers: opts.Servers, timeout: opts.Timeout}
+	return newCache(client.lookup, opts)
+}
+
+func newCache(lookup lookupFunc, opts Options) *Cache {
+	if opts.MinTTL <= 0 {
+		opts.MinTTL = DefaultMinTTL
+	}
+	if opts.MaxTTL <= 0 {
+		opts.MaxTTL = DefaultMaxTTL
+	}
+	if opts.MaxTTL < opts.MinTTL {
+		opts.MaxTTL = opts.MinTTL
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = DefaultNegativeTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	return &Cache{
+		lookup:  lookup,
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string]entry),
+	}
+}
+
+// LookupIPAddr looks up a host's IP addresses, using a cached result if
+// one is available.
+func (c *Cache) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+
+	var info LookupInfo
+	e, hit := c.get(host)
+	if !hit {
+		// the lookup must not be canceled just because the first of several
+		// coalesced callers gives up
+		ch := c.group.DoChan(host, func() (interface{}, error) {
+			return c.fill(context.WithoutCancel(ctx), host), nil
+		})
+		select {
+		case res := <-ch:
+			e = res.Val.(entry)
+			info.Coalesced = res.Shared
+		case <-ctx.Done():
+			e = entry{err: &net.DNSError{Err: ctx.Err().Error(), Name: host, IsTimeout: true}}
+		}
+	}
+	info.CacheHit = hit
+	if !e.expires.IsZero() {
+		info.TTL = e.expires.Sub(c.now())
+	}
+
+	if lookupTrace, ok := ctx.Value(lookupTraceKey).(LookupTrace); ok {
+		lookupTrace(info)
+	}
+	if trace != nil && trace.DNSDone != nil {
+		addrs := make([]net.IPAddr, len(e.addrs))
+		copy(addrs, e.addrs)
+		trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: e.err, Coalesced: info.Coalesced})
+	}
+	return e.addrs, e.err
+}
+
+func (c *Cache) get(host string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[host]
+	if !found || !c.now().Before(e.expires) {
+		return entry{}, false
+	}
+	return e, true
+}
+
+// fill looks up a host and caches the result, unless the lookup failed
+// with a temporary error.
+func (c *Cache) fill(ctx context.Context, host string) entry {
+	addrs, ttl, err := c.lookup(ctx, host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return entry{addrs: addrs, err: err}
+		}
+		ttl = c.opts.NegativeTTL
+	} else {
+		ttl = min(max(ttl, c.opts.MinTTL), c.opts.MaxTTL)
+	}
+
+	e := entry{addrs: addrs, err: err, expires: c.now().Add(ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.opts.MaxEntries {
+		c.evict()
+	}
+	c.entries[host] = e
+	return e
+}
+
+// evict removes expired entries, falling back to removing arbitrary
+// entries if the cache is still full. Must be called with the lock held.
+func (c *Cache) evict() {
+	now := c.now()
+	for host, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, host)
+		}
+	}
+	for host := range c.entries {
+		if len(c.entries) < c.opts.MaxEntries {
+			break
+		}
+		delete(c.entries, host)
+	}
+}
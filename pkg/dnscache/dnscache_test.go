@@ -0,0 +1,190 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLookup returns canned results for each host, counting lookups.
+type fakeLookup struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	results map[string]fakeResult
+	delay   time.Duration
+}
+
+type fakeResult struct {
+	addrs []net.IPAddr
+	ttl   time.Duration
+	err   error
+}
+
+func (f *fakeLookup) lookup(_ context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[host]++
+	r := f.results[host]
+	return r.addrs, r.ttl, r.err
+}
+
+func (f *fakeLookup) count(host string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[host]
+}
+
+func ipAddrs(ips ...string) []net.IPAddr {
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs
+}
+
+func TestCacheTTLs(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeLookup{results: map[string]fakeResult{
+		"short.example":   {addrs: ipAddrs("93.184.216.34"), ttl: time.Second},
+		"long.example":    {addrs: ipAddrs("93.184.216.35"), ttl: time.Hour},
+		"normal.example":  {addrs: ipAddrs("93.184.216.36"), ttl: time.Minute},
+		"missing.example": {err: &net.DNSError{Err: "no such host", IsNotFound: true}},
+		"flaky.example":   {err: &net.DNSError{Err: "server misbehaving", IsTemporary: true}},
+	}}
+	now := time.Now()
+	cache := newCache(fake.lookup, Options{MinTTL: 10 * time.Second, MaxTTL: 5 * time.Minute, NegativeTTL: 30 * time.Second})
+	cache.now = func() time.Time { return now }
+
+	testCases := []struct {
+		host    string
+		wantTTL time.Duration
+	}{
+		{"short.example", 10 * time.Second},   // raised to the floor
+		{"long.example", 5 * time.Minute},     // lowered to the ceiling
+		{"normal.example", time.Minute},       // record TTL respected
+		{"missing.example", 30 * time.Second}, // negative results cached
+	}
+	for _, tc := range testCases {
+		for i := 0; i < 3; i++ {
+			_, _ = cache.LookupIPAddr(context.Background(), tc.host)
+		}
+		assert.Equal(t, 1, fake.count(tc.host), tc.host)
+
+		now = now.Add(tc.wantTTL - time.Millisecond)
+		_, _ = cache.LookupIPAddr(context.Background(), tc.host)
+		assert.Equal(t, 1, fake.count(tc.host), "%s: expected cached result before TTL", tc.host)
+
+		now = now.Add(time.Millisecond)
+		_, _ = cache.LookupIPAddr(context.Background(), tc.host)
+		assert.Equal(t, 2, fake.count(tc.host), "%s: expected new lookup after TTL", tc.host)
+	}
+
+	// negative results are returned as errors from the cache
+	_, err := cache.LookupIPAddr(context.Background(), "missing.example")
+	var dnsErr *net.DNSError
+	assert.True(t, errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+
+	// temporary failures are not cached
+	for i := 0; i < 3; i++ {
+		_, err := cache.LookupIPAddr(context.Background(), "flaky.example")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 3, fake.count("flaky.example"))
+
+	// IP addresses are never looked up
+	addrs, err := cache.LookupIPAddr(context.Background(), "93.184.216.34")
+	assert.NoError(t, err)
+	assert.Equal(t, ipAddrs("93.184.216.34"), addrs)
+	assert.Equal(t, 0, fake.count("93.184.216.34"))
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeLookup{
+		results: map[string]fakeResult{"example.com": {addrs: ipAddrs("93.184.216.34"), ttl: time.Minute}},
+		delay:   50 * time.Millisecond,
+	}
+	cache := newCache(fake.lookup, Options{})
+
+	var (
+		wg        sync.WaitGroup
+		coalesced atomic.Int32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := WithLookupTrace(context.Background(), func(info LookupInfo) {
+				if info.Coalesced {
+					coalesced.Add(1)
+				}
+			})
+			addrs, err := cache.LookupIPAddr(ctx, "example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, ipAddrs("93.184.216.34"), addrs)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, fake.count("example.com"))
+	assert.Greater(t, coalesced.Load(), int32(0))
+}
+
+func TestCacheTrace(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeLookup{results: map[string]fakeResult{"example.com": {addrs: ipAddrs("93.184.216.34"), ttl: time.Minute}}}
+	cache := newCache(fake.lookup, Options{})
+
+	var events []string
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) { events = append(events, "start "+info.Host) },
+		DNSDone:  func(info httptrace.DNSDoneInfo) { events = append(events, "done "+info.Addrs[0].String()) },
+	})
+	var infos []LookupInfo
+	ctx = WithLookupTrace(ctx, func(info LookupInfo) {
+		events = append(events, "lookup")
+		infos = append(infos, info)
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.LookupIPAddr(ctx, "example.com")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{
+		"start example.com", "lookup", "done 93.184.216.34",
+		"start example.com", "lookup", "done 93.184.216.34",
+	}, events)
+	if assert.Len(t, infos, 2) {
+		assert.False(t, infos[0].CacheHit)
+		assert.True(t, infos[1].CacheHit)
+		assert.InDelta(t, time.Minute, infos[1].TTL, float64(time.Second))
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeLookup{results: map[string]fakeResult{
+		"a.example": {addrs: ipAddrs("93.184.216.1")},
+		"b.example": {addrs: ipAddrs("93.184.216.2")},
+		"c.example": {addrs: ipAddrs("93.184.216.3")},
+	}}
+	cache := newCache(fake.lookup, Options{MaxEntries: 2})
+	for _, host := range []string{"a.example", "b.example", "c.example"} {
+		_, _ = cache.LookupIPAddr(context.Background(), host)
+	}
+	assert.Len(t, cache.entries, 2)
+}
@@ -0,0 +1,188 @@
+/*
+Package domainrules implements per-domain overrides of how URLs are resolved,
+for sites that need special handling (e.g. sites that block browser-like
+user agents, or that show a consent interstitial unless a specific
+Accept-Language is sent).
+
+Rules are loaded from a file containing one directive per line, where each
+directive is a hostmatch pattern followed by a directive name and value:
+
+	# this site is slow, give it longer than everyone else
+	slow.example     timeout     20s
+
+	# this site blocks browser user agents and needs a language
+	news.example     user-agent  urlresolverapi (+https://github.com/mccutchen/urlresolverapi)
+	news.example     header      Accept-Language: en-US,en;q=0.9
+	news.example     cookies     on
+
+	# titles on this site are useless
+	cdn.example      title       off
+
+	# everything else
+	*                timeout     5s
+
+The supported directives are:
+
+	timeout <duration>     bound the time spent resolving URLs on the domain
+	user-agent <value>     send this User-Agent header
+	header <name>: <value> send an extra header (may be given more than once)
+	cookies on|off         send cookies set by earlier redirect hops
+	title on|off           whether to fetch the title of pages on the domain
+
+Directives for the same pattern are combined into a single Rule. A host is
+governed by the first pattern in the file that matches it, so more specific
+patterns should come first.
+
+Timeouts are applied via Resolver, based on the host of the URL being
+resolved, and may not exceed the server's overall request timeout. The other
+directives are applied via Transport, based on the host of each redirect hop.
+*/
+package domainrules
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/rulefile"
+)
+
+// ErrInvalidDirective is returned when a rules file contains an invalid
+// directive.
+var ErrInvalidDirective = errors.New("invalid directive")
+
+// Rule overrides how URLs on matching domains are resolved.
+type Rule struct {
+	// Pattern determines which hosts the rule applies to.
+	Pattern hostmatch.Pattern
+
+	// Timeout bounds the time spent resolving a URL, if non-zero.
+	Timeout time.Duration
+
+	// UserAgent overrides the User-Agent header, if non-empty.
+	UserAgent string
+
+	// Header holds extra headers sent with every request.
+	Header http.Header
+
+	// Cookies indicates whether cookies set by earlier redirect hops are
+	// sent with subsequent requests.
+	Cookies bool
+
+	// SkipTitle indicates that the title of pages should not be fetched.
+	SkipTitle bool
+}
+
+// Rules is a set of Rules that may be reloaded at runtime.
+type Rules struct {
+	file *rulefile.File[[]Rule]
+}
+
+// New creates a new Rules from the given rules.
+func New(rules ...Rule) *Rules {
+	return &Rules{
+		file: rulefile.Static(rules),
+	}
+}
+
+// Load creates a new Rules from the directives in the file at path. The
+// rules may be reloaded later via Reload.
+func Load(path string) (*Rules, error) {
+	file, err := rulefile.Load(path, parseRules)
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{file: file}, nil
+}
+
+// Reload re-reads the rules from the file they were loaded from. If the
+// file cannot be read or parsed, the existing rules are kept.
+func (r *Rules) Reload() error {
+	return r.file.Reload()
+}
+
+// Find returns the first Rule matching the given host.
+func (r *Rules) Find(host string) (Rule, bool) {
+	for _, rule := range r.file.Rules() {
+		if rule.Pattern.Match(host) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func parseRules(r io.Reader) ([]Rule, error) {
+	var (
+		rules   []Rule
+		indexes = make(map[string]int)
+	)
+	err := rulefile.ScanDirectives(r, func(d rulefile.Directive) error {
+		pattern, err := hostmatch.Parse(d.Key)
+		if err != nil {
+			return err
+		}
+		idx, found := indexes[pattern.String()]
+		if !found {
+			idx = len(rules)
+			indexes[pattern.String()] = idx
+			rules = append(rules, Rule{Pattern: pattern})
+		}
+		return applyDirective(&rules[idx], d.Name, d.Value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func applyDirective(rule *Rule, name string, value string) error {
+	switch name {
+	case "timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("%w: timeout must be a positive duration: %q", ErrInvalidDirective, value)
+		}
+		rule.Timeout = timeout
+	case "user-agent":
+		rule.UserAgent = value
+	case "header":
+		key, val, err := rulefile.ParseHeader(value)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidDirective, err)
+		}
+		if rule.Header == nil {
+			rule.Header = make(http.Header)
+		}
+		rule.Header.Add(key, val)
+	case "cookies":
+		on, err := parseSwitch(value)
+		if err != nil {
+			return err
+		}
+		rule.Cookies = on
+	case "title":
+		on, err := parseSwitch(value)
+		if err != nil {
+			return err
+		}
+		rule.SkipTitle = !on
+	default:
+		return fmt.Errorf("%w: unknown directive %q", ErrInvalidDirective, name)
+	}
+	return nil
+}
+
+func parseSwitch(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: value must be \"on\" or \"off\": %q", ErrInvalidDirective, value)
+	}
+}
@@ -0,0 +1,206 @@
+//nolint:errcheck
+package domainrules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	rules, err := Load(writeRules(t, `
+# comments and blank lines are ignored
+
+news.example  user-agent  test agent/1.0
+news.example  header      accept-language: en-US,en;q=0.9
+news.example  header      X-Extra: 1
+news.example  cookies     on
+timeout.example  timeout  20s
+cdn.example   title       off
+*             timeout     5s
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rule, found := rules.Find("www.news.example")
+	assert.True(t, found)
+	assert.Equal(t, "news.example", rule.Pattern.String())
+	assert.Equal(t, "test agent/1.0", rule.UserAgent)
+	assert.Equal(t, http.Header{
+		"Accept-Language": {"en-US,en;q=0.9"},
+		"X-Extra":         {"1"},
+	}, rule.Header)
+	assert.True(t, rule.Cookies)
+	assert.False(t, rule.SkipTitle)
+	assert.Equal(t, time.Duration(0), rule.Timeout)
+
+	rule, _ = rules.Find("timeout.example")
+	assert.Equal(t, 20*time.Second, rule.Timeout)
+
+	rule, _ = rules.Find("cdn.example")
+	assert.True(t, rule.SkipTitle)
+
+	// the first matching pattern wins
+	rule, found = rules.Find("example.com")
+	assert.True(t, found)
+	assert.Equal(t, "*", rule.Pattern.String())
+	assert.Equal(t, 5*time.Second, rule.Timeout)
+
+	_, found = New().Find("example.com")
+	assert.False(t, found)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"missing value":     "example.com timeout",
+		"unknown directive": "example.com retries 3",
+		"invalid pattern":   "[example.com timeout 5s",
+		"invalid timeout":   "example.com timeout soon",
+		"negative timeout":  "example.com timeout -5s",
+		"invalid header":    "example.com header X-Foo",
+		"invalid switch":    "example.com cookies yes",
+	}
+	for name, rules := range testCases {
+		rules := rules
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Load(writeRules(t, rules))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "does-not-exist"))
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	path := writeRules(t, "example.com timeout 1s")
+	rules, err := Load(path)
+	assert.NoError(t, err)
+	rule, _ := rules.Find("example.com")
+	assert.Equal(t, time.Second, rule.Timeout)
+
+	assert.NoError(t, os.WriteFile(path, []byte("example.com timeout 2s"), 0o644))
+	assert.NoError(t, rules.Reload())
+	rule, _ = rules.Find("example.com")
+	assert.Equal(t, 2*time.Second, rule.Timeout)
+
+	// invalid rules are rejected and the existing rules are kept
+	assert.NoError(t, os.WriteFile(path, []byte("nope"), 0o644))
+	assert.Error(t, rules.Reload())
+	rule, _ = rules.Find("example.com")
+	assert.Equal(t, 2*time.Second, rule.Timeout)
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/consent":
+			// a consent interstitial that sets a cookie and redirects
+			http.SetCookie(w, &http.Cookie{Name: "consent", Value: "yes", Path: "/"})
+			http.Redirect(w, r, "/article", http.StatusFound)
+		case "/article":
+			if _, err := r.Cookie("consent"); err != nil {
+				w.Write([]byte(`<title>no consent</title>`))
+				return
+			}
+			w.Write([]byte(`<title>` + r.UserAgent() + ` ` + r.Header.Get("Accept-Language") + `</title>`))
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			w.Write([]byte(`<title>slow</title>`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	newResolver := func(rules *Rules) urlresolver.Interface {
+		return NewResolver(rules, urlresolver.New(NewTransport(rules, http.DefaultTransport), 0))
+	}
+
+	t.Run("headers and cookies", func(t *testing.T) {
+		t.Parallel()
+		resolver := newResolver(New(Rule{
+			Pattern:   hostmatch.MustParse("127.0.0.1"),
+			UserAgent: "test-agent",
+			Header:    http.Header{"Accept-Language": {"en-US"}},
+			Cookies:   true,
+		}))
+		result, err := resolver.Resolve(context.Background(), srv.URL+"/consent")
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/article", result.ResolvedURL)
+		assert.Equal(t, "test-agent en-US", result.Title)
+	})
+
+	t.Run("cookies off", func(t *testing.T) {
+		t.Parallel()
+		resolver := newResolver(New(Rule{Pattern: hostmatch.MustParse("127.0.0.1")}))
+		result, err := resolver.Resolve(context.Background(), srv.URL+"/consent")
+		assert.NoError(t, err)
+		assert.Equal(t, "no consent", result.Title)
+	})
+
+	t.Run("skip title", func(t *testing.T) {
+		t.Parallel()
+		resolver := newResolver(New(Rule{
+			Pattern:   hostmatch.MustParse("127.0.0.1"),
+			Cookies:   true,
+			SkipTitle: true,
+		}))
+		result, err := resolver.Resolve(context.Background(), srv.URL+"/consent")
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/article", result.ResolvedURL)
+		assert.Equal(t, "", result.Title)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		resolver := newResolver(New(Rule{
+			Pattern: hostmatch.MustParse("127.0.0.1"),
+			Timeout: 50 * time.Millisecond,
+		}))
+		start := time.Now()
+		_, err := resolver.Resolve(context.Background(), srv.URL+"/slow")
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("no matching rule", func(t *testing.T) {
+		t.Parallel()
+		resolver := newResolver(New(Rule{
+			Pattern:   hostmatch.MustParse("example.com"),
+			SkipTitle: true,
+		}))
+		result, err := resolver.Resolve(context.Background(), srv.URL+"/article")
+		assert.NoError(t, err)
+		assert.Equal(t, "no consent", result.Title)
+	})
+}
+
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,60 @@
+package domainrules
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+// Resolver is a urlresolver.Interface implementation that applies the
+// timeout from the Rule matching the host of the URL being resolved, and
+// tracks the cookies set while resolving so that Transport may send them on
+// subsequent redirect hops.
+type Resolver struct {
+	rules    *Rules
+	resolver urlresolver.Interface
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(rules *Rules, resolver urlresolver.Interface) *Resolver {
+	return &Resolver{
+		rules:    rules,
+		resolver: resolver,
+	}
+}
+
+// Resolve resolves a URL according to the matching Rule.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	if parsed, err := url.Parse(givenURL); err == nil {
+		if rule, found := r.rules.Find(parsed.Hostname()); found {
+			beeline.AddField(ctx, "domain_rules.rule", rule.Pattern.String())
+			if rule.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, rule.Timeout)
+				defer cancel()
+			}
+		}
+	}
+
+	// cookies are only shared between the requests made while resolving a
+	// single URL
+	jar, _ := cookiejar.New(nil)
+	ctx = context.WithValue(ctx, cookieJarKey, jar)
+	return r.resolver.Resolve(ctx, givenURL)
+}
+
+type cookieJarKeyType int
+
+const cookieJarKey = cookieJarKeyType(1)
+
+// cookieJar returns the cookie jar for the URL being resolved, or nil if
+// the request is not being made on behalf of a Resolver.
+func cookieJar(ctx context.Context) http.CookieJar {
+	jar, _ := ctx.Value(cookieJarKey).(http.CookieJar)
+	return jar
+}
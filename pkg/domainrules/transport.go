@@ -0,0 +1,73 @@
+package domainrules
+
+import (
+	"net/http"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolverapi/pkg/response"
+)
+
+// Transport is an http.RoundTripper that applies the Rule matching each
+// request's host, so a redirect to another domain is governed by that
+// domain's rule rather than the original URL's.
+//
+// Transport should be wrapped by any transport that sets default request
+// headers, so that its headers take precedence.
+type Transport struct {
+	rules     *Rules
+	transport http.RoundTripper
+}
+
+// NewTransport creates a new Transport that applies the given Rules before
+// passing requests on to the given transport.
+func NewTransport(rules *Rules, transport http.RoundTripper) *Transport {
+	return &Transport{
+		rules:     rules,
+		transport: transport,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule, found := t.rules.Find(req.URL.Hostname())
+	if !found {
+		return t.transport.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	beeline.AddField(ctx, "domain_rules.transport_rule", rule.Pattern.String())
+
+	req = req.Clone(ctx)
+	if rule.UserAgent != "" {
+		req.Header.Set("User-Agent", rule.UserAgent)
+	}
+	for key, values := range rule.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+
+	jar := cookieJar(ctx)
+	if rule.Cookies && jar != nil {
+		for _, cookie := range jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if rule.Cookies && jar != nil {
+		if cookies := resp.Cookies(); len(cookies) > 0 {
+			jar.SetCookies(req.URL, cookies)
+		}
+	}
+
+	// If the title is not wanted, there's no need to download the body of
+	// the final response.
+	if rule.SkipTitle && !response.IsRedirect(resp.StatusCode) {
+		response.DropBody(resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,276 @@
+/*
+Package grpcserver provides a gRPC service that resolves URLs, the gRPC
+counterpart to the httphandler package.
+
+The service is defined in urlresolverpb/urlresolver.proto, with unary Resolve
+and BatchResolve RPCs and a server-streaming StreamResolve RPC. As with the
+HTTP API, an error during resolution does not fail the RPC: instead, a
+partial result is returned with its error field set.
+
+Regenerate the protobuf code after changing the service definition with
+`make generate`.
+*/
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/honeycombio/beeline-go"
+	"github.com/peterbourgon/ctxdata/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/grpcserver/urlresolverpb"
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler"
+)
+
+// Defaults for batch requests.
+const (
+	DefaultMaxBatchSize     = 100
+	DefaultBatchConcurrency = 4
+)
+
+// Option customizes a Server.
+type Option func(*Server)
+
+// WithExpandResolver sets the resolver used for mode=expand requests. If not
+// set, mode=expand requests are rejected.
+func WithExpandResolver(resolver urlresolver.Interface) Option {
+	return func(s *Server) {
+		s.expandResolver = resolver
+	}
+}
+
+// WithMaxBatchSize sets the max number of URLs in a single batch request.
+func WithMaxBatchSize(n int) Option {
+	return func(s *Server) {
+		s.maxBatchSize = n
+	}
+}
+
+// WithBatchConcurrency sets the number of URLs within a single batch request
+// that may be resolved concurrently.
+func WithBatchConcurrency(n int) Option {
+	return func(s *Server) {
+		s.batchConcurrency = n
+	}
+}
+
+// Server implements the URLResolver gRPC service.
+type Server struct {
+	urlresolverpb.UnimplementedURLResolverServer
+
+	resolver         urlresolver.Interface
+	expandResolver   urlresolver.Interface
+	maxBatchSize     int
+	batchConcurrency int
+}
+
+var _ urlresolverpb.URLResolverServer = &Server{} // Server implements urlresolverpb.URLResolverServer
+
+// New creates a new Server.
+func New(resolver urlresolver.Interface, opts ...Option) *Server {
+	s := &Server{
+		resolver:         resolver,
+		maxBatchSize:     DefaultMaxBatchSize,
+		batchConcurrency: DefaultBatchConcurrency,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.batchConcurrency < 1 {
+		s.batchConcurrency = 1
+	}
+	return s
+}
+
+// Resolve resolves a single URL.
+func (s *Server) Resolve(ctx context.Context, req *urlresolverpb.ResolveRequest) (*urlresolverpb.ResolveResponse, error) {
+	resolver, err := s.resolverForMode(ctx, req.GetMode())
+	if err != nil {
+		return nil, err
+	}
+	if err := validateURL(ctx, req.GetUrl()); err != nil {
+		return nil, err
+	}
+	resp, err := s.resolve(ctx, resolver, req.GetUrl())
+	if err != nil {
+		return nil, err
+	}
+	beeline.AddField(ctx, "intermediate_url_count", len(resp.IntermediateUrls))
+	return resp, nil
+}
+
+// BatchResolve resolves multiple URLs, returning the results in the same
+// order as the given URLs.
+func (s *Server) BatchResolve(ctx context.Context, req *urlresolverpb.BatchResolveRequest) (*urlresolverpb.BatchResolveResponse, error) {
+	resolver, err := s.validateBatch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*urlresolverpb.ResolveResponse, len(req.GetUrls()))
+	err = s.resolveBatch(ctx, resolver, req.GetUrls(), func(i int, resp *urlresolverpb.ResolveResponse) error {
+		results[i] = resp
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &urlresolverpb.BatchResolveResponse{Results: results}, nil
+}
+
+// StreamResolve resolves multiple URLs, sending each result as soon as it is
+// available.
+func (s *Server) StreamResolve(req *urlresolverpb.BatchResolveRequest, stream urlresolverpb.URLResolver_StreamResolveServer) error {
+	ctx := stream.Context()
+	resolver, err := s.validateBatch(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// streams are not safe for concurrent use, so sends are serialized
+	var mu sync.Mutex
+	return s.resolveBatch(ctx, resolver, req.GetUrls(), func(_ int, resp *urlresolverpb.ResolveResponse) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return stream.Send(resp)
+	})
+}
+
+func (s *Server) validateBatch(ctx context.Context, req *urlresolverpb.BatchResolveRequest) (urlresolver.Interface, error) {
+	resolver, err := s.resolverForMode(ctx, req.GetMode())
+	if err != nil {
+		return nil, err
+	}
+	urls := req.GetUrls()
+	if len(urls) == 0 {
+		return nil, invalidArgument(ctx, httphandler.ErrMissingURL, "Missing arg urls")
+	}
+	if s.maxBatchSize > 0 && len(urls) > s.maxBatchSize {
+		return nil, invalidArgument(ctx, fmt.Errorf("too many urls: %d", len(urls)), fmt.Sprintf("Too many urls, max %d", s.maxBatchSize))
+	}
+	for _, givenURL := range urls {
+		if err := validateURL(ctx, givenURL); err != nil {
+			return nil, err
+		}
+	}
+	beeline.AddField(ctx, "batch_size", len(urls))
+	return resolver, nil
+}
+
+// resolveBatch resolves each URL with bounded concurrency, calling the given
+// func with each result. It stops early if the func returns an error or the
+// context is done.
+func (s *Server) resolveBatch(ctx context.Context, resolver urlresolver.Interface, urls []string, handle func(int, *urlresolverpb.ResolveResponse) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, s.batchConcurrency)
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for i, givenURL := range urls {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, givenURL string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := s.resolve(ctx, resolver, givenURL)
+			if err == nil {
+				err = handle(i, resp)
+			}
+			if err != nil {
+				fail(err)
+			}
+		}(i, givenURL)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+func (s *Server) resolve(ctx context.Context, resolver urlresolver.Interface, givenURL string) (*urlresolverpb.ResolveResponse, error) {
+	// As in the HTTP handler, an error may still come with a useful partial
+	// result, so only a canceled request fails outright.
+	result, err := resolver.Resolve(ctx, givenURL)
+
+	resp := &urlresolverpb.ResolveResponse{
+		GivenUrl:         givenURL,
+		ResolvedUrl:      result.ResolvedURL,
+		Title:            result.Title,
+		IntermediateUrls: result.IntermediateURLs,
+	}
+	if err != nil {
+		d := ctxdata.From(ctx)
+		if errors.Is(err, context.Canceled) {
+			_ = d.Set("error", fmt.Errorf("client closed connection: %w", err))
+			return nil, status.FromContextError(err).Err()
+		}
+		_ = d.Set("error", fmt.Errorf("error resolving url: %w", err))
+		resp.Error = httphandler.MapError(err).Error()
+		resp.ErrorCode = httphandler.ErrorCode(err)
+	}
+	return resp, nil
+}
+
+func (s *Server) resolverForMode(ctx context.Context, mode string) (urlresolver.Interface, error) {
+	if mode == "" {
+		mode = httphandler.ModeFull
+	}
+	beeline.AddField(ctx, "resolve_mode", mode)
+
+	var resolver urlresolver.Interface
+	switch mode {
+	case httphandler.ModeFull:
+		resolver = s.resolver
+	case httphandler.ModeExpand:
+		resolver = s.expandResolver
+	}
+	if resolver == nil {
+		return nil, invalidArgument(ctx, fmt.Errorf("%w: %s", httphandler.ErrInvalidMode, mode), "Invalid mode")
+	}
+	return resolver, nil
+}
+
+func validateURL(ctx context.Context, givenURL string) error {
+	if givenURL == "" {
+		return invalidArgument(ctx, httphandler.ErrMissingURL, "Missing arg url")
+	}
+	if !httphandler.IsValidURL(givenURL) {
+		return invalidArgument(ctx, fmt.Errorf("%w: %s", httphandler.ErrInvalidURL, givenURL), "Invalid url: "+givenURL)
+	}
+	return nil
+}
+
+// invalidArgument records the given error and returns an InvalidArgument
+// status with the given message.
+func invalidArgument(ctx context.Context, err error, msg string) error {
+	_ = ctxdata.From(ctx).Set("error", err)
+	return status.Error(codes.InvalidArgument, msg)
+}
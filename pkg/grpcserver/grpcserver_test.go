@@ -0,0 +1,187 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/grpcserver/urlresolverpb"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+)
+
+// stubResolver resolves every URL to itself with a fixed title prefix,
+// except for the error URL.
+type stubResolver struct {
+	title string
+}
+
+func (r stubResolver) Resolve(_ context.Context, givenURL string) (urlresolver.Result, error) {
+	if givenURL == "https://error.example/" {
+		return urlresolver.Result{ResolvedURL: givenURL}, errors.New("resolve error")
+	}
+	return urlresolver.Result{
+		ResolvedURL:      givenURL,
+		Title:            r.title + givenURL,
+		IntermediateURLs: []string{givenURL},
+	}, nil
+}
+
+func newTestClient(t *testing.T, srv *Server) urlresolverpb.URLResolverClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer()
+	urlresolverpb.RegisterURLResolverServer(gs, srv)
+	go gs.Serve(lis) //nolint:errcheck
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return urlresolverpb.NewURLResolverClient(conn)
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, New(stubResolver{title: "full "}, WithExpandResolver(stubResolver{title: "expand "})))
+
+	testCases := map[string]struct {
+		req      *urlresolverpb.ResolveRequest
+		want     *urlresolverpb.ResolveResponse
+		wantCode codes.Code
+	}{
+		"ok": {
+			req: &urlresolverpb.ResolveRequest{Url: "https://example.com/"},
+			want: &urlresolverpb.ResolveResponse{
+				GivenUrl:         "https://example.com/",
+				ResolvedUrl:      "https://example.com/",
+				Title:            "full https://example.com/",
+				IntermediateUrls: []string{"https://example.com/"},
+			},
+		},
+		"expand mode": {
+			req: &urlresolverpb.ResolveRequest{Url: "https://example.com/", Mode: "expand"},
+			want: &urlresolverpb.ResolveResponse{
+				GivenUrl:         "https://example.com/",
+				ResolvedUrl:      "https://example.com/",
+				Title:            "expand https://example.com/",
+				IntermediateUrls: []string{"https://example.com/"},
+			},
+		},
+		"resolve errors return partial result": {
+			req: &urlresolverpb.ResolveRequest{Url: "https://error.example/"},
+			want: &urlresolverpb.ResolveResponse{
+				GivenUrl:    "https://error.example/",
+				ResolvedUrl: "https://error.example/",
+				Error:       "resolve error",
+				ErrorCode:   problem.CodeResolveError,
+			},
+		},
+		"missing url": {
+			req:      &urlresolverpb.ResolveRequest{},
+			wantCode: codes.InvalidArgument,
+		},
+		"invalid url": {
+			req:      &urlresolverpb.ResolveRequest{Url: "path/to/foo"},
+			wantCode: codes.InvalidArgument,
+		},
+		"invalid mode": {
+			req:      &urlresolverpb.ResolveRequest{Url: "https://example.com/", Mode: "foo"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			resp, err := client.Resolve(context.Background(), tc.req)
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			if tc.want != nil {
+				assert.Equal(t, tc.want.String(), resp.String())
+			}
+		})
+	}
+}
+
+func TestBatchResolve(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, New(stubResolver{}, WithMaxBatchSize(3), WithBatchConcurrency(2)))
+
+	t.Run("results in order", func(t *testing.T) {
+		t.Parallel()
+		urls := []string{"https://a.example/", "https://error.example/", "https://c.example/"}
+		resp, err := client.BatchResolve(context.Background(), &urlresolverpb.BatchResolveRequest{Urls: urls})
+		assert.NoError(t, err)
+		if !assert.Len(t, resp.GetResults(), 3) {
+			return
+		}
+		for i, result := range resp.GetResults() {
+			assert.Equal(t, urls[i], result.GetGivenUrl())
+		}
+		assert.Equal(t, "resolve error", resp.GetResults()[1].GetError())
+		assert.Equal(t, problem.CodeResolveError, resp.GetResults()[1].GetErrorCode())
+		assert.Empty(t, resp.GetResults()[0].GetErrorCode())
+	})
+
+	errorCases := map[string]*urlresolverpb.BatchResolveRequest{
+		"no urls":       {},
+		"too many urls": {Urls: []string{"https://a.example/", "https://b.example/", "https://c.example/", "https://d.example/"}},
+		"invalid url":   {Urls: []string{"https://a.example/", "path/to/foo"}},
+		"invalid mode":  {Urls: []string{"https://a.example/"}, Mode: "expand"},
+	}
+	for name, req := range errorCases {
+		req := req
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := client.BatchResolve(context.Background(), req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestStreamResolve(t *testing.T) {
+	t.Parallel()
+
+	client := newTestClient(t, New(stubResolver{}, WithBatchConcurrency(2)))
+
+	urls := []string{"https://a.example/", "https://b.example/", "https://error.example/", "https://d.example/"}
+	stream, err := client.StreamResolve(context.Background(), &urlresolverpb.BatchResolveRequest{Urls: urls})
+	assert.NoError(t, err)
+
+	var got []string
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		got = append(got, resp.GetGivenUrl())
+	}
+	assert.ElementsMatch(t, urls, got)
+
+	// invalid requests fail before any results are sent
+	stream, err = client.StreamResolve(context.Background(), &urlresolverpb.BatchResolveRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,215 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: urlresolver.proto
+
+package urlresolverpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	URLResolver_Resolve_FullMethodName       = "/urlresolver.v1.URLResolver/Resolve"
+	URLResolver_BatchResolve_FullMethodName  = "/urlresolver.v1.URLResolver/BatchResolve"
+	URLResolver_StreamResolve_FullMethodName = "/urlresolver.v1.URLResolver/StreamResolve"
+)
+
+// URLResolverClient is the client API for URLResolver service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// URLResolver resolves URLs by following redirects and extracting titles.
+type URLResolverClient interface {
+	// Resolve resolves a single URL.
+	Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*ResolveResponse, error)
+	// BatchResolve resolves multiple URLs, returning all of the results at once
+	// in the same order as the given URLs.
+	BatchResolve(ctx context.Context, in *BatchResolveRequest, opts ...grpc.CallOption) (*BatchResolveResponse, error)
+	// StreamResolve resolves multiple URLs, streaming each result as soon as it
+	// is available. Results may arrive in any order.
+	StreamResolve(ctx context.Context, in *BatchResolveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ResolveResponse], error)
+}
+
+type uRLResolverClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewURLResolverClient(cc grpc.ClientConnInterface) URLResolverClient {
+	return &uRLResolverClient{cc}
+}
+
+func (c *uRLResolverClient) Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*ResolveResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResolveResponse)
+	err := c.cc.Invoke(ctx, URLResolver_Resolve_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLResolverClient) BatchResolve(ctx context.Context, in *BatchResolveRequest, opts ...grpc.CallOption) (*BatchResolveResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchResolveResponse)
+	err := c.cc.Invoke(ctx, URLResolver_BatchResolve_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *uRLResolverClient) StreamResolve(ctx context.Context, in *BatchResolveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ResolveResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &URLResolver_ServiceDesc.Streams[0], URLResolver_StreamResolve_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchResolveRequest, ResolveResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type URLResolver_StreamResolveClient = grpc.ServerStreamingClient[ResolveResponse]
+
+// URLResolverServer is the server API for URLResolver service.
+// All implementations must embed UnimplementedURLResolverServer
+// for forward compatibility.
+//
+// URLResolver resolves URLs by following redirects and extracting titles.
+type URLResolverServer interface {
+	// Resolve resolves a single URL.
+	Resolve(context.Context, *ResolveRequest) (*ResolveResponse, error)
+	// BatchResolve resolves multiple URLs, returning all of the results at once
+	// in the same order as the given URLs.
+	BatchResolve(context.Context, *BatchResolveRequest) (*BatchResolveResponse, error)
+	// StreamResolve resolves multiple URLs, streaming each result as soon as it
+	// is available. Results may arrive in any order.
+	StreamResolve(*BatchResolveRequest, grpc.ServerStreamingServer[ResolveResponse]) error
+	mustEmbedUnimplementedURLResolverServer()
+}
+
+// UnimplementedURLResolverServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedURLResolverServer struct{}
+
+func (UnimplementedURLResolverServer) Resolve(context.Context, *ResolveRequest) (*ResolveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resolve not implemented")
+}
+func (UnimplementedURLResolverServer) BatchResolve(context.Context, *BatchResolveRequest) (*BatchResolveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchResolve not implemented")
+}
+func (UnimplementedURLResolverServer) StreamResolve(*BatchResolveRequest, grpc.ServerStreamingServer[ResolveResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamResolve not implemented")
+}
+func (UnimplementedURLResolverServer) mustEmbedUnimplementedURLResolverServer() {}
+func (UnimplementedURLResolverServer) testEmbeddedByValue()                     {}
+
+// UnsafeURLResolverServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to URLResolverServer will
+// result in compilation errors.
+type UnsafeURLResolverServer interface {
+	mustEmbedUnimplementedURLResolverServer()
+}
+
+func RegisterURLResolverServer(s grpc.ServiceRegistrar, srv URLResolverServer) {
+	// If the following call pancis, it indicates UnimplementedURLResolverServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&URLResolver_ServiceDesc, srv)
+}
+
+func _URLResolver_Resolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLResolverServer).Resolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLResolver_Resolve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLResolverServer).Resolve(ctx, req.(*ResolveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLResolver_BatchResolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchResolveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(URLResolverServer).BatchResolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: URLResolver_BatchResolve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(URLResolverServer).BatchResolve(ctx, req.(*BatchResolveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _URLResolver_StreamResolve_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchResolveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(URLResolverServer).StreamResolve(m, &grpc.GenericServerStream[BatchResolveRequest, ResolveResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type URLResolver_StreamResolveServer = grpc.ServerStreamingServer[ResolveResponse]
+
+// URLResolver_ServiceDesc is the grpc.ServiceDesc for URLResolver service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var URLResolver_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "urlresolver.v1.URLResolver",
+	HandlerType: (*URLResolverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Resolve",
+			Handler:    _URLResolver_Resolve_Handler,
+		},
+		{
+			MethodName: "BatchResolve",
+			Handler:    _URLResolver_BatchResolve_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamResolve",
+			Handler:       _URLResolver_StreamResolve_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "urlresolver.proto",
+}
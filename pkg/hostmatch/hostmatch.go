@@ -0,0 +1,126 @@
+/*
+Package hostmatch matches hostnames against simple domain patterns.
+
+A pattern is either a domain suffix or a glob:
+
+	example.com      matches example.com and any subdomain of example.com
+	.example.com     same as above
+	*.example.com    matches any subdomain of example.com, but not example.com
+	cdn-?.example.*  glob patterns are matched with path.Match semantics
+
+Matching is case insensitive, and any trailing dot on a hostname is ignored.
+*/
+package hostmatch
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ErrEmptyPattern is returned when parsing an empty pattern.
+var ErrEmptyPattern = errors.New("empty host pattern")
+
+// Pattern is a single hostname pattern.
+type Pattern struct {
+	raw    string
+	suffix string
+	glob   string
+}
+
+// Parse parses a single hostname pattern.
+func Parse(raw string) (Pattern, error) {
+	p := strings.ToLower(strings.TrimSpace(raw))
+	p = strings.TrimSuffix(p, ".")
+	if p == "" || p == "." {
+		return Pattern{}, ErrEmptyPattern
+	}
+	if strings.ContainsAny(p, "*?[") {
+		if _, err := path.Match(p, ""); err != nil {
+			return Pattern{}, fmt.Errorf("invalid host pattern %q: %w", raw, err)
+		}
+		return Pattern{raw: raw, glob: p}, nil
+	}
+	return Pattern{raw: raw, suffix: strings.TrimPrefix(p, ".")}, nil
+}
+
+// MustParse is like Parse but panics if the pattern is invalid.
+func MustParse(raw string) Pattern {
+	p, err := Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Match reports whether the given hostname matches the pattern.
+func (p Pattern) Match(host string) bool {
+	host = normalize(host)
+	if p.glob != "" {
+		ok, _ := path.Match(p.glob, host)
+		return ok
+	}
+	return host == p.suffix || strings.HasSuffix(host, "."+p.suffix)
+}
+
+// String returns the pattern as originally given.
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// List is a list of patterns that matches a hostname if any of its patterns
+// match.
+type List []Pattern
+
+// ParseList parses a list of patterns.
+func ParseList(raws []string) (List, error) {
+	list := make(List, 0, len(raws))
+	for _, raw := range raws {
+		p, err := Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// Match reports whether the given hostname matches any pattern in the list.
+func (l List) Match(host string) bool {
+	_, ok := l.Find(host)
+	return ok
+}
+
+// Find returns the first pattern in the list that matches the given
+// hostname.
+func (l List) Find(host string) (Pattern, bool) {
+	for _, p := range l {
+		if p.Match(host) {
+			return p, true
+		}
+	}
+	return Pattern{}, false
+}
+
+// SameSite reports whether two hostnames are on the same registrable domain,
+// e.g. amp.example.co.uk and www.example.co.uk. Hostnames without a known
+// public suffix, such as IP addresses, are only on the same site as
+// themselves.
+func SameSite(a, b string) bool {
+	return site(a) == site(b)
+}
+
+func site(host string) string {
+	host = normalize(host)
+	if s, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return s
+	}
+	return host
+}
+
+func normalize(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
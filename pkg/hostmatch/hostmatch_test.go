@@ -0,0 +1,91 @@
+package hostmatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		"suffix matches exact host":       {"example.com", "example.com", true},
+		"suffix matches subdomain":        {"example.com", "www.example.com", true},
+		"suffix respects label boundary":  {"example.com", "badexample.com", false},
+		"leading dot is ignored":          {".example.com", "example.com", true},
+		"matching is case insensitive":    {"Example.COM", "WWW.example.com", true},
+		"trailing dot is ignored":         {"example.com.", "www.example.com.", true},
+		"glob matches subdomain":          {"*.example.com", "www.example.com", true},
+		"glob does not match bare domain": {"*.example.com", "example.com", false},
+		"glob matches single char":        {"cdn-?.example.*", "cdn-1.example.net", true},
+		"glob mismatch":                   {"cdn-?.example.*", "cdn-10.example.net", false},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p, err := Parse(tc.pattern)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, p.Match(tc.host))
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := Parse("  ")
+	assert.ErrorIs(t, err, ErrEmptyPattern)
+
+	_, err = Parse("[.example.com")
+	assert.Error(t, err)
+
+	_, err = ParseList([]string{"example.com", ""})
+	assert.ErrorIs(t, err, ErrEmptyPattern)
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	list, err := ParseList([]string{"t.co", "*.bit.ly"})
+	assert.NoError(t, err)
+
+	assert.True(t, list.Match("t.co"))
+	assert.True(t, list.Match("j.bit.ly"))
+	assert.False(t, list.Match("bit.ly"))
+
+	p, ok := list.Find("j.bit.ly")
+	assert.True(t, ok)
+	assert.Equal(t, "*.bit.ly", p.String())
+}
+
+func TestSameSite(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		a, b string
+		want bool
+	}{
+		"subdomains":                   {"amp.example.com", "www.example.com", true},
+		"bare domain":                  {"example.com", "consent.example.com", true},
+		"multi-label public suffix":    {"amp.example.co.uk", "www.example.co.uk", true},
+		"case and trailing dot":        {"WWW.Example.com.", "example.com", true},
+		"different domains":            {"example.com", "example.net", false},
+		"different private registries": {"a.github.io", "b.github.io", false},
+		"same ip address":              {"127.0.0.1", "127.0.0.1", true},
+		"different hosts without tld":  {"127.0.0.1", "localhost", false},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, SameSite(tc.a, tc.b))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Package hostpolicy implements a policy that restricts which hosts may be
+fetched while resolving URLs.
+
+Policies are loaded from a file containing one rule per line, where each rule
+is either "allow" or "deny" followed by a hostmatch pattern:
+
+	# never fetch from known malware hosts
+	deny malware.example
+	deny *.tracker.example
+
+	# only resolve shortener domains
+	allow t.co
+	allow bit.ly
+
+A host is blocked if it matches any deny rule. If the policy contains any
+allow rules, a host is also blocked unless it matches at least one of them.
+
+Policies are enforced both before a URL is resolved, via Resolver, and on
+every redirect hop, via Transport.
+*/
+package hostpolicy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/rulefile"
+)
+
+// ErrBlockedHost is returned when a host is blocked by a Policy.
+var ErrBlockedHost = errors.New("blocked host")
+
+// Policy determines which hosts may be fetched.
+type Policy struct {
+	file *rulefile.File[lists]
+}
+
+// lists holds a policy's allow and deny lists, which are reloaded together.
+type lists struct {
+	allow hostmatch.List
+	deny  hostmatch.List
+}
+
+// New creates a new Policy from the given allow and deny lists.
+func New(allow hostmatch.List, deny hostmatch.List) *Policy {
+	return &Policy{
+		file: rulefile.Static(lists{allow: allow, deny: deny}),
+	}
+}
+
+// Load creates a new Policy from the rules in the file at path. The policy
+// may be reloaded later via Reload.
+func Load(path string) (*Policy, error) {
+	file, err := rulefile.Load(path, parseRules)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{file: file}, nil
+}
+
+// Reload re-reads the policy's rules from the file it was loaded from. If
+// the file cannot be read or parsed, the existing rules are kept.
+func (p *Policy) Reload() error {
+	return p.file.Reload()
+}
+
+// Check returns an error wrapping ErrBlockedHost if the given host is not
+// allowed by the policy.
+func (p *Policy) Check(host string) error {
+	rules := p.file.Rules()
+	if pattern, found := rules.deny.Find(host); found {
+		return fmt.Errorf("%w: %s denied by rule %q", ErrBlockedHost, host, pattern)
+	}
+	if len(rules.allow) > 0 && !rules.allow.Match(host) {
+		return fmt.Errorf("%w: %s not allowed", ErrBlockedHost, host)
+	}
+	return nil
+}
+
+// CheckURL returns an error wrapping ErrBlockedHost if the given URL's host
+// is not allowed by the policy. URLs that cannot be parsed are not checked.
+func (p *Policy) CheckURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	return p.Check(parsed.Hostname())
+}
+
+func parseRules(r io.Reader) (lists, error) {
+	var rules lists
+	err := rulefile.Scan(r, func(l rulefile.Line) error {
+		if len(l.Fields) != 2 {
+			return errors.New("rule must be in \"allow|deny pattern\" format")
+		}
+		pattern, err := hostmatch.Parse(l.Fields[1])
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(l.Fields[0]) {
+		case "allow":
+			rules.allow = append(rules.allow, pattern)
+		case "deny":
+			rules.deny = append(rules.deny, pattern)
+		default:
+			return fmt.Errorf("unknown rule type %q", l.Fields[0])
+		}
+		return nil
+	})
+	return rules, err
+}
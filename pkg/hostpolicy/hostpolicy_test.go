@@ -0,0 +1,186 @@
+//nolint:errcheck
+package hostpolicy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		rules   string
+		allowed []string
+		blocked []string
+	}{
+		"empty policy allows everything": {
+			rules:   "# nothing here\n\n",
+			allowed: []string{"example.com", "t.co"},
+		},
+		"deny rules": {
+			rules:   "deny malware.example\ndeny *.tracker.example",
+			allowed: []string{"example.com", "tracker.example"},
+			blocked: []string{"malware.example", "www.malware.example", "a.tracker.example"},
+		},
+		"allow rules": {
+			rules:   "allow t.co\nallow bit.ly",
+			allowed: []string{"t.co", "j.bit.ly"},
+			blocked: []string{"example.com"},
+		},
+		"deny rules take precedence": {
+			rules:   "allow bit.ly\ndeny evil.bit.ly",
+			allowed: []string{"bit.ly"},
+			blocked: []string{"evil.bit.ly"},
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			p, err := Load(writeRules(t, tc.rules))
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, host := range tc.allowed {
+				assert.NoError(t, p.Check(host), "expected %s to be allowed", host)
+			}
+			for _, host := range tc.blocked {
+				assert.ErrorIs(t, p.Check(host), ErrBlockedHost, "expected %s to be blocked", host)
+			}
+		})
+	}
+}
+
+func TestPolicyParseErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"unknown rule type": "block example.com",
+		"missing pattern":   "deny",
+		"invalid pattern":   "deny [example.com",
+	}
+	for name, rules := range testCases {
+		rules := rules
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Load(writeRules(t, rules))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "does-not-exist"))
+	assert.Error(t, err)
+}
+
+func TestPolicyReload(t *testing.T) {
+	t.Parallel()
+
+	path := writeRules(t, "deny example.com")
+	p, err := Load(path)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, p.Check("example.com"), ErrBlockedHost)
+
+	assert.NoError(t, os.WriteFile(path, []byte("deny example.org"), 0o644))
+	assert.NoError(t, p.Reload())
+	assert.NoError(t, p.Check("example.com"))
+	assert.ErrorIs(t, p.Check("example.org"), ErrBlockedHost)
+
+	// invalid rules are rejected and the existing rules are kept
+	assert.NoError(t, os.WriteFile(path, []byte("nope"), 0o644))
+	assert.Error(t, p.Reload())
+	assert.ErrorIs(t, p.Check("example.org"), ErrBlockedHost)
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	blockedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<title>blocked</title>`))
+	}))
+	defer blockedSrv.Close()
+
+	allowedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			// use the "localhost" hostname to distinguish the blocked server
+			// from the allowed one
+			http.Redirect(w, r, strings.Replace(blockedSrv.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+			return
+		}
+		w.Write([]byte(`<title>allowed</title>`))
+	}))
+	defer allowedSrv.Close()
+
+	policy := New(nil, hostmatch.List{hostmatch.MustParse("localhost")})
+	resolver := NewResolver(policy, urlresolver.New(NewTransport(policy, http.DefaultTransport), 0))
+
+	t.Run("allowed", func(t *testing.T) {
+		result, err := resolver.Resolve(context.Background(), allowedSrv.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "allowed", result.Title)
+	})
+
+	t.Run("blocked before resolving", func(t *testing.T) {
+		blockedURL := strings.Replace(blockedSrv.URL, "127.0.0.1", "localhost", 1) + "/foo"
+		result, err := resolver.Resolve(context.Background(), blockedURL)
+		assert.ErrorIs(t, err, ErrBlockedHost)
+		assert.Equal(t, blockedURL, result.ResolvedURL)
+		assert.Equal(t, "", result.Title)
+	})
+
+	t.Run("blocked while following redirects", func(t *testing.T) {
+		result, err := resolver.Resolve(context.Background(), allowedSrv.URL+"/redirect")
+		assert.ErrorIs(t, err, ErrBlockedHost)
+		assert.Equal(t, "", result.Title)
+	})
+
+	t.Run("blocked in previously resolved result", func(t *testing.T) {
+		stub := stubResolver{result: urlresolver.Result{
+			ResolvedURL:      "https://example.com/",
+			Title:            "title",
+			IntermediateURLs: []string{"https://example.org/", "https://example.net/a", "https://localhost/foo"},
+		}}
+		result, err := NewResolver(policy, stub).Resolve(context.Background(), "https://example.org")
+		assert.ErrorIs(t, err, ErrBlockedHost)
+		assert.Equal(t, urlresolver.Result{ResolvedURL: "https://example.net/a"}, result)
+	})
+
+	t.Run("blocked resolved url in previously resolved result", func(t *testing.T) {
+		stub := stubResolver{result: urlresolver.Result{
+			ResolvedURL: "https://localhost/foo",
+			Title:       "title",
+		}}
+		result, err := NewResolver(policy, stub).Resolve(context.Background(), "https://example.org/?utm_source=x")
+		assert.ErrorIs(t, err, ErrBlockedHost)
+		assert.Equal(t, urlresolver.Result{ResolvedURL: "https://example.org/"}, result)
+	})
+}
+
+type stubResolver struct {
+	result urlresolver.Result
+	err    error
+}
+
+func (r stubResolver) Resolve(_ context.Context, _ string) (urlresolver.Result, error) {
+	return r.result, r.err
+}
+
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,73 @@
+package hostpolicy
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+// Resolver is a urlresolver.Interface implementation that enforces a Policy
+// before resolving a URL. It also checks every URL in the result, so that
+// results resolved (and perhaps cached) before a host was blocked are not
+// returned afterwards.
+type Resolver struct {
+	policy   *Policy
+	resolver urlresolver.Interface
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(policy *Policy, resolver urlresolver.Interface) *Resolver {
+	return &Resolver{
+		policy:   policy,
+		resolver: resolver,
+	}
+}
+
+// Resolve resolves a URL if it and every URL it resolves through are allowed
+// by the policy.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	if parsed, err := url.Parse(givenURL); err == nil {
+		if err := r.policy.Check(parsed.Hostname()); err != nil {
+			beeline.AddField(ctx, "host_policy.blocked_host", parsed.Hostname())
+			return urlresolver.Result{ResolvedURL: urlresolver.Canonicalize(parsed)}, err
+		}
+	}
+
+	result, err := r.resolver.Resolve(ctx, givenURL)
+	if errors.Is(err, ErrBlockedHost) {
+		// a blocked redirect was never followed, but don't return anything
+		// beyond the last allowed URL
+		return urlresolver.Result{ResolvedURL: result.ResolvedURL}, err
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// like a host blocked before resolving, a result that passed through a
+	// blocked host only reveals the last allowed URL
+	lastAllowed := givenURL
+	if parsed, err := url.Parse(givenURL); err == nil {
+		lastAllowed = urlresolver.Canonicalize(parsed)
+	}
+	urls := make([]string, 0, len(result.IntermediateURLs)+1)
+	urls = append(urls, result.IntermediateURLs...)
+	for _, u := range append(urls, result.ResolvedURL) {
+		if err := r.checkURL(ctx, u); err != nil {
+			return urlresolver.Result{ResolvedURL: lastAllowed}, err
+		}
+		lastAllowed = u
+	}
+	return result, nil
+}
+
+func (r *Resolver) checkURL(ctx context.Context, u string) error {
+	err := r.policy.CheckURL(u)
+	if err != nil {
+		beeline.AddField(ctx, "host_policy.blocked_url", u)
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package hostpolicy
+
+import (
+	"net/http"
+
+	"github.com/honeycombio/beeline-go"
+)
+
+// Transport is an http.RoundTripper that refuses to make requests to hosts
+// blocked by a Policy. Because every redirect hop is a separate request, this
+// enforces the policy while redirects are being followed.
+type Transport struct {
+	policy    *Policy
+	transport http.RoundTripper
+}
+
+// NewTransport creates a new Transport that enforces the given Policy before
+// passing requests on to the given transport.
+func NewTransport(policy *Policy, transport http.RoundTripper) *Transport {
+	return &Transport{
+		policy:    policy,
+		transport: transport,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.Check(req.URL.Hostname()); err != nil {
+		beeline.AddField(req.Context(), "host_policy.blocked_host", req.URL.Hostname())
+		return nil, err
+	}
+	return t.transport.RoundTrip(req)
+}
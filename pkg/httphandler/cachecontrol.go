@@ -0,0 +1,188 @@
+package httphandler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/ctxdata/v4"
+
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+)
+
+// Response outcomes, each of which may be given its own Cache-Control
+// policy.
+const (
+	OutcomeOK          = "ok"           // 200 OK
+	OutcomeTimeout     = "timeout"      // 203 partial result after an upstream timeout
+	OutcomeUnsafe      = "unsafe"       // 203 partial result for an unsafe or blocked URL
+	OutcomeError       = "error"        // 203 partial result for any other error
+	OutcomeClientError = "client_error" // 4xx invalid request
+)
+
+// AnyClient is the key for per-client CacheControl overrides that apply to
+// every authenticated client without its own override.
+const AnyClient = "*"
+
+// CacheControl gives the Cache-Control header value to use for each response
+// outcome. Empty values fall back to the defaults.
+type CacheControl struct {
+	OK          string
+	Timeout     string
+	Unsafe      string
+	Error       string
+	ClientError string
+}
+
+// DefaultCacheControl returns the default cache policy, which allows
+// successful responses to be cached aggressively and everything else to be
+// cached briefly.
+func DefaultCacheControl() CacheControl {
+	errValue := fmt.Sprintf("public,max-age=%.0f", maxAgeErr.Seconds())
+	return CacheControl{
+		OK:          fmt.Sprintf("public,max-age=%.0f", maxAgeOK.Seconds()),
+		Timeout:     errValue,
+		Unsafe:      errValue,
+		Error:       errValue,
+		ClientError: errValue,
+	}
+}
+
+// Value returns the Cache-Control value for the given outcome.
+func (c CacheControl) Value(outcome string) string {
+	switch outcome {
+	case OutcomeOK:
+		return c.OK
+	case OutcomeTimeout:
+		return c.Timeout
+	case OutcomeUnsafe:
+		return c.Unsafe
+	case OutcomeError:
+		return c.Error
+	case OutcomeClientError:
+		return c.ClientError
+	default:
+		return ""
+	}
+}
+
+// Set sets the Cache-Control value for the given outcome, returning an error
+// if the outcome is unknown or the value is invalid.
+func (c *CacheControl) Set(outcome string, value string) error {
+	if err := validateCacheControl(value); err != nil {
+		return err
+	}
+	switch outcome {
+	case OutcomeOK:
+		c.OK = value
+	case OutcomeTimeout:
+		c.Timeout = value
+	case OutcomeUnsafe:
+		c.Unsafe = value
+	case OutcomeError:
+		c.Error = value
+	case OutcomeClientError:
+		c.ClientError = value
+	default:
+		return fmt.Errorf("unknown cache outcome %q", outcome)
+	}
+	return nil
+}
+
+// ParseCacheControlOverrides parses per-client CacheControl overrides from a
+// semicolon-separated list of "client-id[/outcome]=cache-control" entries. If
+// no outcome is given, the override applies to every outcome. The client ID
+// may be AnyClient to apply to every authenticated client. For example:
+//
+//	*=private,max-age=3600;cdn/ok=public,max-age=86400,s-maxage=31536000,stale-while-revalidate=86400
+func ParseCacheControlOverrides(config string) (map[string]CacheControl, error) {
+	if len(strings.TrimSpace(config)) == 0 {
+		return nil, nil
+	}
+
+	outcomes := []string{OutcomeOK, OutcomeTimeout, OutcomeUnsafe, OutcomeError, OutcomeClientError}
+	overrides := make(map[string]CacheControl)
+	for _, def := range strings.Split(config, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		key, value, found := strings.Cut(def, "=")
+		if !found {
+			return nil, fmt.Errorf(`invalid cache control override %q, must be in "client-id[/outcome]=cache-control" format`, def)
+		}
+		clientID, outcome, _ := strings.Cut(strings.TrimSpace(key), "/")
+		if clientID == "" {
+			return nil, fmt.Errorf("cache control override %q has empty client ID", def)
+		}
+
+		policy := overrides[clientID]
+		targets := outcomes
+		if outcome != "" {
+			targets = []string{outcome}
+		}
+		for _, target := range targets {
+			if err := policy.Set(target, strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid cache control override %q: %w", def, err)
+			}
+		}
+		overrides[clientID] = policy
+	}
+	return overrides, nil
+}
+
+// validateCacheControl ensures that a Cache-Control value contains only
+// known response directives with valid arguments, to catch typos in
+// configuration.
+func validateCacheControl(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("empty cache control value")
+	}
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "public", "private", "no-cache", "no-store", "no-transform", "must-revalidate", "proxy-revalidate", "immutable":
+			if hasArg {
+				return fmt.Errorf("cache control directive %q takes no argument", name)
+			}
+		case "max-age", "s-maxage", "stale-while-revalidate", "stale-if-error":
+			if n, err := strconv.Atoi(arg); err != nil || n < 0 {
+				return fmt.Errorf("cache control directive %q requires a non-negative number of seconds", name)
+			}
+		default:
+			return fmt.Errorf("unknown cache control directive %q", name)
+		}
+	}
+	return nil
+}
+
+// cacheControlValue returns the Cache-Control value for a response with the
+// given outcome, taking into account any overrides for the authenticated
+// client making the request.
+func (h *Handler) cacheControlValue(r *http.Request, outcome string) string {
+	if clientID := ctxdata.From(r.Context()).GetString("client_id"); clientID != "" {
+		if value := h.cacheControlOverrides[clientID].Value(outcome); value != "" {
+			return value
+		}
+		if value := h.cacheControlOverrides[AnyClient].Value(outcome); value != "" {
+			return value
+		}
+	}
+	if value := h.cacheControl.Value(outcome); value != "" {
+		return value
+	}
+	return DefaultCacheControl().Value(outcome)
+}
+
+// outcomeForError returns the outcome of a partial result with the given
+// resolve error.
+func outcomeForError(err error) string {
+	switch ErrorCode(err) {
+	case problem.CodeUpstreamTimeout:
+		return OutcomeTimeout
+	case problem.CodeUnsafeURL, problem.CodeBlockedURL:
+		return OutcomeUnsafe
+	default:
+		return OutcomeError
+	}
+}
@@ -0,0 +1,209 @@
+package httphandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peterbourgon/ctxdata/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/safedialer"
+	"github.com/mccutchen/urlresolver"
+)
+
+func TestParseCacheControlOverrides(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		config  string
+		want    map[string]CacheControl
+		wantErr string
+	}{
+		"empty": {
+			config: "  ",
+			want:   nil,
+		},
+		"all outcomes": {
+			config: "*=private,max-age=3600",
+			want: map[string]CacheControl{
+				AnyClient: {
+					OK:          "private,max-age=3600",
+					Timeout:     "private,max-age=3600",
+					Unsafe:      "private,max-age=3600",
+					Error:       "private,max-age=3600",
+					ClientError: "private,max-age=3600",
+				},
+			},
+		},
+		"single outcomes": {
+			config: "cdn/ok=public,max-age=86400,s-maxage=31536000,stale-while-revalidate=86400; cdn/timeout=no-store;",
+			want: map[string]CacheControl{
+				"cdn": {
+					OK:      "public,max-age=86400,s-maxage=31536000,stale-while-revalidate=86400",
+					Timeout: "no-store",
+				},
+			},
+		},
+		"later entries win": {
+			config: "a=no-store;a/ok=public,max-age=60",
+			want: map[string]CacheControl{
+				"a": {
+					OK:          "public,max-age=60",
+					Timeout:     "no-store",
+					Unsafe:      "no-store",
+					Error:       "no-store",
+					ClientError: "no-store",
+				},
+			},
+		},
+		"missing value": {
+			config:  "a/ok",
+			wantErr: "must be in",
+		},
+		"empty client id": {
+			config:  "/ok=no-store",
+			wantErr: "empty client ID",
+		},
+		"unknown outcome": {
+			config:  "a/bogus=no-store",
+			wantErr: `unknown cache outcome "bogus"`,
+		},
+		"empty directives": {
+			config:  "a=",
+			wantErr: "empty cache control value",
+		},
+		"unknown directive": {
+			config:  "a=public,maxage=60",
+			wantErr: `unknown cache control directive "maxage"`,
+		},
+		"invalid seconds": {
+			config:  "a=public,max-age=-1",
+			wantErr: "non-negative number of seconds",
+		},
+		"unexpected argument": {
+			config:  "a=public=1",
+			wantErr: "takes no argument",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseCacheControlOverrides(tc.config)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCacheControlPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := CacheControl{
+		OK:          "public,max-age=100",
+		Timeout:     "public,max-age=1",
+		Unsafe:      "public,max-age=2",
+		Error:       "public,max-age=3",
+		ClientError: "public,max-age=4",
+	}
+	overrides := map[string]CacheControl{
+		AnyClient: {OK: "private,max-age=100", Error: "no-store"},
+		"cdn":     {OK: "public,max-age=100,s-maxage=1000,stale-while-revalidate=10"},
+	}
+	newHandler := func(err error) *Handler {
+		return New(
+			stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/"}, err: err},
+			WithCacheControl(policy, overrides),
+		)
+	}
+
+	testCases := map[string]struct {
+		handler  *Handler
+		url      string
+		clientID string
+		want     string
+	}{
+		"ok": {
+			handler: newHandler(nil),
+			want:    "public,max-age=100",
+		},
+		"timeout": {
+			handler: newHandler(context.DeadlineExceeded),
+			want:    "public,max-age=1",
+		},
+		"unsafe": {
+			handler: newHandler(safedialer.ErrUnsafeIP),
+			want:    "public,max-age=2",
+		},
+		"blocked": {
+			handler: newHandler(ErrBlockedURL),
+			want:    "public,max-age=2",
+		},
+		"error": {
+			handler: newHandler(errors.New("oops")),
+			want:    "public,max-age=3",
+		},
+		"client error": {
+			handler: newHandler(nil),
+			url:     "/resolve",
+			want:    "public,max-age=4",
+		},
+		"authenticated client": {
+			handler:  newHandler(nil),
+			clientID: "some-client",
+			want:     "private,max-age=100",
+		},
+		"authenticated client error": {
+			handler:  newHandler(errors.New("oops")),
+			clientID: "some-client",
+			want:     "no-store",
+		},
+		"authenticated client falls back to default": {
+			handler:  newHandler(context.DeadlineExceeded),
+			clientID: "some-client",
+			want:     "public,max-age=1",
+		},
+		"specific client": {
+			handler:  newHandler(nil),
+			clientID: "cdn",
+			want:     "public,max-age=100,s-maxage=1000,stale-while-revalidate=10",
+		},
+		"specific client falls back to any client": {
+			handler:  newHandler(errors.New("oops")),
+			clientID: "cdn",
+			want:     "no-store",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			url := tc.url
+			if url == "" {
+				url = "/resolve?url=https://t.co/foo"
+			}
+			ctx, d := ctxdata.New(context.Background())
+			if tc.clientID != "" {
+				_ = d.Set("client_id", tc.clientID)
+			}
+			w := httptest.NewRecorder()
+			tc.handler.ServeHTTP(w, httptest.NewRequest("GET", url, nil).WithContext(ctx))
+			assert.Equal(t, tc.want, w.Header().Get("Cache-Control"))
+		})
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+		w := httptest.NewRecorder()
+		New(stubResolver{}).ServeHTTP(w, httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "public,max-age=31536000", w.Header().Get("Cache-Control"))
+	})
+}
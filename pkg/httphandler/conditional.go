@@ -0,0 +1,85 @@
+package httphandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sendCacheableResponse writes a response in the given format, adding
+// validators to successful responses so that clients and CDNs may revalidate
+// them with conditional requests. A strong ETag is computed from the encoded
+// response body and, if lastModified is non-zero, a Last-Modified header is
+// added.
+//
+// Conditional requests whose validators match are answered with 304 Not
+// Modified. As described in RFC 9110, If-None-Match takes precedence over
+// If-Modified-Since.
+func sendCacheableResponse(w http.ResponseWriter, r *http.Request, format responseFormat, code int, data interface{}, lastModified time.Time) {
+	if code != http.StatusOK {
+		writeResponse(w, r, format, code, data)
+		return
+	}
+
+	var buf bytes.Buffer
+	_ = format.encode(&buf, data, isPretty(r))
+
+	etag := strongETag(buf.Bytes())
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType())
+	w.WriteHeader(code)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// strongETag computes a strong entity tag from a response body.
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether the request's preconditions indicate that the
+// client already has the current representation.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		// Last-Modified has a resolution of one second
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagMatches reports whether the given If-None-Match header value matches
+// the given entity tag, using the weak comparison required for If-None-Match.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package httphandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	t.Parallel()
+
+	storedAt := time.Date(2024, 3, 1, 12, 30, 15, 500, time.UTC)
+	result := urlresolver.Result{ResolvedURL: "https://example.com/resolved", Title: "title"}
+
+	cachedHandler := New(metaResolver{
+		result: result,
+		meta:   resultmeta.Meta{CacheResult: resultmeta.CacheHit, StoredAt: storedAt},
+	})
+	uncachedHandler := New(stubResolver{result: result})
+
+	// get the current ETag, which depends only on the response content
+	w := httptest.NewRecorder()
+	uncachedHandler.ServeHTTP(w, httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil))
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+
+	w = httptest.NewRecorder()
+	cachedHandler.ServeHTTP(w, httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil))
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, "Fri, 01 Mar 2024 12:30:15 GMT", w.Header().Get("Last-Modified"))
+
+	testCases := map[string]struct {
+		handler  http.Handler
+		url      string
+		headers  map[string]string
+		wantCode int
+	}{
+		"matching etag": {
+			handler:  uncachedHandler,
+			headers:  map[string]string{"If-None-Match": etag},
+			wantCode: http.StatusNotModified,
+		},
+		"matching weak etag in list": {
+			handler:  uncachedHandler,
+			headers:  map[string]string{"If-None-Match": `"abc", W/` + etag},
+			wantCode: http.StatusNotModified,
+		},
+		"wildcard etag": {
+			handler:  uncachedHandler,
+			headers:  map[string]string{"If-None-Match": "*"},
+			wantCode: http.StatusNotModified,
+		},
+		"mismatched etag": {
+			handler:  uncachedHandler,
+			headers:  map[string]string{"If-None-Match": `"abc"`},
+			wantCode: http.StatusOK,
+		},
+		"etag differs by format": {
+			handler:  uncachedHandler,
+			url:      "/resolve?url=https://t.co/foo&pretty",
+			headers:  map[string]string{"If-None-Match": etag},
+			wantCode: http.StatusOK,
+		},
+		"not modified since": {
+			handler:  cachedHandler,
+			headers:  map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 12:30:15 GMT"},
+			wantCode: http.StatusNotModified,
+		},
+		"modified since": {
+			handler:  cachedHandler,
+			headers:  map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 12:30:14 GMT"},
+			wantCode: http.StatusOK,
+		},
+		"if-modified-since ignored without last-modified": {
+			handler:  uncachedHandler,
+			headers:  map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 12:30:15 GMT"},
+			wantCode: http.StatusOK,
+		},
+		"if-none-match takes precedence": {
+			handler: cachedHandler,
+			headers: map[string]string{
+				"If-None-Match":     `"abc"`,
+				"If-Modified-Since": "Fri, 01 Mar 2024 12:30:15 GMT",
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			url := tc.url
+			if url == "" {
+				url = "/resolve?url=https://t.co/foo"
+			}
+			r := httptest.NewRequest("GET", url, nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			tc.handler.ServeHTTP(w, r)
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.NotEmpty(t, w.Header().Get("ETag"))
+			assert.Equal(t, DefaultCacheControl().OK, w.Header().Get("Cache-Control"))
+			if tc.wantCode == http.StatusNotModified {
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("partial results have no validators", func(t *testing.T) {
+		t.Parallel()
+		handler := New(stubResolver{result: result, err: errors.New("oops")})
+		r := httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil)
+		r.Header.Set("If-None-Match", "*")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNonAuthoritativeInfo, w.Code)
+		assert.Empty(t, w.Header().Get("ETag"))
+	})
+}
+
+// metaResolver is a stub resolver that records the given metadata.
+type metaResolver struct {
+	result urlresolver.Result
+	meta   resultmeta.Meta
+}
+
+func (r metaResolver) Resolve(ctx context.Context, _ string) (urlresolver.Result, error) {
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { *m = r.meta })
+	return r.result, nil
+}
@@ -13,17 +13,55 @@ JSON object containing the resolved URL and the resolved title:
 	}
 
 If an error occurs during resolution, the response status code will be 203
-Non-Authoritative Information (to indicate partial response), an additional
-error field will be added, and a partial result will be returned, including the
-canonicalized and potentially partially-resolved URL:
+Non-Authoritative Information (to indicate partial response), additional error
+and error_code fields will be added, and a partial result will be returned,
+including the canonicalized and potentially partially-resolved URL:
 
 	$ curl -s localhost:8080/resolve?url=https://i-do-not-exist.xyz?utm_tag=tracking-code | jq .
 	{
 	    "given_url": "https://i-do-not-exist.xyz?utm_tag=tracking-code",
 	    "resolved_url": "https://i-do-not-exist.xyz",
 	    "title": "",
-	    "error": "resolve error"
+	    "error": "resolve error",
+	    "error_code": "resolve_error"
 	}
+
+Invalid requests are rejected with an RFC 7807 problem details response, as
+described in the problem package.
+
+If the optional mode=expand query parameter is given, the URL is only expanded
+by following redirects through known URL shorteners, stopping at the first
+non-shortener URL without fetching it or its title:
+
+	$ curl -s 'localhost:8080/resolve?mode=expand&url=https://nyti.ms/2FVHq9v' | jq .
+	{
+	    "given_url": "https://nyti.ms/2FVHq9v",
+	    "resolved_url": "https://www.nytimes.com/tips",
+	    "title": "",
+	    "intermediate_urls": [
+	        "https://nyti.ms/2FVHq9v"
+	    ]
+	}
+
+Optional timeout, max_redirects, fetch_title, and user_agent parameters tune
+how the URL is resolved, within limits set by the server. See the resolveopts
+package for details.
+
+The same parameters may instead be given in the JSON body of a POST request,
+which keeps long URLs out of request lines and access logs:
+
+	$ curl -s localhost:8080/resolve -d '{"url": "https://nyti.ms/2FVHq9v"}'
+
+Responses are compact JSON by default, or indented JSON if the ?pretty query
+parameter is given. Clients may instead request MessagePack via the Accept
+header, and job results may also be requested as CSV. Requests that accept
+none of an endpoint's supported media types are rejected with 406 Not
+Acceptable.
+
+Successful responses include a strong ETag, and a Last-Modified header if the
+result was served from the cache, and conditional requests using
+If-None-Match or If-Modified-Since are answered with 304 Not Modified when
+the response has not changed.
 */
 package httphandler
 
@@ -35,6 +73,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/honeycombio/beeline-go"
@@ -42,17 +81,30 @@ import (
 
 	"github.com/mccutchen/safedialer"
 	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostpolicy"
+	"github.com/mccutchen/urlresolverapi/pkg/pinning"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
 )
 
 // Errors that might be returned by the HTTP handler.
 var (
+	ErrBlockedURL     = errors.New("blocked URL")
+	ErrInvalidMode    = errors.New("invalid arg mode")
+	ErrInvalidOption  = errors.New("invalid resolve option")
 	ErrInvalidURL     = errors.New("invalid arg url")
 	ErrMissingURL     = errors.New("missing arg url")
+	ErrNotAcceptable  = errors.New("not acceptable")
+	ErrRateLimited    = errors.New("rate limited")
 	ErrRequestTimeout = errors.New("request timeout")
 	ErrResolveError   = errors.New("resolve error")
 	ErrUnsafeURL      = errors.New("unsafe URL")
 )
 
+// Response formats supported by the resolve endpoint.
+var resolveFormats = []responseFormat{formatJSON, formatMsgpack}
+
 // Cache control
 const (
 	maxAgeOK  = 365 * 24 * time.Hour
@@ -66,18 +118,108 @@ type ResolveResponse struct {
 	Title            string   `json:"title"`
 	IntermediateURLs []string `json:"intermediate_urls"`
 	Error            string   `json:"error,omitempty"`
+	ErrorCode        string   `json:"error_code,omitempty"`
+
+	// OriginalResolvedURL is the resolved URL before it was mapped to the
+	// canonical URL of an AMP or mobile page, if it was.
+	OriginalResolvedURL string `json:"original_resolved_url,omitempty"`
+
+	// ContentType is the media type of the resolved URL, if known.
+	ContentType string `json:"content_type,omitempty"`
+
+	// Interstitial indicates that the URL led to an interstitial (e.g. a
+	// cookie consent wall) that could not be bypassed, so the result stops
+	// at the URL before it.
+	Interstitial bool `json:"interstitial,omitempty"`
+
+	// RobotsDisallowed indicates that the title was not fetched because the
+	// resolved URL is disallowed by its host's robots.txt.
+	RobotsDisallowed bool `json:"robots_disallowed,omitempty"`
+}
+
+// ResolveRequest defines the body of POST requests to the HTTP handler, which
+// accept the same parameters as the query string of GET requests.
+type ResolveRequest struct {
+	URL  string `json:"url"`
+	Mode string `json:"mode,omitempty"`
+
+	// Optional per-request options, clamped to server and per-client limits
+	Timeout      string `json:"timeout,omitempty"`
+	MaxRedirects *int   `json:"max_redirects,omitempty"`
+	FetchTitle   *bool  `json:"fetch_title,omitempty"`
+	UserAgent    string `json:"user_agent,omitempty"`
+
+	// Optional response options, which do not affect how the URL is resolved
+	Detail   string `json:"detail,omitempty"`
+	Metadata *bool  `json:"metadata,omitempty"`
+}
+
+// maxResolveRequestSize limits the size of resolve request bodies.
+const maxResolveRequestSize = 64 << 10
+
+// Resolve modes.
+const (
+	ModeFull   = "full"
+	ModeExpand = "expand"
+)
+
+// Response detail levels. Basic responses omit intermediate URLs.
+const (
+	DetailFull  = "full"
+	DetailBasic = "basic"
+)
+
+// Option customizes a Handler.
+type Option func(*Handler)
+
+// WithExpandResolver sets the resolver used for mode=expand requests, which
+// should only follow redirects through known URL shorteners without fetching
+// titles. If not set, mode=expand requests are rejected.
+func WithExpandResolver(resolver urlresolver.Interface) Option {
+	return func(h *Handler) {
+		h.expandResolver = resolver
+	}
+}
+
+// WithCacheControl sets the Cache-Control policy for responses, along with
+// optional per-client overrides keyed by authenticated client ID (or
+// AnyClient). If not set, DefaultCacheControl is used.
+func WithCacheControl(policy CacheControl, overrides map[string]CacheControl) Option {
+	return func(h *Handler) {
+		h.cacheControl = policy
+		h.cacheControlOverrides = overrides
+	}
+}
+
+// WithResolvePolicy sets the policy limiting the per-request resolve options
+// clients may request. If not set, options are not limited.
+func WithResolvePolicy(policy resolveopts.Policy) Option {
+	return func(h *Handler) {
+		h.resolvePolicy = policy
+	}
 }
 
 // New creates a new Handler.
-func New(resolver urlresolver.Interface) *Handler {
-	return &Handler{
-		resolver: resolver,
+func New(resolver urlresolver.Interface, opts ...Option) *Handler {
+	h := &Handler{
+		resolver:     resolver,
+		cacheControl: DefaultCacheControl(),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // Handler is an HTTP request handler that can resolve URLs.
 type Handler struct {
-	resolver urlresolver.Interface
+	resolver       urlresolver.Interface
+	expandResolver urlresolver.Interface
+
+	cacheControl          CacheControl
+	cacheControlOverrides map[string]CacheControl
+
+	resolvePolicy resolveopts.Policy
 }
 
 var _ http.Handler = &Handler{} // Handler implements http.Handler
@@ -86,15 +228,65 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	d := ctxdata.From(ctx)
 
-	givenURL := r.URL.Query().Get("url")
+	format, ok := negotiate(w, r, resolveFormats...)
+	if !ok {
+		_ = d.Set("error", ErrNotAcceptable)
+		h.sendProblem(w, r, http.StatusNotAcceptable, problem.CodeNotAcceptable, notAcceptableDetail(resolveFormats...))
+		return
+	}
+
+	req, err := parseResolveRequest(w, r)
+	if errors.Is(err, ErrInvalidOption) {
+		_ = d.Set("error", err)
+		h.sendProblem(w, r, http.StatusBadRequest, problem.CodeInvalidOption, err.Error())
+		return
+	}
+	if err != nil {
+		_ = d.Set("error", fmt.Errorf("invalid resolve request: %w", err))
+		h.sendProblem(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	givenURL := req.URL
 	if givenURL == "" {
 		_ = d.Set("error", ErrMissingURL)
-		sendError(w, "Missing arg url", http.StatusBadRequest)
+		h.sendProblem(w, r, http.StatusBadRequest, problem.CodeMissingURL, "Missing arg url")
 		return
 	}
 	if !isValidInput(givenURL) {
 		_ = d.Set("error", fmt.Errorf("%w: %s", ErrInvalidURL, givenURL))
-		sendError(w, "Invalid url", http.StatusBadRequest)
+		h.sendProblem(w, r, http.StatusBadRequest, problem.CodeInvalidURL, "Invalid url")
+		return
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = ModeFull
+	}
+	resolver := h.resolverForMode(mode)
+	if resolver == nil {
+		_ = d.Set("error", fmt.Errorf("%w: %s", ErrInvalidMode, mode))
+		h.sendProblem(w, r, http.StatusBadRequest, problem.CodeInvalidMode, "Invalid mode")
+		return
+	}
+	beeline.AddField(ctx, "resolve_mode", mode)
+
+	opts, err := req.options(h.resolvePolicy.LimitsFor(d.GetString("client_id")))
+	if err != nil {
+		_ = d.Set("error", err)
+		h.sendProblem(w, r, http.StatusBadRequest, problem.CodeInvalidOption, err.Error())
+		return
+	}
+	ctx = resolveopts.NewContext(ctx, opts)
+
+	detail := req.Detail
+	if detail == "" {
+		detail = DetailFull
+	}
+	if detail != DetailFull && detail != DetailBasic {
+		err := fmt.Errorf("%w: invalid detail level %q", ErrInvalidOption, detail)
+		_ = d.Set("error", err)
+		h.sendProblem(w, r, http.StatusBadRequest, problem.CodeInvalidOption, err.Error())
 		return
 	}
 
@@ -104,7 +296,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//
 	// So, we always log the error, but we only return an error response if we
 	// did not manage to resolve the URL.
-	result, err := h.resolver.Resolve(ctx, givenURL)
+	ctx, rec := resultmeta.NewContext(ctx)
+	result, err := resolver.Resolve(ctx, givenURL)
 
 	resp := ResolveResponse{
 		GivenURL:    givenURL,
@@ -113,7 +306,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	// ensure our API response includes an empty list once encoded as JSON when
 	// result.IntermediateURLs is nil
-	if result.IntermediateURLs != nil {
+	if result.IntermediateURLs != nil && detail == DetailFull {
 		resp.IntermediateURLs = result.IntermediateURLs
 	} else {
 		resp.IntermediateURLs = []string{}
@@ -121,6 +314,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	beeline.AddField(ctx, "intermediate_url_count", len(resp.IntermediateURLs))
 
 	code := http.StatusOK
+	outcome := OutcomeOK
 	if err != nil {
 		d := ctxdata.From(r.Context())
 
@@ -142,9 +336,103 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		// Rewrite the error to hide implementation details
 		resp.Error = mapError(err).Error()
+		resp.ErrorCode = ErrorCode(err)
+		outcome = outcomeForError(err)
 	}
 
-	sendJSON(w, code, resp)
+	meta := rec.Meta()
+	if req.Metadata == nil || *req.Metadata {
+		resp.OriginalResolvedURL = meta.OriginalResolvedURL
+		resp.ContentType = meta.ContentType
+		resp.Interstitial = meta.Interstitial
+		resp.RobotsDisallowed = meta.RobotsDisallowed
+	}
+
+	// POST responses are keyed by a request body that caches never see, so
+	// they must not be stored or revalidated
+	if r.Method == http.MethodPost {
+		w.Header().Set("Cache-Control", "no-store")
+		writeResponse(w, r, format, code, resp)
+		return
+	}
+
+	// cached results may be revalidated against the time they were stored
+	var lastModified time.Time
+	if meta.CacheResult == resultmeta.CacheHit {
+		lastModified = meta.StoredAt
+	}
+	w.Header().Set("Cache-Control", h.cacheControlValue(r, outcome))
+	sendCacheableResponse(w, r, format, code, resp, lastModified)
+}
+
+// parseResolveRequest extracts the resolve request parameters from the JSON
+// body of POST requests, which keeps long URLs out of proxies' request lines
+// and access logs, or from the query string of any other request.
+func parseResolveRequest(w http.ResponseWriter, r *http.Request) (ResolveRequest, error) {
+	if r.Method != http.MethodPost {
+		q := r.URL.Query()
+		req := ResolveRequest{
+			URL:       q.Get("url"),
+			Mode:      q.Get("mode"),
+			Timeout:   q.Get("timeout"),
+			UserAgent: q.Get("user_agent"),
+			Detail:    q.Get("detail"),
+		}
+		if v := q.Get("metadata"); v != "" {
+			metadata, err := strconv.ParseBool(v)
+			if err != nil {
+				return ResolveRequest{}, fmt.Errorf("%w: invalid metadata %q", ErrInvalidOption, v)
+			}
+			req.Metadata = &metadata
+		}
+		optsReq, err := resolveopts.ParseRequest(q.Get("timeout"), q.Get("max_redirects"), q.Get("fetch_title"), q.Get("user_agent"))
+		if err != nil {
+			return ResolveRequest{}, fmt.Errorf("%w: %w", ErrInvalidOption, err)
+		}
+		req.MaxRedirects = optsReq.MaxRedirects
+		req.FetchTitle = optsReq.FetchTitle
+		return req, nil
+	}
+	var req ResolveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxResolveRequestSize)).Decode(&req); err != nil {
+		return ResolveRequest{}, err
+	}
+	return req, nil
+}
+
+// options returns the requested resolve options, clamped to the given
+// limits.
+func (req ResolveRequest) options(limits resolveopts.Limits) (resolveopts.Options, error) {
+	optsReq, err := resolveopts.ParseRequest(req.Timeout, "", "", req.UserAgent)
+	if err != nil {
+		return resolveopts.Options{}, fmt.Errorf("%w: %w", ErrInvalidOption, err)
+	}
+	optsReq.MaxRedirects = req.MaxRedirects
+	optsReq.FetchTitle = req.FetchTitle
+	opts, err := limits.Apply(optsReq)
+	if err != nil {
+		return resolveopts.Options{}, fmt.Errorf("%w: %w", ErrInvalidOption, err)
+	}
+	return opts, nil
+}
+
+// resolverForMode returns the resolver to use for the given mode, or nil if
+// the mode is invalid or not supported.
+func (h *Handler) resolverForMode(mode string) urlresolver.Interface {
+	switch mode {
+	case ModeFull:
+		return h.resolver
+	case ModeExpand:
+		return h.expandResolver
+	default:
+		return nil
+	}
+}
+
+// IsValidURL reports whether the given URL is acceptable input for
+// resolution.
+func IsValidURL(givenURL string) bool {
+	return isValidInput(givenURL)
 }
 
 func isValidInput(givenURL string) bool {
@@ -163,36 +451,45 @@ func isValidInput(givenURL string) bool {
 	return true
 }
 
-func sendJSON(w http.ResponseWriter, code int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", cacheControlValue(code))
-	w.WriteHeader(code)
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(data)
+func (h *Handler) sendProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	if r.Method == http.MethodPost {
+		sendUncachedProblem(w, r, status, code, detail)
+		return
+	}
+	w.Header().Set("Cache-Control", h.cacheControlValue(r, OutcomeClientError))
+	problem.Send(w, r, status, code, detail)
 }
 
-func sendError(w http.ResponseWriter, msg string, code int) {
-	sendJSON(w, code, map[string]string{
-		"error": msg,
-	})
+// MapError maps an error encountered while resolving a URL to one of the
+// errors above, hiding implementation details that should not be exposed to
+// clients.
+func MapError(err error) error {
+	return mapError(err)
 }
 
-func cacheControlValue(code int) string {
-	// Allow API responses to be cached aggressively
-	maxAge := maxAgeErr
-	if code == http.StatusOK {
-		maxAge = maxAgeOK
+// ErrorCode returns the stable, machine-readable code for an error
+// encountered while resolving a URL.
+func ErrorCode(err error) string {
+	switch mapError(err) {
+	case ErrRequestTimeout:
+		return problem.CodeUpstreamTimeout
+	case ErrUnsafeURL:
+		return problem.CodeUnsafeURL
+	case ErrBlockedURL:
+		return problem.CodeBlockedURL
+	default:
+		return problem.CodeResolveError
 	}
-	return fmt.Sprintf("public,max-age=%.0f", maxAge.Seconds())
 }
 
 func mapError(err error) error {
 	switch {
-	case isTimeoutError(err):
+	case errors.Is(err, ErrRequestTimeout) || isTimeoutError(err):
 		return ErrRequestTimeout
-	case isUnsafeError(err):
+	case errors.Is(err, ErrUnsafeURL) || isUnsafeError(err):
 		return ErrUnsafeURL
+	case errors.Is(err, ErrBlockedURL) || errors.Is(err, hostpolicy.ErrBlockedHost):
+		return ErrBlockedURL
 	default:
 		return ErrResolveError
 	}
@@ -206,7 +503,10 @@ func isTimeoutError(err error) bool {
 }
 
 func isUnsafeError(err error) bool {
-	return errors.Is(err, safedialer.ErrUnsafeIP) ||
+	var unsafeAddrErr *pinning.UnsafeAddressError
+	return errors.As(err, &unsafeAddrErr) ||
+		errors.Is(err, pinning.ErrUnpinnedAddress) ||
+		errors.Is(err, safedialer.ErrUnsafeIP) ||
 		errors.Is(err, safedialer.ErrUnsafePort) ||
 		errors.Is(err, safedialer.ErrUnsafeNetwork)
 }
@@ -10,13 +10,21 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/peterbourgon/ctxdata/v4"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mccutchen/safedialer"
 	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/hostpolicy"
+	"github.com/mccutchen/urlresolverapi/pkg/pinning"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
 	"github.com/mccutchen/urlresolverapi/pkg/tracetransport"
 )
 
@@ -24,10 +32,11 @@ func TestRouting(t *testing.T) {
 	t.Parallel()
 
 	type testCase struct {
-		method   string
-		url      string
-		wantCode int
-		wantBody string
+		method      string
+		url         string
+		wantCode    int
+		wantBody    string
+		wantProblem string
 	}
 	testCases := map[string]testCase{
 		"lookup valid url ok": {
@@ -43,28 +52,52 @@ func TestRouting(t *testing.T) {
 			wantBody: "{{remoteSrv}}",
 		},
 		"lookup arg required": {
-			method:   "GET",
-			url:      "/lookup?foo",
-			wantCode: http.StatusBadRequest,
-			wantBody: "Missing arg url",
+			method:      "GET",
+			url:         "/lookup?foo",
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "Missing arg url",
+			wantProblem: problem.CodeMissingURL,
 		},
 		"lookup arg must be valid URL": {
-			method:   "GET",
-			url:      "/lookup?url=" + url.QueryEscape("%%"),
-			wantCode: http.StatusBadRequest,
-			wantBody: "Invalid url",
+			method:      "GET",
+			url:         "/lookup?url=" + url.QueryEscape("%%"),
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "Invalid url",
+			wantProblem: problem.CodeInvalidURL,
 		},
 		"lookup arg must be absolute URL": {
-			method:   "GET",
-			url:      `/lookup?url=path/to/foo`,
-			wantCode: http.StatusBadRequest,
-			wantBody: "Invalid url",
+			method:      "GET",
+			url:         `/lookup?url=path/to/foo`,
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "Invalid url",
+			wantProblem: problem.CodeInvalidURL,
 		},
 		"lookup arg must have hostname": {
+			method:      "GET",
+			url:         `/lookup?url=https:///path/to/foo`,
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "Invalid url",
+			wantProblem: problem.CodeInvalidURL,
+		},
+		"lookup mode must be valid": {
+			method:      "GET",
+			url:         "/lookup?mode=foo&url={{remoteSrv}}",
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "Invalid mode",
+			wantProblem: problem.CodeInvalidMode,
+		},
+		"lookup expand mode requires expand resolver": {
+			method:      "GET",
+			url:         "/lookup?mode=expand&url={{remoteSrv}}",
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "Invalid mode",
+			wantProblem: problem.CodeInvalidMode,
+		},
+		"lookup full mode ok": {
 			method:   "GET",
-			url:      `/lookup?url=https:///path/to/foo`,
-			wantCode: http.StatusBadRequest,
-			wantBody: "Invalid url",
+			url:      "/lookup?mode=full&url={{remoteSrv}}",
+			wantCode: http.StatusOK,
+			wantBody: "{{remoteSrv}}",
 		},
 	}
 
@@ -103,6 +136,13 @@ func TestRouting(t *testing.T) {
 			if !strings.Contains(w.Body.String(), tc.wantBody) {
 				t.Errorf("expected %q in body %q", tc.wantBody, w.Body.String())
 			}
+			if tc.wantProblem != "" {
+				var p problem.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, tc.wantProblem, p.Code)
+				assert.Equal(t, tc.wantCode, p.Status)
+				assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+			}
 		})
 	}
 }
@@ -155,6 +195,7 @@ func TestResolve(t *testing.T) {
 			upstreamReqTimeout: 5 * time.Millisecond,
 			wantResult: ResolveResponse{
 				Error:            ErrRequestTimeout.Error(),
+				ErrorCode:        problem.CodeUpstreamTimeout,
 				GivenURL:         "/foo?utm_tag=foo",
 				IntermediateURLs: []string{},
 				ResolvedURL:      "/foo",
@@ -195,6 +236,7 @@ func TestResolve(t *testing.T) {
 			wantCode:           http.StatusNonAuthoritativeInfo,
 			wantResult: ResolveResponse{
 				Error:            ErrRequestTimeout.Error(),
+				ErrorCode:        problem.CodeUpstreamTimeout,
 				GivenURL:         "/redirect",
 				IntermediateURLs: []string{"/redirect"},
 				ResolvedURL:      "/resolved",
@@ -209,6 +251,7 @@ func TestResolve(t *testing.T) {
 			wantCode:   http.StatusNonAuthoritativeInfo,
 			wantResult: ResolveResponse{
 				Error:            ErrResolveError.Error(),
+				ErrorCode:        problem.CodeResolveError,
 				GivenURL:         "/foo?utm_param=bar",
 				IntermediateURLs: []string{},
 				ResolvedURL:      "/foo",
@@ -224,6 +267,24 @@ func TestResolve(t *testing.T) {
 			wantCode: http.StatusNonAuthoritativeInfo,
 			wantResult: ResolveResponse{
 				Error:            ErrUnsafeURL.Error(),
+				ErrorCode:        problem.CodeUnsafeURL,
+				GivenURL:         "/foo?utm_param=bar",
+				IntermediateURLs: []string{},
+				ResolvedURL:      "/foo",
+				Title:            "",
+			},
+		},
+		"request to blocked upstream fails": {
+			remoteHandler: func(w http.ResponseWriter, r *http.Request) {},
+			remotePath:    "/foo?utm_param=bar",
+			transport: hostpolicy.NewTransport(
+				hostpolicy.New(nil, hostmatch.List{hostmatch.MustParse("127.0.0.1")}),
+				http.DefaultTransport,
+			),
+			wantCode: http.StatusNonAuthoritativeInfo,
+			wantResult: ResolveResponse{
+				Error:            ErrBlockedURL.Error(),
+				ErrorCode:        problem.CodeBlockedURL,
 				GivenURL:         "/foo?utm_param=bar",
 				IntermediateURLs: []string{},
 				ResolvedURL:      "/foo",
@@ -322,6 +383,453 @@ func TestResolve(t *testing.T) {
 	}
 }
 
+func TestExpandMode(t *testing.T) {
+	t.Parallel()
+
+	fullResolver := stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/full", Title: "title"}}
+	expandResolver := stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/expanded"}}
+	handler := New(fullResolver, WithExpandResolver(expandResolver))
+
+	testCases := map[string]struct {
+		url  string
+		want string
+	}{
+		"default mode": {"/lookup?url=https://t.co/foo", "https://example.com/full"},
+		"full mode":    {"/lookup?mode=full&url=https://t.co/foo", "https://example.com/full"},
+		"expand mode":  {"/lookup?mode=expand&url=https://t.co/foo", "https://example.com/expanded"},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var resp ResolveResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tc.want, resp.ResolvedURL)
+		})
+	}
+}
+
+func TestResolvePost(t *testing.T) {
+	t.Parallel()
+
+	fullResolver := stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/full", Title: "title"}}
+	expandResolver := stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/expanded"}}
+	handler := New(fullResolver, WithExpandResolver(expandResolver))
+
+	testCases := map[string]struct {
+		url         string
+		body        string
+		wantCode    int
+		wantURL     string
+		wantProblem string
+	}{
+		"full mode": {
+			body:     `{"url": "https://t.co/foo"}`,
+			wantCode: http.StatusOK,
+			wantURL:  "https://example.com/full",
+		},
+		"expand mode": {
+			body:     `{"url": "https://t.co/foo", "mode": "expand"}`,
+			wantCode: http.StatusOK,
+			wantURL:  "https://example.com/expanded",
+		},
+		"query string is ignored": {
+			url:         "/resolve?url=https://t.co/foo",
+			body:        `{}`,
+			wantCode:    http.StatusBadRequest,
+			wantProblem: problem.CodeMissingURL,
+		},
+		"invalid url": {
+			body:        `{"url": "path/to/foo"}`,
+			wantCode:    http.StatusBadRequest,
+			wantProblem: problem.CodeInvalidURL,
+		},
+		"invalid mode": {
+			body:        `{"url": "https://t.co/foo", "mode": "bogus"}`,
+			wantCode:    http.StatusBadRequest,
+			wantProblem: problem.CodeInvalidMode,
+		},
+		"invalid body": {
+			body:        `{"url": `,
+			wantCode:    http.StatusBadRequest,
+			wantProblem: problem.CodeInvalidRequest,
+		},
+		"body too large": {
+			body:        `{"url": "https://t.co/` + strings.Repeat("a", maxResolveRequestSize) + `"}`,
+			wantCode:    http.StatusBadRequest,
+			wantProblem: problem.CodeInvalidRequest,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			url := tc.url
+			if url == "" {
+				url = "/resolve"
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", url, strings.NewReader(tc.body)))
+			assert.Equal(t, tc.wantCode, w.Code)
+
+			// POST responses are never cacheable
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			assert.Equal(t, "", w.Header().Get("ETag"))
+
+			if tc.wantProblem != "" {
+				var p problem.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, tc.wantProblem, p.Code)
+				return
+			}
+			var resp ResolveResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, "https://t.co/foo", resp.GivenURL)
+			assert.Equal(t, tc.wantURL, resp.ResolvedURL)
+		})
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	t.Parallel()
+
+	policy := resolveopts.Policy{
+		Limits:  resolveopts.Limits{MaxTimeout: 10 * time.Second, MaxRedirects: 5},
+		Clients: map[string]resolveopts.Limits{"client": {MaxTimeout: time.Second}},
+	}
+
+	testCases := map[string]struct {
+		method      string
+		url         string
+		body        string
+		clientID    string
+		want        resolveopts.Options
+		wantProblem string
+	}{
+		"defaults": {
+			url:  "/resolve?url=https://t.co/foo",
+			want: resolveopts.Options{Timeout: 10 * time.Second, MaxRedirects: 5, FetchTitle: true, UserAgent: resolveopts.UserAgentBrowser},
+		},
+		"query options": {
+			url:  "/resolve?url=https://t.co/foo&timeout=2s&max_redirects=2&fetch_title=false&user_agent=mobile",
+			want: resolveopts.Options{Timeout: 2 * time.Second, MaxRedirects: 2, FetchTitle: false, UserAgent: resolveopts.UserAgentMobile},
+		},
+		"body options": {
+			method: "POST",
+			url:    "/resolve",
+			body:   `{"url": "https://t.co/foo", "timeout": "2s", "max_redirects": 0, "fetch_title": false, "user_agent": "bot"}`,
+			want:   resolveopts.Options{Timeout: 2 * time.Second, MaxRedirects: 0, FetchTitle: false, UserAgent: resolveopts.UserAgentBot},
+		},
+		"clamped to server limits": {
+			url:  "/resolve?url=https://t.co/foo&timeout=1m&max_redirects=50",
+			want: resolveopts.Options{Timeout: 10 * time.Second, MaxRedirects: 5, FetchTitle: true, UserAgent: resolveopts.UserAgentBrowser},
+		},
+		"clamped to client limits": {
+			url:      "/resolve?url=https://t.co/foo&timeout=5s",
+			clientID: "client",
+			want:     resolveopts.Options{Timeout: time.Second, MaxRedirects: 5, FetchTitle: true, UserAgent: resolveopts.UserAgentBrowser},
+		},
+		"invalid query timeout": {
+			url:         "/resolve?url=https://t.co/foo&timeout=soon",
+			wantProblem: problem.CodeInvalidOption,
+		},
+		"invalid query max redirects": {
+			url:         "/resolve?url=https://t.co/foo&max_redirects=many",
+			wantProblem: problem.CodeInvalidOption,
+		},
+		"invalid body timeout": {
+			method:      "POST",
+			url:         "/resolve",
+			body:        `{"url": "https://t.co/foo", "timeout": "soon"}`,
+			wantProblem: problem.CodeInvalidOption,
+		},
+		"negative max redirects": {
+			method:      "POST",
+			url:         "/resolve",
+			body:        `{"url": "https://t.co/foo", "max_redirects": -1}`,
+			wantProblem: problem.CodeInvalidOption,
+		},
+		"unknown user agent": {
+			url:         "/resolve?url=https://t.co/foo&user_agent=netscape",
+			wantProblem: problem.CodeInvalidOption,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			resolver := &optsResolver{}
+			handler := New(resolver, WithResolvePolicy(policy))
+
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			ctx, d := ctxdata.New(context.Background())
+			if tc.clientID != "" {
+				_ = d.Set("client_id", tc.clientID)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(method, tc.url, strings.NewReader(tc.body)).WithContext(ctx))
+
+			if tc.wantProblem != "" {
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				var p problem.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, tc.wantProblem, p.Code)
+				return
+			}
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, resolver.opts)
+		})
+	}
+}
+
+// optsResolver is a stub resolver that records the options it was called
+// with.
+type optsResolver struct {
+	opts resolveopts.Options
+}
+
+func (r *optsResolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	r.opts = resolveopts.FromContext(ctx)
+	return urlresolver.Result{ResolvedURL: givenURL}, nil
+}
+
+type stubResolver struct {
+	result urlresolver.Result
+	err    error
+}
+
+func (r stubResolver) Resolve(_ context.Context, _ string) (urlresolver.Result, error) {
+	return r.result, r.err
+}
+
+func TestResolveRobotsDisallowed(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		meta     resultmeta.Meta
+		wantBody string
+	}{
+		"disallowed": {
+			meta:     resultmeta.Meta{RobotsDisallowed: true},
+			wantBody: `{"given_url":"https://t.co/foo","resolved_url":"https://example.com/private","title":"","intermediate_urls":[],"robots_disallowed":true}`,
+		},
+		"allowed": {
+			wantBody: `{"given_url":"https://t.co/foo","resolved_url":"https://example.com/private","title":"","intermediate_urls":[]}`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			handler := New(metaResolver{
+				result: urlresolver.Result{ResolvedURL: "https://example.com/private"},
+				meta:   tc.meta,
+			})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, tc.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestResolveResponseOptions(t *testing.T) {
+	t.Parallel()
+
+	handler := New(metaResolver{
+		result: urlresolver.Result{
+			ResolvedURL:      "https://example.com/article",
+			Title:            "title",
+			IntermediateURLs: []string{"https://t.co/foo"},
+		},
+		meta: resultmeta.Meta{ContentType: "text/html"},
+	})
+
+	testCases := map[string]struct {
+		method      string
+		url         string
+		body        string
+		wantBody    string
+		wantProblem string
+	}{
+		"defaults": {
+			url:      "/resolve?url=https://t.co/foo",
+			wantBody: `{"given_url":"https://t.co/foo","resolved_url":"https://example.com/article","title":"title","intermediate_urls":["https://t.co/foo"],"content_type":"text/html"}`,
+		},
+		"basic detail": {
+			url:      "/resolve?url=https://t.co/foo&detail=basic",
+			wantBody: `{"given_url":"https://t.co/foo","resolved_url":"https://example.com/article","title":"title","intermediate_urls":[],"content_type":"text/html"}`,
+		},
+		"without metadata": {
+			url:      "/resolve?url=https://t.co/foo&metadata=false",
+			wantBody: `{"given_url":"https://t.co/foo","resolved_url":"https://example.com/article","title":"title","intermediate_urls":["https://t.co/foo"]}`,
+		},
+		"body options": {
+			method:   "POST",
+			url:      "/resolve",
+			body:     `{"url": "https://t.co/foo", "detail": "basic", "metadata": false}`,
+			wantBody: `{"given_url":"https://t.co/foo","resolved_url":"https://example.com/article","title":"title","intermediate_urls":[]}`,
+		},
+		"invalid detail": {
+			url:         "/resolve?url=https://t.co/foo&detail=verbose",
+			wantProblem: problem.CodeInvalidOption,
+		},
+		"invalid metadata": {
+			url:         "/resolve?url=https://t.co/foo&metadata=maybe",
+			wantProblem: problem.CodeInvalidOption,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(method, tc.url, strings.NewReader(tc.body)))
+
+			if tc.wantProblem != "" {
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+				var p problem.Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, tc.wantProblem, p.Code)
+				return
+			}
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, tc.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestResolveOriginalResolvedURL(t *testing.T) {
+	t.Parallel()
+
+	handler := New(metaResolver{
+		result: urlresolver.Result{ResolvedURL: "https://example.com/article"},
+		meta:   resultmeta.Meta{OriginalResolvedURL: "https://amp.example.com/article"},
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"given_url":"https://t.co/foo","resolved_url":"https://example.com/article","title":"","intermediate_urls":[],"original_resolved_url":"https://amp.example.com/article"}`, w.Body.String())
+}
+
+func TestResolveUnsafeHops(t *testing.T) {
+	t.Parallel()
+
+	remoteSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.URL.Query().Get("to"); target != "" {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		w.Write([]byte(`<title>title</title>`))
+	}))
+	t.Cleanup(remoteSrv.Close)
+	_, port, _ := net.SplitHostPort(remoteSrv.Listener.Addr().String())
+	hostURL := func(host string, query string) string {
+		return "http://" + net.JoinHostPort(host, port) + "/" + query
+	}
+
+	// only the test server's loopback address is considered safe
+	resolver := stubLookupResolver{
+		"safe.test":   {"127.0.0.1"},
+		"mixed.test":  {"127.0.0.1", "10.0.0.1"},
+		"unsafe.test": {"192.168.0.1"},
+	}
+	control := func(_ string, address string, _ syscall.RawConn) error {
+		if host, _, _ := net.SplitHostPort(address); host != "127.0.0.1" {
+			return safedialer.ErrUnsafeIP
+		}
+		return nil
+	}
+	transport := &http.Transport{
+		DialContext: pinning.NewDialer(resolver, control).DialContext,
+	}
+	handler := New(urlresolver.New(transport, 0))
+
+	testCases := map[string]struct {
+		givenURL     string
+		wantCode     int
+		wantHops     []string
+		wantResolved string
+	}{
+		"safe": {
+			givenURL:     hostURL("safe.test", ""),
+			wantCode:     http.StatusOK,
+			wantHops:     []string{},
+			wantResolved: hostURL("safe.test", ""),
+		},
+		"first hop resolves to mixed addresses": {
+			givenURL:     hostURL("mixed.test", ""),
+			wantCode:     http.StatusNonAuthoritativeInfo,
+			wantHops:     []string{},
+			wantResolved: hostURL("mixed.test", ""),
+		},
+		"redirect hop resolves to mixed addresses": {
+			givenURL:     hostURL("safe.test", "?to="+url.QueryEscape(hostURL("mixed.test", ""))),
+			wantCode:     http.StatusNonAuthoritativeInfo,
+			wantHops:     []string{hostURL("safe.test", "?to="+url.QueryEscape(hostURL("mixed.test", "")))},
+			wantResolved: hostURL("mixed.test", ""),
+		},
+		"redirect hop resolves to unsafe address": {
+			givenURL:     hostURL("safe.test", "?to="+url.QueryEscape(hostURL("unsafe.test", ""))),
+			wantCode:     http.StatusNonAuthoritativeInfo,
+			wantHops:     []string{hostURL("safe.test", "?to="+url.QueryEscape(hostURL("unsafe.test", "")))},
+			wantResolved: hostURL("unsafe.test", ""),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/resolve?url="+url.QueryEscape(tc.givenURL), nil)
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tc.wantCode, w.Code)
+
+			var result ResolveResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, tc.wantHops, result.IntermediateURLs)
+			assert.Equal(t, tc.wantResolved, result.ResolvedURL)
+			if tc.wantCode == http.StatusOK {
+				assert.Equal(t, "", result.Error)
+			} else {
+				assert.Equal(t, ErrUnsafeURL.Error(), result.Error)
+				assert.Equal(t, problem.CodeUnsafeURL, result.ErrorCode)
+			}
+		})
+	}
+}
+
+// stubLookupResolver resolves hostnames to fixed IP addresses.
+type stubLookupResolver map[string][]string
+
+func (r stubLookupResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, found := r[host]
+	if !found {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
 func newLookupRequest(ctx context.Context, t *testing.T, resolverSrv *httptest.Server, remoteSrv *httptest.Server, remotePath string) *http.Request {
 	t.Helper()
 
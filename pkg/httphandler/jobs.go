@@ -0,0 +1,149 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/honeycombio/beeline-go"
+	"github.com/peterbourgon/ctxdata/v4"
+
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler/middleware"
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+)
+
+// maxJobRequestSize limits the size of job request bodies.
+const maxJobRequestSize = 1 << 20
+
+// JobRequest defines the request body used to submit a job.
+type JobRequest struct {
+	URLs        []string `json:"urls"`
+	CallbackURL string   `json:"callback_url,omitempty"`
+}
+
+// JobsHandler provides HTTP handlers for submitting asynchronous resolve
+// jobs and querying their status:
+//
+//	POST /jobs       submit a new job, responding with 202 Accepted
+//	GET  /jobs/{id}  get the status and results of a job
+//
+// Job state changes over time, so job responses are never cacheable.
+type JobsHandler struct {
+	runner *jobs.Runner
+}
+
+// NewJobsHandler creates a new JobsHandler.
+func NewJobsHandler(runner *jobs.Runner) *JobsHandler {
+	return &JobsHandler{
+		runner: runner,
+	}
+}
+
+// Response formats supported by the job endpoints. Job results may also be
+// exported as CSV.
+var (
+	jobFormats       = []responseFormat{formatJSON, formatMsgpack}
+	jobStatusFormats = []responseFormat{formatJSON, formatMsgpack, formatCSV}
+)
+
+// Create handles requests to submit a new job.
+func (h *JobsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	d := ctxdata.From(ctx)
+
+	format, ok := negotiate(w, r, jobFormats...)
+	if !ok {
+		_ = d.Set("error", ErrNotAcceptable)
+		sendUncachedProblem(w, r, http.StatusNotAcceptable, problem.CodeNotAcceptable, notAcceptableDetail(jobFormats...))
+		return
+	}
+
+	var req JobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestSize)).Decode(&req); err != nil {
+		_ = d.Set("error", fmt.Errorf("invalid job request: %w", err))
+		sendUncachedProblem(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	for _, givenURL := range req.URLs {
+		if !isValidInput(givenURL) {
+			_ = d.Set("error", fmt.Errorf("%w: %s", ErrInvalidURL, givenURL))
+			sendUncachedProblem(w, r, http.StatusBadRequest, problem.CodeInvalidURL, "Invalid url: "+givenURL)
+			return
+		}
+	}
+
+	// anonymous jobs are charged for every URL, as if each were requested
+	// separately
+	if ok, msg := middleware.AllowURLs(ctx, len(req.URLs)); !ok {
+		_ = d.Set("error", ErrRateLimited)
+		w.Header().Set("Retry-After", "1")
+		sendUncachedProblem(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, msg)
+		return
+	}
+
+	job, err := h.runner.Submit(ctx, req.URLs, req.CallbackURL)
+	if err != nil {
+		_ = d.Set("error", err)
+		switch {
+		case errors.Is(err, jobs.ErrNoURLs):
+			sendUncachedProblem(w, r, http.StatusBadRequest, problem.CodeMissingURL, err.Error())
+		case errors.Is(err, jobs.ErrTooManyURLs):
+			sendUncachedProblem(w, r, http.StatusBadRequest, problem.CodeTooManyURLs, err.Error())
+		case errors.Is(err, jobs.ErrInvalidCallbackURL):
+			sendUncachedProblem(w, r, http.StatusBadRequest, problem.CodeInvalidCallbackURL, err.Error())
+		case errors.Is(err, jobs.ErrCallbacksDisabled):
+			sendUncachedProblem(w, r, http.StatusBadRequest, problem.CodeCallbacksDisabled, err.Error())
+		case errors.Is(err, jobs.ErrQueueFull), errors.Is(err, jobs.ErrShuttingDown):
+			w.Header().Set("Retry-After", "1")
+			sendUncachedProblem(w, r, http.StatusServiceUnavailable, problem.CodeUnavailable, err.Error())
+		default:
+			sendUncachedProblem(w, r, http.StatusInternalServerError, problem.CodeInternalError, "Error submitting job")
+		}
+		return
+	}
+
+	// the job's location is relative to the path the request was made to,
+	// which may or may not include the API version prefix
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+job.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, format, http.StatusAccepted, job)
+}
+
+// Status handles requests for the status and results of a job.
+func (h *JobsHandler) Status(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	d := ctxdata.From(ctx)
+
+	format, ok := negotiate(w, r, jobStatusFormats...)
+	if !ok {
+		_ = d.Set("error", ErrNotAcceptable)
+		sendUncachedProblem(w, r, http.StatusNotAcceptable, problem.CodeNotAcceptable, notAcceptableDetail(jobStatusFormats...))
+		return
+	}
+
+	id := r.PathValue("id")
+	beeline.AddField(ctx, "job.id", id)
+
+	job, err := h.runner.Get(ctx, id)
+	if err != nil {
+		_ = d.Set("error", err)
+		if errors.Is(err, jobs.ErrNotFound) {
+			sendUncachedProblem(w, r, http.StatusNotFound, problem.CodeNotFound, "Job not found")
+			return
+		}
+		sendUncachedProblem(w, r, http.StatusInternalServerError, problem.CodeInternalError, "Error getting job")
+		return
+	}
+
+	beeline.AddField(ctx, "job.status", job.Status)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, format, http.StatusOK, job)
+}
+
+func sendUncachedProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	w.Header().Set("Cache-Control", "no-store")
+	problem.Send(w, r, status, code, detail)
+}
@@ -0,0 +1,193 @@
+package httphandler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/httphandler/middleware"
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+)
+
+func TestJobsHandler(t *testing.T) {
+	t.Parallel()
+
+	runner := jobs.NewRunner(
+		stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/resolved", Title: "title"}},
+		jobs.NewMemoryStore(time.Hour),
+		jobs.Options{QueueSize: 10, MaxURLs: 2},
+	)
+	t.Cleanup(func() { _ = runner.Shutdown(context.Background()) })
+
+	handler := NewJobsHandler(runner)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", handler.Create)
+	mux.HandleFunc("GET /jobs/{id}", handler.Status)
+
+	t.Run("create and get job", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"urls": ["https://t.co/a"]}`)))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		var job jobs.Job
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+		assert.Equal(t, jobs.StatusPending, job.Status)
+		assert.Equal(t, "/jobs/"+job.ID, w.Header().Get("Location"))
+
+		assert.Eventually(t, func() bool {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+			if w.Code != http.StatusOK {
+				return false
+			}
+			var got jobs.Job
+			_ = json.Unmarshal(w.Body.Bytes(), &got)
+			return got.Status == jobs.StatusCompleted && len(got.Results) == 1 && got.Results[0].Title == "title"
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	errorCases := map[string]struct {
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		"invalid body": {
+			method:   "POST",
+			path:     "/jobs",
+			body:     `{"urls": `,
+			wantCode: http.StatusBadRequest,
+			wantBody: "Invalid request body",
+		},
+		"invalid url": {
+			method:   "POST",
+			path:     "/jobs",
+			body:     `{"urls": ["path/to/foo"]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "Invalid url",
+		},
+		"no urls": {
+			method:   "POST",
+			path:     "/jobs",
+			body:     `{"urls": []}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "no urls",
+		},
+		"too many urls": {
+			method:   "POST",
+			path:     "/jobs",
+			body:     `{"urls": ["https://a.com", "https://b.com", "https://c.com"]}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "too many urls",
+		},
+		"callbacks disabled": {
+			method:   "POST",
+			path:     "/jobs",
+			body:     `{"urls": ["https://a.com"], "callback_url": "https://callback.example"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "callbacks disabled",
+		},
+		"job not found": {
+			method:   "GET",
+			path:     "/jobs/does-not-exist",
+			wantCode: http.StatusNotFound,
+			wantBody: "Job not found",
+		},
+	}
+	for name, tc := range errorCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Contains(t, w.Body.String(), tc.wantBody)
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestJobsHandlerRateLimit(t *testing.T) {
+	t.Parallel()
+
+	runner := jobs.NewRunner(
+		stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/resolved"}},
+		jobs.NewMemoryStore(time.Hour),
+		jobs.Options{QueueSize: 10, MaxURLs: 10},
+	)
+	t.Cleanup(func() { _ = runner.Shutdown(context.Background()) })
+
+	handler := NewJobsHandler(runner)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", handler.Create)
+
+	testCases := map[string]struct {
+		authToken string
+		body      string
+		wantCode  int
+		wantBody  string
+	}{
+		"anonymous job within burst limit": {
+			body:     `{"urls": ["https://a.com", "https://b.com", "https://c.com"]}`,
+			wantCode: http.StatusAccepted,
+		},
+		"anonymous job larger than burst limit": {
+			body:     `{"urls": ["https://a.com", "https://b.com", "https://c.com", "https://d.com"]}`,
+			wantCode: http.StatusTooManyRequests,
+			wantBody: "at most 3 URLs",
+		},
+		"authenticated job larger than burst limit": {
+			authToken: "valid-token",
+			body:      `{"urls": ["https://a.com", "https://b.com", "https://c.com", "https://d.com"]}`,
+			wantCode:  http.StatusAccepted,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// each test case gets its own limiter, so that they don't
+			// interfere with each other
+			h := middleware.Wrap(mux, middleware.AuthMap{"valid-token": "client-1"}, rate.NewLimiter(rate.Every(time.Hour), 3), zerolog.Nop())
+
+			r := httptest.NewRequest("POST", "/jobs", strings.NewReader(tc.body))
+			if tc.authToken != "" {
+				r.Header.Set("Authorization", "Token "+tc.authToken)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Contains(t, w.Body.String(), tc.wantBody)
+		})
+	}
+
+	t.Run("every url in an anonymous job is charged", func(t *testing.T) {
+		t.Parallel()
+
+		h := middleware.Wrap(mux, nil, rate.NewLimiter(rate.Every(time.Hour), 3), zerolog.Nop())
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"urls": ["https://a.com", "https://b.com"]}`)))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		// only one token is left, which is not enough for two more urls
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"urls": ["https://a.com", "https://b.com"]}`)))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/honeycombio/beeline-go"
 	"github.com/peterbourgon/ctxdata/v4"
+
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
 )
 
 // AuthMap maps from opaque token value to client ID.
@@ -15,32 +17,39 @@ type AuthMap map[string]string
 
 func authHandler(next http.Handler, authMap AuthMap) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ctx = r.Context()
-			d   = ctxdata.From(ctx)
-		)
-
-		clientID, err := authenticate(r, authMap)
+		ctx, err := authenticate(r.Context(), r.Header.Get("Authorization"), authMap)
 		if err != nil {
-			beeline.AddField(ctx, "client_authenticated", false)
-			beeline.AddField(ctx, "error", err)
-			sendAuthError(w)
+			problem.Send(w, r, http.StatusForbidden, problem.CodeUnauthorized, "Invalid or malformed auth token")
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		beeline.AddField(ctx, "client_authenticated", clientID != "")
-		beeline.AddField(ctx, "client_id", clientID)
-		_ = d.Set("client_id", clientID)
+// authenticate checks the given Authorization header value against the auth
+// map, returning a context carrying the authenticated client ID (which is
+// empty for anonymous requests).
+func authenticate(ctx context.Context, authHeader string, authMap AuthMap) (context.Context, error) {
+	d := ctxdata.From(ctx)
 
-		r = r.WithContext(contextWithClientID(r.Context(), clientID))
-		next.ServeHTTP(w, r)
-	})
+	clientID, err := lookupClientID(ctx, authHeader, authMap)
+	if err != nil {
+		beeline.AddField(ctx, "client_authenticated", false)
+		beeline.AddField(ctx, "error", err)
+		return ctx, err
+	}
+
+	beeline.AddField(ctx, "client_authenticated", clientID != "")
+	beeline.AddField(ctx, "client_id", clientID)
+	_ = d.Set("client_id", clientID)
+
+	return contextWithClientID(ctx, clientID), nil
 }
 
-func authenticate(r *http.Request, authMap AuthMap) (string, error) {
-	tok, err := authTokenFromRequest(r)
+func lookupClientID(ctx context.Context, authHeader string, authMap AuthMap) (string, error) {
+	tok, err := authTokenFromHeader(authHeader)
 	if err != nil {
-		beeline.AddField(r.Context(), "auth_result", "error")
+		beeline.AddField(ctx, "auth_result", "error")
 		return "", err
 	}
 
@@ -73,8 +82,7 @@ func contextWithClientID(ctx context.Context, clientID string) context.Context {
 	return context.WithValue(ctx, clientIDKey, clientID)
 }
 
-func authTokenFromRequest(r *http.Request) (string, error) {
-	val := r.Header.Get("Authorization")
+func authTokenFromHeader(val string) (string, error) {
 	if val == "" {
 		return "", nil
 	}
@@ -91,12 +99,6 @@ func authTokenFromRequest(r *http.Request) (string, error) {
 	return parts[1], nil
 }
 
-func sendAuthError(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusForbidden)
-	_, _ = w.Write([]byte(`{"error": "unauthorized"}`))
-}
-
 // ParseAuthMap takes a slice of token strings in "client-id:token-value"
 // form and returns a mapping from token value to client ID.
 func ParseAuthMap(tokenConfig string) (AuthMap, error) {
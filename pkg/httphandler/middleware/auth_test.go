@@ -1,12 +1,15 @@
 package middleware
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
 )
 
 func TestAuthHandler(t *testing.T) {
@@ -69,6 +72,9 @@ func TestAuthHandler(t *testing.T) {
 			resp, err := http.DefaultClient.Do(req)
 			assert.Nil(t, err)
 			assert.Equal(t, tc.wantStatus, resp.StatusCode)
+			if tc.wantStatus == http.StatusForbidden {
+				assertProblemCode(t, resp, problem.CodeUnauthorized)
+			}
 		})
 	}
 }
@@ -123,3 +129,12 @@ func TestParseAuthMap(t *testing.T) {
 	}
 
 }
+
+func assertProblemCode(t *testing.T, resp *http.Response, wantCode string) {
+	t.Helper()
+	defer resp.Body.Close()
+	assert.Equal(t, problem.ContentType, resp.Header.Get("Content-Type"))
+	var p problem.Problem
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, wantCode, p.Code)
+}
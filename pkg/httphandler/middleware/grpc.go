@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+	ctxdata "github.com/peterbourgon/ctxdata/v4"
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
+)
+
+// batchRequest is implemented by gRPC request messages containing multiple
+// URLs, each of which counts against the anonymous rate limit.
+type batchRequest interface {
+	GetUrls() []string
+}
+
+// The gRPC interceptors share authenticate and allowRequest with the HTTP
+// middleware, so that both protocols apply the same auth and rate limiting
+// policies.
+
+// UnaryServerInterceptor returns a gRPC interceptor that applies the same
+// instrumentation, error handling, authentication, and rate limiting as
+// Wrap does for HTTP handlers.
+func UnaryServerInterceptor(authMap AuthMap, rl *rate.Limiter, l zerolog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		err = observeGRPC(ctx, info.FullMethod, authMap, l, func(ctx context.Context) error {
+			if n := requestCost(req); !allowRequest(ctx, rl, n) {
+				return rateLimitStatus(rl, n)
+			}
+			var err error
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a gRPC interceptor that applies the same
+// instrumentation, error handling, authentication, and rate limiting as
+// Wrap does for HTTP handlers.
+//
+// Rate limits are applied to each message received on the stream, since the
+// request is not available before the handler is called.
+func StreamServerInterceptor(authMap AuthMap, rl *rate.Limiter, l zerolog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return observeGRPC(ss.Context(), info.FullMethod, authMap, l, func(ctx context.Context) error {
+			return handler(srv, &rateLimitedStream{ServerStream: ss, ctx: ctx, rl: rl})
+		})
+	}
+}
+
+// observeGRPC is the gRPC equivalent of the observeHandler, panicHandler, and
+// authHandler middleware, calling the given func with an authenticated
+// context.
+func observeGRPC(ctx context.Context, method string, authMap AuthMap, l zerolog.Logger, call func(context.Context) error) (err error) {
+	start := time.Now()
+
+	ctx, span := beeline.StartSpan(ctx, method)
+	defer span.Send()
+	span.AddField("grpc.method", method)
+
+	ctx, d := ctxdata.New(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	id := requestid.FromOrNew(firstMetadataValue(md, strings.ToLower(requestid.Header)))
+	span.AddField("request_id", id)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, id))
+	ctx = requestid.NewContext(ctx, id)
+
+	defer func() {
+		if p := recover(); p != nil {
+			buf := make([]byte, 2048)
+			n := runtime.Stack(buf, false)
+			_ = d.Set("error", fmt.Errorf("panic: %s", p))
+			_ = d.Set("stack", string(buf[:n]))
+			err = status.Error(codes.Internal, "internal error")
+		}
+
+		code := status.Code(err)
+		span.AddField("grpc.code", code.String())
+
+		rec := logRecord{
+			ClientID:   d.GetString("client_id"),
+			DurationMS: time.Since(start).Milliseconds(),
+			Method:     "GRPC",
+			RemoteAddr: getGRPCRemoteAddr(ctx, md),
+			RequestID:  id,
+			Status:     httpStatusFromCode(code),
+			URL:        method,
+			UserAgent:  firstMetadataValue(md, "user-agent"),
+		}
+		if recErr := d.GetError("error"); recErr != nil {
+			rec.Error = recErr.Error()
+			rec.Stack = d.GetString("stack")
+		} else if err != nil {
+			rec.Error = err.Error()
+		}
+		if rec.Error != "" {
+			span.AddField("error", rec.Error)
+			if rec.Stack != "" {
+				span.AddField("stack", rec.Stack)
+			}
+		}
+
+		evt := l.Info()
+		if code != codes.OK || rec.Error != "" {
+			evt = l.Error()
+		}
+		evt.EmbedObject(rec).Str("grpc_code", code.String()).Send()
+	}()
+
+	ctx, err = authenticate(ctx, firstMetadataValue(md, "authorization"), authMap)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return call(ctx)
+}
+
+// rateLimitedStream applies rate limits to each message received on a
+// stream, and carries the authenticated context to the stream handler.
+type rateLimitedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	rl  *rate.Limiter
+}
+
+func (s *rateLimitedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *rateLimitedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if n := requestCost(m); !allowRequest(s.ctx, s.rl, n) {
+		return rateLimitStatus(s.rl, n)
+	}
+	return nil
+}
+
+func requestCost(req any) int {
+	if batch, ok := req.(batchRequest); ok && len(batch.GetUrls()) > 1 {
+		return len(batch.GetUrls())
+	}
+	return 1
+}
+
+func rateLimitStatus(rl *rate.Limiter, n int) error {
+	return status.Error(codes.ResourceExhausted, rateLimitMessage(rl, n))
+}
+
+func getGRPCRemoteAddr(ctx context.Context, md metadata.MD) string {
+	if remoteAddr := firstMetadataValue(md, "fly-client-ip"); remoteAddr != "" {
+		return remoteAddr
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// httpStatusFromCode maps gRPC status codes onto the equivalent HTTP status
+// codes, so that gRPC and HTTP requests may be analyzed together.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		// non-standard 499 Client Closed Request, as in the HTTP handler
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeBatchRequest struct {
+	urls []string
+}
+
+func (r *fakeBatchRequest) GetUrls() []string { return r.urls }
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	authMap := map[string]string{
+		"valid-token": "client-1",
+	}
+
+	testCases := map[string]struct {
+		rl           *rate.Limiter
+		md           metadata.MD
+		req          any
+		handler      grpc.UnaryHandler
+		wantCode     codes.Code
+		wantClientID string
+		wantStatus   int
+	}{
+		"valid auth token accepted and not rate limited": {
+			rl:           newLimiter(0, 0),
+			md:           metadata.Pairs("authorization", "Token valid-token"),
+			wantCode:     codes.OK,
+			wantClientID: "client-1",
+			wantStatus:   http.StatusOK,
+		},
+		"invalid auth token rejected": {
+			md:         metadata.Pairs("authorization", "Token zzz-invalid-token"),
+			wantCode:   codes.Unauthenticated,
+			wantStatus: http.StatusForbidden,
+		},
+		"anonymous request rate limit ok": {
+			rl:         newLimiter(1, 1),
+			wantCode:   codes.OK,
+			wantStatus: http.StatusOK,
+		},
+		"anonymous request rate limit exceeded": {
+			rl:         newLimiter(0, 0),
+			wantCode:   codes.ResourceExhausted,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		"each url in anonymous batch counts against rate limit": {
+			rl:         newLimiter(1, 2),
+			req:        &fakeBatchRequest{urls: []string{"a", "b", "c"}},
+			wantCode:   codes.ResourceExhausted,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		"handler errors are returned": {
+			handler: func(ctx context.Context, req any) (any, error) {
+				return nil, status.Error(codes.InvalidArgument, "bad")
+			},
+			wantCode:   codes.InvalidArgument,
+			wantStatus: http.StatusBadRequest,
+		},
+		"panics are caught": {
+			handler: func(ctx context.Context, req any) (any, error) {
+				panic("oops")
+			},
+			wantCode:   codes.Internal,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotClientID string
+			handler := tc.handler
+			if handler == nil {
+				handler = func(ctx context.Context, req any) (any, error) {
+					gotClientID = clientIDFromContext(ctx)
+					return "ok", nil
+				}
+			}
+
+			captured := &capturingWriter{}
+			interceptor := UnaryServerInterceptor(authMap, tc.rl, zerolog.New(captured))
+
+			ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+			_, err := interceptor(ctx, tc.req, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, handler)
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantClientID, gotClientID)
+
+			if !assert.Len(t, captured.events, 1) {
+				return
+			}
+			assert.Equal(t, tc.wantStatus, captured.events[0].Status)
+			assert.Equal(t, "/test.Service/Method", captured.events[0].URL)
+			if tc.wantCode != codes.OK {
+				assert.NotEmpty(t, captured.events[0].Error)
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptorBatchTooLarge(t *testing.T) {
+	t.Parallel()
+
+	// a batch larger than the burst limit could never be allowed, so the
+	// client is told to authenticate rather than to retry
+	interceptor := UnaryServerInterceptor(nil, newLimiter(100, 2), zerolog.Nop())
+	_, err := interceptor(context.Background(), &fakeBatchRequest{urls: []string{"a", "b", "c"}}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "at most 2 URLs")
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	authMap := map[string]string{
+		"valid-token": "client-1",
+	}
+
+	testCases := map[string]struct {
+		rl       *rate.Limiter
+		md       metadata.MD
+		wantCode codes.Code
+	}{
+		"valid auth token accepted and not rate limited": {
+			rl:       newLimiter(0, 0),
+			md:       metadata.Pairs("authorization", "Token valid-token"),
+			wantCode: codes.OK,
+		},
+		"invalid auth token rejected": {
+			md:       metadata.Pairs("authorization", "Token zzz-invalid-token"),
+			wantCode: codes.Unauthenticated,
+		},
+		"anonymous batch within rate limit": {
+			rl:       newLimiter(1, 3),
+			wantCode: codes.OK,
+		},
+		"anonymous batch exceeds rate limit": {
+			rl:       newLimiter(1, 2),
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			interceptor := StreamServerInterceptor(authMap, tc.rl, zerolog.New(&capturingWriter{}))
+			ss := &fakeServerStream{
+				ctx: metadata.NewIncomingContext(context.Background(), tc.md),
+				msg: &fakeBatchRequest{urls: []string{"a", "b", "c"}},
+			}
+			err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv any, stream grpc.ServerStream) error {
+				return stream.RecvMsg(&fakeBatchRequest{})
+			})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+		})
+	}
+}
+
+// fakeServerStream is a grpc.ServerStream that receives a single message.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	msg *fakeBatchRequest
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) RecvMsg(m any) error {
+	*m.(*fakeBatchRequest) = *s.msg
+	return nil
+}
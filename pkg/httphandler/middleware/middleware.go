@@ -11,6 +11,9 @@ import (
 	ctxdata "github.com/peterbourgon/ctxdata/v4"
 	"github.com/rs/zerolog"
 	"golang.org/x/time/rate"
+
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
 )
 
 // Wrap wraps an http handler with middleware to add instrumentation, error
@@ -21,6 +24,7 @@ func Wrap(h http.Handler, authMap AuthMap, rl *rate.Limiter, l zerolog.Logger) h
 	h = authHandler(h, authMap)
 	h = panicHandler(h)
 	h = observeHandler(h, l)
+	h = requestIDHandler(h)
 	h = hnynethttp.WrapHandler(h)
 	return h
 }
@@ -35,6 +39,7 @@ func observeHandler(next http.Handler, l zerolog.Logger) http.Handler {
 			DurationMS: m.Duration.Milliseconds(),
 			Method:     r.Method,
 			RemoteAddr: getRemoteAddr(r),
+			RequestID:  requestid.FromContext(ctx),
 			Size:       m.Written,
 			Status:     m.Code,
 			URL:        r.URL.String(),
@@ -70,7 +75,7 @@ func panicHandler(next http.Handler) http.Handler {
 				_ = d.Set("error", fmt.Errorf("panic: %s", p))
 				_ = d.Set("stack", stack)
 
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.Send(w, r, http.StatusInternalServerError, problem.CodeInternalError, "Internal server error")
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -90,6 +95,7 @@ type logRecord struct {
 	DurationMS int64  `json:"duration_ms"`
 	Method     string `json:"method"`
 	RemoteAddr string `json:"remote_addr"`
+	RequestID  string `json:"request_id"`
 	Size       int64  `json:"size"`
 	Status     int    `json:"status"`
 	URL        string `json:"url"`
@@ -106,6 +112,7 @@ func (rec logRecord) MarshalZerologObject(e *zerolog.Event) {
 	e.Int64("size", rec.Size)
 	e.Str("method", rec.Method)
 	e.Str("remote_addr", rec.RemoteAddr)
+	e.Str("request_id", rec.RequestID)
 	e.Str("url", rec.URL)
 	e.Str("user_agent", rec.UserAgent)
 	e.Str("client_id", rec.ClientID)
@@ -11,6 +11,9 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
 )
 
 func TestMiddleware(t *testing.T) {
@@ -38,6 +41,7 @@ func TestMiddleware(t *testing.T) {
 			},
 			validator: func(t *testing.T, rec logRecord) {
 				assert.Equal(t, http.StatusInternalServerError, rec.Status)
+				assert.NotEmpty(t, rec.RequestID)
 				assert.Equal(t, rec.Error, "panic: oops")
 				assert.Contains(t, rec.Stack, "goroutine")
 			},
@@ -68,6 +72,82 @@ func TestMiddleware(t *testing.T) {
 	}
 }
 
+func TestPanicProblem(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+	srv := httptest.NewServer(Wrap(handler, nil, nil, zerolog.Nop()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	var p problem.Problem
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, problem.CodeInternalError, p.Code)
+	assert.Equal(t, resp.Header.Get(requestid.Header), p.RequestID)
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		givenID  string
+		wantKept bool
+	}{
+		"valid request id is kept": {
+			givenID:  "client-request-id",
+			wantKept: true,
+		},
+		"missing request id is generated": {
+			givenID: "",
+		},
+		"invalid request id is replaced": {
+			givenID: "not valid",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotCtxID string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotCtxID = requestid.FromContext(r.Context())
+			})
+			captured := &capturingWriter{}
+			srv := httptest.NewServer(Wrap(handler, nil, nil, zerolog.New(captured)))
+			defer srv.Close()
+
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			if tc.givenID != "" {
+				req.Header.Set(requestid.Header, tc.givenID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			gotID := resp.Header.Get(requestid.Header)
+			if tc.wantKept {
+				assert.Equal(t, tc.givenID, gotID)
+			} else {
+				assert.Regexp(t, `^[0-9a-f]{32}$`, gotID)
+			}
+			assert.Equal(t, gotID, gotCtxID)
+			if assert.Len(t, captured.events, 1) {
+				assert.Equal(t, gotID, captured.events[0].RequestID)
+			}
+		})
+	}
+}
+
 type capturingWriter struct {
 	mu     sync.Mutex
 	events []logRecord
@@ -1,12 +1,16 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/honeycombio/beeline-go"
 	"golang.org/x/time/rate"
+
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
 )
 
 var (
@@ -17,35 +21,71 @@ var (
 
 func rateLimitHandler(next http.Handler, rateLimiter *rate.Limiter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		clientID := clientIDFromContext(ctx)
-
-		if rateLimiter == nil {
-			beeline.AddField(r.Context(), "rate_limit_result", "skipped_disabled")
-			next.ServeHTTP(w, r)
+		if !allowRequest(r.Context(), rateLimiter, 1) {
+			w.Header().Set("Retry-After", "1")
+			problem.Send(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, rateLimitMessage(rateLimiter, 1))
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimiterKey, rateLimiter)))
+	})
+}
 
-		// If a known API key is provided, no rate limiting is necessary
-		if clientID != "" {
-			beeline.AddField(r.Context(), "rate_limit_result", "skipped_authenticated")
-			next.ServeHTTP(w, r)
-			return
-		}
+// AllowURLs reports whether a request admitted by Wrap may resolve n URLs,
+// for handlers whose requests may contain more than one. The request was
+// charged for a single URL when it was admitted, so anonymous requests are
+// charged for the rest here. If the request is not allowed, a message
+// describing the limit is also returned.
+func AllowURLs(ctx context.Context, n int) (bool, string) {
+	rateLimiter, _ := ctx.Value(rateLimiterKey).(*rate.Limiter)
+	if rateLimiter == nil || n <= 1 {
+		return true, ""
+	}
+	// the whole request must fit within the burst limit, not just the rest
+	// of its URLs
+	if clientIDFromContext(ctx) == "" && n > rateLimiter.Burst() {
+		beeline.AddField(ctx, "rate_limit_result", "denied_anonymous")
+		return false, rateLimitMessage(rateLimiter, n)
+	}
+	if !allowRequest(ctx, rateLimiter, n-1) {
+		return false, rateLimitMessage(rateLimiter, n)
+	}
+	return true, ""
+}
 
-		if !rateLimiter.Allow() {
-			beeline.AddField(r.Context(), "rate_limit_result", "denied_anonymous")
-			sendRateLimitError(w, rateLimiter)
-			return
-		}
+// allowRequest reports whether a request for n URLs should be allowed by the
+// rate limiter. Authenticated clients are never rate limited, while anonymous
+// requests are charged for every URL they contain, so requests for more URLs
+// than the burst limit are never allowed.
+func allowRequest(ctx context.Context, rateLimiter *rate.Limiter, n int) bool {
+	if rateLimiter == nil {
+		beeline.AddField(ctx, "rate_limit_result", "skipped_disabled")
+		return true
+	}
 
-		beeline.AddField(r.Context(), "rate_limit_result", "allowed_anonymous")
-		next.ServeHTTP(w, r)
-	})
+	// If a known API key is provided, no rate limiting is necessary
+	if clientIDFromContext(ctx) != "" {
+		beeline.AddField(ctx, "rate_limit_result", "skipped_authenticated")
+		return true
+	}
+
+	if !rateLimiter.AllowN(time.Now(), n) {
+		beeline.AddField(ctx, "rate_limit_result", "denied_anonymous")
+		return false
+	}
+
+	beeline.AddField(ctx, "rate_limit_result", "allowed_anonymous")
+	return true
 }
 
-func sendRateLimitError(w http.ResponseWriter, rl *rate.Limiter) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusTooManyRequests)
-	_, _ = fmt.Fprintf(w, `{"error": "Anonymous request rate limit of %0f req/sec exceeded. Try again later."}`, rl.Limit())
+type rateLimiterKeyType int
+
+const rateLimiterKey = rateLimiterKeyType(1)
+
+// rateLimitMessage describes why an anonymous request for n URLs was not
+// allowed.
+func rateLimitMessage(rl *rate.Limiter, n int) string {
+	if n > rl.Burst() {
+		return fmt.Sprintf("Anonymous requests may contain at most %d URLs. Authenticate to resolve larger batches.", rl.Burst())
+	}
+	return fmt.Sprintf("Anonymous request rate limit of %0f req/sec exceeded. Try again later.", rl.Limit())
 }
@@ -7,6 +7,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/time/rate"
+
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
 )
 
 func TestRateLimiter(t *testing.T) {
@@ -65,6 +67,10 @@ func TestRateLimiter(t *testing.T) {
 			resp, err := http.DefaultClient.Do(req)
 			assert.Nil(t, err)
 			assert.Equal(t, tc.wantStatus, resp.StatusCode)
+			if tc.wantStatus == http.StatusTooManyRequests {
+				assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+				assertProblemCode(t, resp, problem.CodeRateLimited)
+			}
 		})
 	}
 }
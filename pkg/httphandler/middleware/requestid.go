@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
+)
+
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestid.FromOrNew(r.Header.Get(requestid.Header))
+		beeline.AddField(r.Context(), "request_id", id)
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
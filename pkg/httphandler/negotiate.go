@@ -0,0 +1,214 @@
+package httphandler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+)
+
+// responseFormat is a response media type that may be negotiated via the
+// Accept header.
+type responseFormat struct {
+	// mediaTypes are the media types that select this format, the first of
+	// which is used as the response Content-Type
+	mediaTypes []string
+	encode     func(w io.Writer, data interface{}, pretty bool) error
+}
+
+// Supported response formats.
+var (
+	formatJSON = responseFormat{
+		mediaTypes: []string{"application/json"},
+		encode:     encodeJSON,
+	}
+	formatMsgpack = responseFormat{
+		mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode:     encodeMsgpack,
+	}
+	formatCSV = responseFormat{
+		mediaTypes: []string{"text/csv"},
+		encode:     encodeCSV,
+	}
+)
+
+func (f responseFormat) contentType() string {
+	return f.mediaTypes[0]
+}
+
+// negotiate selects the response format for a request from the given
+// formats, in order of preference, based on its Accept header. It returns
+// false if none of the formats are acceptable.
+//
+// Per RFC 9110 §12.5.1, each format is given the quality of the most
+// specific media range matching it, so that e.g. "application/json;q=0, */*"
+// excludes JSON rather than accepting it via the wildcard. Formats of equal
+// quality are chosen in the order their media ranges appear in the header,
+// and then in the given order of preference.
+func negotiate(w http.ResponseWriter, r *http.Request, offers ...responseFormat) (responseFormat, bool) {
+	w.Header().Add("Vary", "Accept")
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	ranges := parseAccept(accept)
+
+	var (
+		best      responseFormat
+		bestQ     float64
+		bestIndex int
+	)
+	for _, offer := range offers {
+		q, index, ok := matchRange(offer, ranges)
+		if !ok || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && index < bestIndex) {
+			best, bestQ, bestIndex = offer, q, index
+		}
+	}
+	return best, bestQ > 0
+}
+
+// mediaRange is a single media range from an Accept header.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// specificity ranks how specifically the range matches the given media
+// type, from 3 for an exact match down to 1 for "*/*", or 0 if it does not
+// match at all.
+func (mr mediaRange) specificity(mediaType string) int {
+	switch {
+	case mr.mediaType == mediaType:
+		return 3
+	case mr.mediaType == strings.Split(mediaType, "/")[0]+"/*":
+		return 2
+	case mr.mediaType == "*/*":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseAccept parses the media ranges in an Accept header, skipping any
+// that are malformed.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qv, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qv, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// matchRange returns the quality of the most specific media range matching
+// any of the format's media types, along with the index of that range. It
+// returns false if no range matches.
+func matchRange(offer responseFormat, ranges []mediaRange) (float64, int, bool) {
+	var (
+		q        float64
+		index    int
+		bestSpec int
+	)
+	for i, mr := range ranges {
+		for _, offered := range offer.mediaTypes {
+			spec := mr.specificity(offered)
+			if spec == 0 || spec < bestSpec || (spec == bestSpec && mr.q <= q) {
+				continue
+			}
+			q, index, bestSpec = mr.q, i, spec
+		}
+	}
+	return q, index, bestSpec > 0
+}
+
+// notAcceptableDetail describes the formats supported by an endpoint, for
+// use in 406 Not Acceptable responses.
+func notAcceptableDetail(offers ...responseFormat) string {
+	supported := make([]string, 0, len(offers))
+	for _, offer := range offers {
+		supported = append(supported, offer.contentType())
+	}
+	return "Supported media types: " + strings.Join(supported, ", ")
+}
+
+// writeResponse encodes the given data in the negotiated format. JSON
+// responses are compact unless the request includes a ?pretty param.
+func writeResponse(w http.ResponseWriter, r *http.Request, format responseFormat, code int, data interface{}) {
+	w.Header().Set("Content-Type", format.contentType())
+	w.WriteHeader(code)
+	_ = format.encode(w, data, isPretty(r))
+}
+
+func isPretty(r *http.Request) bool {
+	vals, ok := r.URL.Query()["pretty"]
+	if !ok {
+		return false
+	}
+	if vals[0] == "" {
+		return true
+	}
+	pretty, _ := strconv.ParseBool(vals[0])
+	return pretty
+}
+
+func encodeJSON(w io.Writer, data interface{}, pretty bool) error {
+	enc := json.NewEncoder(w)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(data)
+}
+
+func encodeMsgpack(w io.Writer, data interface{}, _ bool) error {
+	enc := msgpack.NewEncoder(w)
+	// use the same field names as the JSON encoding
+	enc.SetCustomStructTag("json")
+	return enc.Encode(data)
+}
+
+// csvHeader is the header row of CSV job results.
+var csvHeader = []string{"given_url", "resolved_url", "title", "intermediate_urls", "error", "error_code"}
+
+// encodeCSV encodes a job's results as CSV, one row per URL. Intermediate
+// URLs are separated by spaces.
+func encodeCSV(w io.Writer, data interface{}, _ bool) error {
+	job, ok := data.(*jobs.Job)
+	if !ok {
+		return fmt.Errorf("cannot encode %T as CSV", data)
+	}
+	cw := csv.NewWriter(w)
+	_ = cw.Write(csvHeader)
+	for _, result := range job.Results {
+		_ = cw.Write([]string{
+			result.GivenURL,
+			result.ResolvedURL,
+			result.Title,
+			strings.Join(result.IntermediateURLs, " "),
+			result.Error,
+			result.ErrorCode,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,235 @@
+package httphandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+)
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		accept string
+		offers []responseFormat
+		want   string
+		wantOK bool
+	}{
+		"no accept header": {
+			offers: resolveFormats,
+			want:   "application/json",
+			wantOK: true,
+		},
+		"wildcard": {
+			accept: "*/*",
+			offers: resolveFormats,
+			want:   "application/json",
+			wantOK: true,
+		},
+		"type wildcard": {
+			accept: "application/*",
+			offers: resolveFormats,
+			want:   "application/json",
+			wantOK: true,
+		},
+		"msgpack": {
+			accept: "application/msgpack",
+			offers: resolveFormats,
+			want:   "application/msgpack",
+			wantOK: true,
+		},
+		"msgpack alias": {
+			accept: "application/x-msgpack",
+			offers: resolveFormats,
+			want:   "application/msgpack",
+			wantOK: true,
+		},
+		"highest quality wins": {
+			accept: "application/json;q=0.5, text/csv;q=0.9",
+			offers: jobStatusFormats,
+			want:   "text/csv",
+			wantOK: true,
+		},
+		"unsupported type is skipped": {
+			accept: "text/html, application/json;q=0.1",
+			offers: resolveFormats,
+			want:   "application/json",
+			wantOK: true,
+		},
+		"zero quality is refused": {
+			accept: "application/json;q=0",
+			offers: resolveFormats,
+			wantOK: false,
+		},
+		"zero quality exclusion beats wildcard": {
+			accept: "application/json;q=0, */*",
+			offers: resolveFormats,
+			want:   "application/msgpack",
+			wantOK: true,
+		},
+		"zero quality exclusion beats type wildcard": {
+			accept: "application/*, application/json;q=0",
+			offers: resolveFormats,
+			want:   "application/msgpack",
+			wantOK: true,
+		},
+		"zero quality alias exclusion beats wildcard": {
+			accept: "application/x-msgpack;q=0, application/json;q=0, */*",
+			offers: resolveFormats,
+			wantOK: false,
+		},
+		"most specific range sets quality": {
+			accept: "*/*, text/csv;q=0.2, application/*;q=0.1",
+			offers: jobStatusFormats,
+			want:   "text/csv",
+			wantOK: true,
+		},
+		"header order breaks ties": {
+			accept: "application/msgpack, application/json",
+			offers: resolveFormats,
+			want:   "application/msgpack",
+			wantOK: true,
+		},
+		"unsupported type": {
+			accept: "text/csv",
+			offers: resolveFormats,
+			wantOK: false,
+		},
+		"malformed": {
+			accept: ";;;",
+			offers: resolveFormats,
+			wantOK: false,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			got, ok := negotiate(w, r, tc.offers...)
+			assert.Equal(t, tc.wantOK, ok)
+			if tc.wantOK {
+				assert.Equal(t, tc.want, got.contentType())
+			}
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestResolveFormats(t *testing.T) {
+	t.Parallel()
+
+	handler := New(stubResolver{result: urlresolver.Result{ResolvedURL: "https://example.com/resolved", Title: "title"}})
+
+	t.Run("compact json by default", func(t *testing.T) {
+		t.Parallel()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		assert.Equal(t, 1, strings.Count(w.Body.String(), "\n"))
+	})
+
+	t.Run("pretty json", func(t *testing.T) {
+		t.Parallel()
+		for _, param := range []string{"pretty", "pretty=1", "pretty=true"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/resolve?url=https://t.co/foo&"+param, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), "\n  \"given_url\"", param)
+		}
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		t.Parallel()
+		r := httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil)
+		r.Header.Set("Accept", "application/msgpack")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+
+		var got map[string]interface{}
+		assert.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, "https://t.co/foo", got["given_url"])
+		assert.Equal(t, "https://example.com/resolved", got["resolved_url"])
+		assert.Equal(t, "title", got["title"])
+	})
+
+	t.Run("not acceptable", func(t *testing.T) {
+		t.Parallel()
+		r := httptest.NewRequest("GET", "/resolve?url=https://t.co/foo", nil)
+		r.Header.Set("Accept", "text/csv")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotAcceptable, w.Code)
+		assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), problem.CodeNotAcceptable)
+	})
+}
+
+func TestJobResultsCSV(t *testing.T) {
+	t.Parallel()
+
+	runner := jobs.NewRunner(
+		stubResolver{result: urlresolver.Result{
+			ResolvedURL:      "https://example.com/resolved",
+			Title:            "title, with comma",
+			IntermediateURLs: []string{"https://t.co/a", "https://example.com/a"},
+		}},
+		jobs.NewMemoryStore(time.Hour),
+		jobs.Options{QueueSize: 10},
+	)
+	t.Cleanup(func() { _ = runner.Shutdown(context.Background()) })
+
+	job, err := runner.Submit(context.Background(), []string{"https://t.co/a"}, "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		got, err := runner.Get(context.Background(), job.ID)
+		return err == nil && got.Status == jobs.StatusCompleted
+	}, time.Second, 5*time.Millisecond)
+
+	handler := NewJobsHandler(runner)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", handler.Create)
+	mux.HandleFunc("GET /jobs/{id}", handler.Status)
+
+	r := httptest.NewRequest("GET", "/jobs/"+job.ID, nil)
+	r.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+
+	records, err := csv.NewReader(bytes.NewReader(w.Body.Bytes())).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		csvHeader,
+		{"https://t.co/a", "https://example.com/resolved", "title, with comma", "https://t.co/a https://example.com/a", "", ""},
+	}, records)
+
+	// CSV is only offered for job results
+	r = httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"urls": ["https://t.co/a"]}`))
+	r.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
@@ -0,0 +1,28 @@
+package httphandler
+
+import (
+	_ "embed" // embed the OpenAPI spec
+	"fmt"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3 specification of the HTTP API, which is
+// checked against the handlers' request and response types by tests.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// APIVersionPrefix is the path prefix under which the current version of the
+// API is mounted.
+const APIVersionPrefix = "/v1"
+
+// OpenAPIHandler returns an HTTP handler that serves the OpenAPI 3
+// specification of the HTTP API.
+func OpenAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// the spec may change with any deploy, so it is only cached briefly
+		w.Header().Set("Cache-Control", fmt.Sprintf("public,max-age=%.0f", maxAgeErr.Seconds()))
+		_, _ = w.Write(openAPISpec)
+	})
+}
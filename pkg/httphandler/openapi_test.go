@@ -0,0 +1,181 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolverapi/pkg/jobs"
+	"github.com/mccutchen/urlresolverapi/pkg/problem"
+)
+
+// openAPISchema is the subset of an OpenAPI schema object checked against
+// Go types.
+type openAPISchema struct {
+	Type       string                   `json:"type"`
+	Format     string                   `json:"format"`
+	Ref        string                   `json:"$ref"`
+	Required   []string                 `json:"required"`
+	Properties map[string]openAPISchema `json:"properties"`
+	Items      *openAPISchema           `json:"items"`
+	Enum       []string                 `json:"enum"`
+}
+
+func loadOpenAPISchemas(t *testing.T) map[string]openAPISchema {
+	t.Helper()
+	var spec struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]openAPISchema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("invalid OpenAPI spec: %s", err)
+	}
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."), "unexpected OpenAPI version %q", spec.OpenAPI)
+	return spec.Components.Schemas
+}
+
+// TestOpenAPISchemas ensures that the OpenAPI spec matches the shape of the
+// request and response types actually used by the handlers. If this test
+// fails after changing one of those types, update openapi.json to match.
+func TestOpenAPISchemas(t *testing.T) {
+	t.Parallel()
+
+	schemas := loadOpenAPISchemas(t)
+	schemaTypes := map[string]reflect.Type{
+		"ResolveRequest":  reflect.TypeOf(ResolveRequest{}),
+		"ResolveResponse": reflect.TypeOf(ResolveResponse{}),
+		"Problem":         reflect.TypeOf(problem.Problem{}),
+		"JobRequest":      reflect.TypeOf(JobRequest{}),
+		"Job":             reflect.TypeOf(jobs.Job{}),
+		"JobResult":       reflect.TypeOf(jobs.Result{}),
+	}
+
+	for name, typ := range schemaTypes {
+		name, typ := name, typ
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			schema, ok := schemas[name]
+			if !assert.True(t, ok, "schema %q missing from spec", name) {
+				return
+			}
+			checkSchema(t, name, schema, typ, schemas, schemaTypes)
+		})
+	}
+}
+
+func TestOpenAPIEnums(t *testing.T) {
+	t.Parallel()
+
+	schemas := loadOpenAPISchemas(t)
+
+	errorCodes := []string{}
+	for _, err := range []error{ErrRequestTimeout, ErrUnsafeURL, ErrBlockedURL, ErrResolveError} {
+		errorCodes = append(errorCodes, ErrorCode(err))
+	}
+	assert.ElementsMatch(t, errorCodes, schemas["ResolveResponse"].Properties["error_code"].Enum)
+	assert.ElementsMatch(t, errorCodes, schemas["JobResult"].Properties["error_code"].Enum)
+	assert.ElementsMatch(t,
+		[]string{jobs.StatusPending, jobs.StatusRunning, jobs.StatusCompleted, jobs.StatusFailed},
+		schemas["Job"].Properties["status"].Enum)
+	assert.ElementsMatch(t,
+		[]string{jobs.CallbackPending, jobs.CallbackDelivered, jobs.CallbackFailed},
+		schemas["Job"].Properties["callback_status"].Enum)
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	OpenAPIHandler().ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.True(t, json.Valid(w.Body.Bytes()))
+}
+
+func checkSchema(t *testing.T, path string, schema openAPISchema, typ reflect.Type, schemas map[string]openAPISchema, schemaTypes map[string]reflect.Type) {
+	t.Helper()
+
+	if !assert.Equal(t, "object", schema.Type, "%s: wrong type", path) {
+		return
+	}
+
+	var (
+		wantProps    []string
+		wantRequired []string
+	)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		wantProps = append(wantProps, name)
+		if !strings.Contains(opts, "omitempty") {
+			wantRequired = append(wantRequired, name)
+		}
+
+		prop, ok := schema.Properties[name]
+		if !assert.True(t, ok, "%s: property %q missing from spec", path, name) {
+			continue
+		}
+		checkPropertyType(t, path+"."+name, prop, field.Type, schemas, schemaTypes)
+	}
+
+	gotProps := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		gotProps = append(gotProps, name)
+	}
+	sort.Strings(gotProps)
+	sort.Strings(wantProps)
+	assert.Equal(t, wantProps, gotProps, "%s: properties do not match", path)
+	assert.ElementsMatch(t, wantRequired, schema.Required, "%s: required properties do not match", path)
+}
+
+func checkPropertyType(t *testing.T, path string, prop openAPISchema, typ reflect.Type, schemas map[string]openAPISchema, schemaTypes map[string]reflect.Type) {
+	t.Helper()
+
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if prop.Ref != "" {
+		refName := strings.TrimPrefix(prop.Ref, "#/components/schemas/")
+		assert.Equal(t, schemaTypes[refName], typ, "%s: $ref %q does not match type %s", path, prop.Ref, typ)
+		return
+	}
+
+	switch {
+	case typ == reflect.TypeOf(time.Time{}):
+		assert.Equal(t, "string", prop.Type, "%s: wrong type", path)
+		assert.Equal(t, "date-time", prop.Format, "%s: wrong format", path)
+	case typ.Kind() == reflect.String:
+		assert.Equal(t, "string", prop.Type, "%s: wrong type", path)
+	case typ.Kind() == reflect.Bool:
+		assert.Equal(t, "boolean", prop.Type, "%s: wrong type", path)
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64:
+		assert.Equal(t, "integer", prop.Type, "%s: wrong type", path)
+	case typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64:
+		assert.Equal(t, "number", prop.Type, "%s: wrong type", path)
+	case typ.Kind() == reflect.Slice:
+		if assert.Equal(t, "array", prop.Type, "%s: wrong type", path) && assert.NotNil(t, prop.Items, "%s: missing items", path) {
+			checkPropertyType(t, path+"[]", *prop.Items, typ.Elem(), schemas, schemaTypes)
+		}
+	case typ.Kind() == reflect.Struct:
+		checkSchema(t, path, prop, typ, schemas, schemaTypes)
+	default:
+		t.Errorf("%s: unsupported type %s", path, typ)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Package interstitial detects cookie consent walls and other interstitial
+pages that stand between a resolved URL and its real destination.
+
+Rules are loaded from a file containing one directive per line, where each
+directive is a rule name followed by a directive name and value:
+
+	# Google redirects to a consent page, which a consent cookie avoids
+	google    url     consent.google.com
+	google    cookie  SOCS=CAESEwgDEgk0ODE3Nzk3MjQaAmVuIAEaBgiA_LyaBg
+
+	# some sites serve a consent wall in place of the requested page
+	generic   title   (?i)^(before you continue|we value your privacy)\b
+	generic   header  Accept-Language: en-US,en;q=0.9
+
+	# nothing gets past this one
+	gateway   url     interstitial.example/warning
+
+The supported directives are:
+
+	url <host>[/<path>]    match resolved URLs on hosts matching the
+	                       hostmatch pattern, optionally under a path prefix
+	title <regexp>         match resolved titles against a regular expression
+	cookie <name>=<value>  send a cookie when retrying (may be given more than once)
+	header <name>: <value> send a header when retrying (may be given more than once)
+
+Directives with the same name are combined into a single Rule, and a result
+is governed by the first rule with any url or title pattern that matches it.
+
+When a rule with cookies or headers detects an interstitial, Resolver
+resolves the URL again within the time left from the first attempt, sending
+them with every request to the interstitial's site (i.e. its registrable
+domain, so that a cookie for consent.google.com is also sent to
+www.google.com, but not to the shortener that led there). If the rule has
+neither, or if the retry still ends up at an interstitial, the result is
+rewound to the last URL before the interstitial and flagged in the context's
+resultmeta.Recorder.
+*/
+package interstitial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/rulefile"
+)
+
+// ErrInvalidDirective is returned when a rules file contains an invalid
+// directive.
+var ErrInvalidDirective = errors.New("invalid directive")
+
+// URLPattern matches URLs on matching hosts, optionally under a path prefix.
+type URLPattern struct {
+	Host       hostmatch.Pattern
+	PathPrefix string
+}
+
+// ParseURLPattern parses a URL pattern in "host[/path]" format.
+func ParseURLPattern(raw string) (URLPattern, error) {
+	host, path, hasPath := strings.Cut(raw, "/")
+	pattern, err := hostmatch.Parse(host)
+	if err != nil {
+		return URLPattern{}, err
+	}
+	p := URLPattern{Host: pattern}
+	if hasPath {
+		p.PathPrefix = "/" + path
+	}
+	return p, nil
+}
+
+// Match reports whether the pattern matches the given URL.
+func (p URLPattern) Match(u *url.URL) bool {
+	return p.Host.Match(u.Hostname()) && strings.HasPrefix(u.Path, p.PathPrefix)
+}
+
+// String returns the pattern in its original format.
+func (p URLPattern) String() string {
+	return p.Host.String() + p.PathPrefix
+}
+
+// Rule describes an interstitial and, optionally, how to get past it.
+type Rule struct {
+	// Name identifies the rule.
+	Name string
+
+	// URLs match the URLs of interstitial pages.
+	URLs []URLPattern
+
+	// Titles match the titles of interstitial pages.
+	Titles []*regexp.Regexp
+
+	// Cookies are sent with every request to the interstitial's site when
+	// retrying.
+	Cookies []*http.Cookie
+
+	// Header holds extra headers sent with every request to the
+	// interstitial's site when retrying.
+	Header http.Header
+}
+
+// Bypassable reports whether the rule describes how to get past the
+// interstitial.
+func (r Rule) Bypassable() bool {
+	return len(r.Cookies) > 0 || len(r.Header) > 0
+}
+
+// Match reports whether the rule matches the given result.
+func (r Rule) Match(result urlresolver.Result) bool {
+	return r.MatchURL(result.ResolvedURL) || r.matchTitle(result.Title)
+}
+
+// MatchURL reports whether any of the rule's URL patterns match the given
+// URL.
+func (r Rule) MatchURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, p := range r.URLs {
+		if p.Match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchTitle(title string) bool {
+	if title == "" {
+		return false
+	}
+	for _, re := range r.Titles {
+		if re.MatchString(title) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules is a set of Rules that may be reloaded at runtime.
+type Rules struct {
+	file *rulefile.File[[]Rule]
+}
+
+// New creates a new Rules from the given rules.
+func New(rules ...Rule) *Rules {
+	return &Rules{
+		file: rulefile.Static(rules),
+	}
+}
+
+// Load creates a new Rules from the directives in the file at path. The
+// rules may be reloaded later via Reload.
+func Load(path string) (*Rules, error) {
+	file, err := rulefile.Load(path, parseRules)
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{file: file}, nil
+}
+
+// Reload re-reads the rules from the file they were loaded from. If the
+// file cannot be read or parsed, the existing rules are kept.
+func (r *Rules) Reload() error {
+	return r.file.Reload()
+}
+
+// Detect returns the first Rule matching the given result.
+func (r *Rules) Detect(result urlresolver.Result) (Rule, bool) {
+	for _, rule := range r.file.Rules() {
+		if rule.Match(result) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func parseRules(r io.Reader) ([]Rule, error) {
+	var (
+		rules   []Rule
+		indexes = make(map[string]int)
+	)
+	err := rulefile.ScanDirectives(r, func(d rulefile.Directive) error {
+		idx, found := indexes[d.Key]
+		if !found {
+			idx = len(rules)
+			indexes[d.Key] = idx
+			rules = append(rules, Rule{Name: d.Key})
+		}
+		return applyDirective(&rules[idx], d.Name, d.Value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		if len(rule.URLs) == 0 && len(rule.Titles) == 0 {
+			return nil, fmt.Errorf("%w: rule %q has no url or title patterns", ErrInvalidDirective, rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+func applyDirective(rule *Rule, name string, value string) error {
+	switch name {
+	case "url":
+		p, err := ParseURLPattern(value)
+		if err != nil {
+			return fmt.Errorf("%w: invalid url pattern: %w", ErrInvalidDirective, err)
+		}
+		rule.URLs = append(rule.URLs, p)
+	case "title":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("%w: invalid title pattern: %w", ErrInvalidDirective, err)
+		}
+		rule.Titles = append(rule.Titles, re)
+	case "cookie":
+		cookie, err := http.ParseSetCookie(value)
+		if err != nil || strings.Contains(value, ";") {
+			return fmt.Errorf("%w: cookie must be in \"name=value\" format: %q", ErrInvalidDirective, value)
+		}
+		rule.Cookies = append(rule.Cookies, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	case "header":
+		key, val, err := rulefile.ParseHeader(value)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidDirective, err)
+		}
+		if rule.Header == nil {
+			rule.Header = make(http.Header)
+		}
+		rule.Header.Add(key, val)
+	default:
+		return fmt.Errorf("%w: unknown directive %q", ErrInvalidDirective, name)
+	}
+	return nil
+}
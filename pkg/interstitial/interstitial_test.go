@@ -0,0 +1,393 @@
+//nolint:errcheck
+package interstitial
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+func writeRules(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "interstitials.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	rules, err := Load(writeRules(t, `
+# comments and blank lines are ignored
+
+google   url     consent.google.com
+google   url     consent.youtube.com
+google   cookie  SOCS=abc123
+generic  title   (?i)^before you continue
+generic  header  accept-language: en-US,en;q=0.9
+generic  cookie  consent=yes
+gateway  url     *.example.com/interstitial/
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := map[string]struct {
+		result   urlresolver.Result
+		wantRule string
+	}{
+		"url match": {
+			result:   urlresolver.Result{ResolvedURL: "https://consent.youtube.com/m?continue=foo"},
+			wantRule: "google",
+		},
+		"title match": {
+			result:   urlresolver.Result{ResolvedURL: "https://news.example/article", Title: "Before You Continue to News"},
+			wantRule: "generic",
+		},
+		"url match with path prefix": {
+			result:   urlresolver.Result{ResolvedURL: "https://www.example.com/interstitial/warning"},
+			wantRule: "gateway",
+		},
+		"first matching rule wins": {
+			result:   urlresolver.Result{ResolvedURL: "https://consent.google.com/", Title: "Before you continue"},
+			wantRule: "google",
+		},
+		"path prefix mismatch": {
+			result: urlresolver.Result{ResolvedURL: "https://www.example.com/article"},
+		},
+		"no match": {
+			result: urlresolver.Result{ResolvedURL: "https://google.com/", Title: "Google"},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			rule, found := rules.Detect(tc.result)
+			assert.Equal(t, tc.wantRule != "", found)
+			assert.Equal(t, tc.wantRule, rule.Name)
+		})
+	}
+
+	rule, _ := rules.Detect(urlresolver.Result{ResolvedURL: "https://consent.google.com/"})
+	assert.True(t, rule.Bypassable())
+	assert.Equal(t, []*http.Cookie{{Name: "SOCS", Value: "abc123"}}, rule.Cookies)
+	assert.Equal(t, []string{"consent.google.com", "consent.youtube.com"}, []string{rule.URLs[0].String(), rule.URLs[1].String()})
+
+	rule, _ = rules.Detect(urlresolver.Result{Title: "before you continue"})
+	assert.Equal(t, http.Header{"Accept-Language": {"en-US,en;q=0.9"}}, rule.Header)
+
+	rule, _ = rules.Detect(urlresolver.Result{ResolvedURL: "https://example.com/interstitial/"})
+	assert.False(t, rule.Bypassable())
+
+	_, found := New().Detect(urlresolver.Result{ResolvedURL: "https://consent.google.com/"})
+	assert.False(t, found)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"missing value":     "google url",
+		"unknown directive": "google retries 3",
+		"invalid url":       "google url [consent.google.com",
+		"invalid title":     "google title (unclosed",
+		"invalid cookie":    "google cookie SOCS",
+		"cookie attributes": "google cookie SOCS=abc; Path=/",
+		"invalid header":    "google header X-Foo",
+		"no patterns":       "google cookie SOCS=abc",
+	}
+	for name, rules := range testCases {
+		rules := rules
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Load(writeRules(t, rules))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	path := writeRules(t, "google url consent.google.com\n")
+	rules, err := Load(path)
+	assert.NoError(t, err)
+
+	result := urlresolver.Result{ResolvedURL: "https://consent.yahoo.com/"}
+	_, found := rules.Detect(result)
+	assert.False(t, found)
+
+	os.WriteFile(path, []byte("yahoo url consent.yahoo.com\n"), 0o600)
+	assert.NoError(t, rules.Reload())
+	_, found = rules.Detect(result)
+	assert.True(t, found)
+
+	// invalid rules are not applied
+	os.WriteFile(path, []byte("yahoo url\n"), 0o600)
+	assert.Error(t, rules.Reload())
+	_, found = rules.Detect(result)
+	assert.True(t, found)
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	var requestsWithCookie []string
+	mux := http.NewServeMux()
+	consented := func(r *http.Request) bool {
+		cookie, err := r.Cookie("consent")
+		return err == nil && cookie.Value == "yes"
+	}
+	// a site that redirects to a consent page unless a cookie is sent
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article?utm_source=x", http.StatusFound)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		if consented(r) {
+			requestsWithCookie = append(requestsWithCookie, r.URL.Path)
+			w.Write([]byte("<title>Article</title>"))
+			return
+		}
+		http.Redirect(w, r, "/consent/page?continue=/article", http.StatusFound)
+	})
+	mux.HandleFunc("/consent/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>Cookie consent</title>"))
+	})
+	// a site that serves a consent wall in place of the page unless a
+	// header is sent
+	mux.HandleFunc("/wall", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consent") == "yes" {
+			w.Write([]byte("<title>Behind the wall</title>"))
+			return
+		}
+		w.Write([]byte("<title>Before you continue</title>"))
+	})
+	// a site whose consent wall can't be bypassed
+	mux.HandleFunc("/stubborn", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>Before you continue</title>"))
+	})
+	mux.HandleFunc("/gate", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/warning/1", http.StatusFound)
+	})
+	mux.HandleFunc("/warning/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>Leaving this site</title>"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	host := mustParseURL(t, srv.URL).Hostname()
+	rules, err := Load(writeRules(t, `
+consent  url     `+host+`/consent/
+consent  cookie  consent=yes
+wall     title   ^Before you continue$
+wall     header  X-Consent: yes
+gate     url     `+host+`/warning/
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resolver := NewResolver(rules, urlresolver.New(NewTransport(http.DefaultTransport), 0), 0)
+
+	testCases := map[string]struct {
+		path             string
+		wantResult       urlresolver.Result
+		wantInterstitial bool
+	}{
+		"given url is the interstitial": {
+			path: "/warning/direct",
+			wantResult: urlresolver.Result{
+				ResolvedURL: srv.URL + "/warning/direct",
+			},
+			wantInterstitial: true,
+		},
+		"bypassed with cookie": {
+			path: "/short",
+			wantResult: urlresolver.Result{
+				ResolvedURL:      srv.URL + "/article",
+				Title:            "Article",
+				IntermediateURLs: []string{srv.URL + "/short"},
+			},
+		},
+		"bypassed with header": {
+			path: "/wall",
+			wantResult: urlresolver.Result{
+				ResolvedURL: srv.URL + "/wall",
+				Title:       "Behind the wall",
+			},
+		},
+		"title match flagged in place": {
+			path: "/stubborn",
+			wantResult: urlresolver.Result{
+				ResolvedURL: srv.URL + "/stubborn",
+			},
+			wantInterstitial: true,
+		},
+		"rewound to url before interstitial": {
+			path: "/gate?utm_source=x",
+			wantResult: urlresolver.Result{
+				ResolvedURL:      srv.URL + "/gate",
+				IntermediateURLs: []string{},
+			},
+			wantInterstitial: true,
+		},
+	}
+	for name, tc := range testCases {
+		ctx, rec := resultmeta.NewContext(context.Background())
+		result, err := resolver.Resolve(ctx, srv.URL+tc.path)
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.wantResult, result, name)
+		assert.Equal(t, tc.wantInterstitial, rec.Meta().Interstitial, name)
+	}
+
+	// the consent cookie was sent on every hop of the retry
+	assert.Equal(t, []string{"/article"}, requestsWithCookie)
+
+	t.Run("cookies only sent to the interstitial's site", func(t *testing.T) {
+		var offsiteCookies []string
+		// use the "localhost" hostname to put the shortener on another site
+		shortener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("consent"); err == nil {
+				offsiteCookies = append(offsiteCookies, cookie.Value)
+			}
+			http.Redirect(w, r, srv.URL+"/article", http.StatusFound)
+		}))
+		t.Cleanup(shortener.Close)
+
+		shortURL := strings.Replace(shortener.URL, "127.0.0.1", "localhost", 1) + "/abc"
+		result, err := resolver.Resolve(context.Background(), shortURL)
+		assert.NoError(t, err)
+		assert.Equal(t, "Article", result.Title)
+		assert.Empty(t, offsiteCookies)
+	})
+}
+
+func TestResolverTimeout(t *testing.T) {
+	t.Parallel()
+
+	rules := New(Rule{
+		Name:    "wall",
+		Titles:  []*regexp.Regexp{regexp.MustCompile(`^Before you continue$`)},
+		Cookies: []*http.Cookie{{Name: "consent", Value: "yes"}},
+	})
+
+	var deadlines []time.Time
+	resolver := NewResolver(rules, resolverFunc(func(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+		deadline, _ := ctx.Deadline()
+		deadlines = append(deadlines, deadline)
+		return urlresolver.Result{ResolvedURL: givenURL, Title: "Before you continue"}, nil
+	}), time.Minute)
+
+	// the retry shares the first attempt's deadline
+	start := time.Now()
+	_, err := resolver.Resolve(context.Background(), "https://example.com/")
+	assert.NoError(t, err)
+	if assert.Len(t, deadlines, 2) {
+		assert.Equal(t, deadlines[0], deadlines[1])
+		assert.WithinDuration(t, start.Add(time.Minute), deadlines[0], time.Second)
+	}
+
+	// a shorter per-request timeout takes precedence
+	deadlines = nil
+	opts := resolveopts.Default()
+	opts.Timeout = time.Second
+	start = time.Now()
+	_, err = resolver.Resolve(resolveopts.NewContext(context.Background(), opts), "https://example.com/")
+	assert.NoError(t, err)
+	if assert.Len(t, deadlines, 2) {
+		assert.WithinDuration(t, start.Add(time.Second), deadlines[1], 500*time.Millisecond)
+	}
+
+	// there is no retry once the time is up
+	deadlines = nil
+	resolver = NewResolver(rules, resolverFunc(func(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+		deadlines = append(deadlines, time.Time{})
+		<-ctx.Done()
+		return urlresolver.Result{ResolvedURL: givenURL, Title: "Before you continue"}, nil
+	}), 10*time.Millisecond)
+	ctx, rec := resultmeta.NewContext(context.Background())
+	_, err = resolver.Resolve(ctx, "https://example.com/")
+	assert.NoError(t, err)
+	assert.Len(t, deadlines, 1)
+	assert.True(t, rec.Meta().Interstitial)
+}
+
+type resolverFunc func(ctx context.Context, givenURL string) (urlresolver.Result, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	return f(ctx, givenURL)
+}
+
+func TestRewind(t *testing.T) {
+	t.Parallel()
+
+	rule := Rule{URLs: []URLPattern{mustParseURLPattern(t, "consent.example.com")}}
+	testCases := map[string]struct {
+		result urlresolver.Result
+		want   urlresolver.Result
+	}{
+		"skips interstitial hops": {
+			result: urlresolver.Result{
+				ResolvedURL:      "https://consent.example.com/done",
+				Title:            "Consent",
+				IntermediateURLs: []string{"https://t.co/x", "https://news.example/a?utm_source=x", "https://consent.example.com/start"},
+			},
+			want: urlresolver.Result{
+				ResolvedURL:      "https://news.example/a",
+				IntermediateURLs: []string{"https://t.co/x"},
+			},
+		},
+		"given url is the interstitial": {
+			result: urlresolver.Result{
+				ResolvedURL: "https://consent.example.com/done",
+				Title:       "Consent",
+			},
+			want: urlresolver.Result{
+				ResolvedURL: "https://consent.example.com/done",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, rewind(tc.result, rule))
+		})
+	}
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func mustParseURLPattern(t *testing.T, s string) URLPattern {
+	t.Helper()
+	p, err := ParseURLPattern(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,142 @@
+package interstitial
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+// Outcomes of detecting an interstitial, for instrumentation purposes.
+const (
+	outcomeBypassed = "bypassed"
+	outcomeFlagged  = "flagged"
+)
+
+// Resolver is a urlresolver.Interface implementation that detects results
+// ending at an interstitial, and either retries with the matching Rule's
+// cookies and headers or rewinds the result to the URL before the
+// interstitial.
+//
+// The first attempt and any retry share a single timeout, so that a retry
+// does not extend the time spent resolving a URL.
+type Resolver struct {
+	rules    *Rules
+	resolver urlresolver.Interface
+	timeout  time.Duration
+}
+
+// NewResolver creates a new Resolver whose attempts to resolve a URL take no
+// longer than the given timeout, or the timeout given in the context's
+// resolveopts.Options, in total. A zero timeout means no timeout beyond the
+// context's own.
+func NewResolver(rules *Rules, resolver urlresolver.Interface, timeout time.Duration) *Resolver {
+	return &Resolver{
+		rules:    rules,
+		resolver: resolver,
+		timeout:  timeout,
+	}
+}
+
+// Resolve resolves a URL, getting past or flagging any interstitial.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	timeout := r.timeout
+	if opts := resolveopts.FromContext(ctx); opts.Timeout > 0 && (timeout == 0 || opts.Timeout < timeout) {
+		timeout = opts.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result, err := r.resolver.Resolve(ctx, givenURL)
+	if err != nil {
+		return result, err
+	}
+	rule, found := r.rules.Detect(result)
+	if !found {
+		return result, nil
+	}
+	beeline.AddField(ctx, "interstitial.rule", rule.Name)
+	beeline.AddField(ctx, "interstitial.url", result.ResolvedURL)
+
+	// the retry only gets whatever time the first attempt left
+	if rule.Bypassable() && ctx.Err() == nil {
+		retryCtx := context.WithValue(ctx, retryKey, retry{rule: rule, host: hostname(result.ResolvedURL)})
+		retried, err := r.resolver.Resolve(retryCtx, givenURL)
+		if err == nil {
+			if _, found := r.rules.Detect(retried); !found {
+				beeline.AddField(ctx, "interstitial.outcome", outcomeBypassed)
+				return retried, nil
+			}
+		} else {
+			beeline.AddField(ctx, "interstitial.retry_error", err.Error())
+		}
+	}
+
+	beeline.AddField(ctx, "interstitial.outcome", outcomeFlagged)
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.Interstitial = true })
+	return rewind(result, rule), nil
+}
+
+// rewind returns the result as of the last URL before the interstitial. The
+// title is always dropped, since it is the interstitial's title or no title
+// at all.
+func rewind(result urlresolver.Result, rule Rule) urlresolver.Result {
+	rewound := urlresolver.Result{
+		ResolvedURL:      result.ResolvedURL,
+		IntermediateURLs: result.IntermediateURLs,
+	}
+
+	// if only the title matched, the interstitial was served in place of
+	// the resolved URL
+	if !rule.MatchURL(result.ResolvedURL) {
+		return rewound
+	}
+
+	// otherwise, the last redirect hop not matching the rule led to the
+	// interstitial
+	for i := len(result.IntermediateURLs) - 1; i >= 0; i-- {
+		hop := result.IntermediateURLs[i]
+		if rule.MatchURL(hop) {
+			continue
+		}
+		if parsed, err := url.Parse(hop); err == nil {
+			rewound.ResolvedURL = urlresolver.Canonicalize(parsed)
+			rewound.IntermediateURLs = result.IntermediateURLs[:i]
+		}
+		break
+	}
+	return rewound
+}
+
+func hostname(rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil {
+		return parsed.Hostname()
+	}
+	return ""
+}
+
+// retry describes a Resolver's retry of a URL that led to an interstitial
+// on the given host.
+type retry struct {
+	rule Rule
+	host string
+}
+
+type retryKeyType int
+
+const retryKey = retryKeyType(1)
+
+// retryFromContext returns the retry being made, if the request is being
+// made on behalf of a Resolver's retry.
+func retryFromContext(ctx context.Context) (retry, bool) {
+	r, ok := ctx.Value(retryKey).(retry)
+	return r, ok
+}
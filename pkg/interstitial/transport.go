@@ -0,0 +1,44 @@
+package interstitial
+
+import (
+	"net/http"
+
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+)
+
+// Transport is an http.RoundTripper that sends the cookies and headers of
+// the Rule being retried by a Resolver with every request to the site of the
+// interstitial, including every redirect hop on that site. Requests to other
+// sites are passed on unchanged, so that a rule's cookies never leak to
+// unrelated hosts.
+//
+// Transport should be wrapped by any transport that sets default request
+// headers, so that its headers take precedence.
+type Transport struct {
+	transport http.RoundTripper
+}
+
+// NewTransport creates a new Transport.
+func NewTransport(transport http.RoundTripper) *Transport {
+	return &Transport{
+		transport: transport,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retry, found := retryFromContext(req.Context())
+	if !found || !hostmatch.SameSite(req.URL.Hostname(), retry.host) {
+		return t.transport.RoundTrip(req)
+	}
+
+	rule := retry.rule
+	req = req.Clone(req.Context())
+	for key, values := range rule.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	for _, cookie := range rule.Cookies {
+		req.AddCookie(cookie)
+	}
+	return t.transport.RoundTrip(req)
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+)
+
+// Callback request headers.
+const (
+	SignatureHeader = "X-Urlresolver-Signature"
+	JobIDHeader     = "X-Urlresolver-Job-Id"
+)
+
+// Sign returns the signature of a callback request body, in the form used
+// in the SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether the given signature is valid for the
+// given callback request body.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// deliverCallback POSTs the completed job to its callback URL, retrying with
+// exponential backoff until it succeeds, attempts are exhausted, or the
+// runner starts shutting down.
+func (r *Runner) deliverCallback(ctx context.Context, job *Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	signature := Sign(r.opts.CallbackSecret, body)
+
+	backoff := r.opts.CallbackBackoff
+	for attempt := 1; ; attempt++ {
+		err = r.postCallback(ctx, job, body, signature, attempt)
+		if err == nil || attempt >= r.opts.CallbackAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-r.closing:
+			return fmt.Errorf("%w: %s", ErrShuttingDown, err)
+		}
+	}
+}
+
+func (r *Runner) postCallback(ctx context.Context, job *Job, body []byte, signature string, attempt int) error {
+	ctx, span := beeline.StartSpan(ctx, "jobs.callback")
+	span.AddField("job.callback_attempt", attempt)
+	defer span.Send()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+	req.Header.Set(JobIDHeader, job.ID)
+
+	resp, err := r.opts.CallbackClient.Do(req)
+	if err != nil {
+		span.AddField("error", err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.CopyN(io.Discard, resp.Body, 4*1024)
+
+	span.AddField("job.callback_status_code", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("callback failed with status %d", resp.StatusCode)
+		span.AddField("error", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+/*
+Package jobs resolves batches of URLs asynchronously.
+
+A job is submitted with one or more URLs and an optional callback URL. It is
+saved to a Store and queued to be resolved in the background by a Runner,
+which records the results in the Store when finished. If a callback URL was
+given, the completed job is then POSTed to it, signed with an HMAC-SHA256
+signature of the request body:
+
+	X-Urlresolver-Signature: sha256=<hex-encoded signature>
+
+Failed callback deliveries are retried with exponential backoff.
+
+Jobs still queued or running when a Runner's shutdown times out are marked
+failed, so that clients polling for their results do not wait on them until
+they expire.
+*/
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+// Errors that may be returned by Runner.Submit.
+var (
+	ErrCallbacksDisabled  = errors.New("callbacks disabled")
+	ErrInvalidCallbackURL = errors.New("invalid callback url")
+	ErrNoURLs             = errors.New("no urls")
+	ErrQueueFull          = errors.New("job queue full")
+	ErrShuttingDown       = errors.New("shutting down")
+	ErrTooManyURLs        = errors.New("too many urls")
+)
+
+// Job statuses.
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Callback statuses.
+const (
+	CallbackPending   = "pending"
+	CallbackDelivered = "delivered"
+	CallbackFailed    = "failed"
+)
+
+// Job is a batch of URLs to resolve asynchronously.
+type Job struct {
+	ID             string     `json:"id"`
+	Status         string     `json:"status"`
+	URLs           []string   `json:"urls"`
+	Results        []Result   `json:"results"`
+	CallbackURL    string     `json:"callback_url,omitempty"`
+	CallbackStatus string     `json:"callback_status,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+func (j Job) clone() Job {
+	j.URLs = append([]string(nil), j.URLs...)
+	j.Results = append([]Result(nil), j.Results...)
+	return j
+}
+
+// Result is the result of resolving a single URL in a job.
+type Result struct {
+	GivenURL         string   `json:"given_url"`
+	ResolvedURL      string   `json:"resolved_url"`
+	Title            string   `json:"title"`
+	IntermediateURLs []string `json:"intermediate_urls"`
+	Error            string   `json:"error,omitempty"`
+	ErrorCode        string   `json:"error_code,omitempty"`
+}
+
+// Options configures a Runner.
+type Options struct {
+	// Workers is the number of jobs that may run concurrently.
+	Workers int
+
+	// QueueSize is the max number of jobs waiting to run, beyond which new
+	// jobs are rejected.
+	QueueSize int
+
+	// MaxURLs is the max number of URLs in a single job.
+	MaxURLs int
+
+	// URLConcurrency is the number of URLs within a single job that may be
+	// resolved concurrently.
+	URLConcurrency int
+
+	// MapError maps resolve errors to errors that are safe to expose to
+	// clients.
+	MapError func(error) error
+
+	// ErrorCode maps resolve errors to stable, machine-readable codes.
+	ErrorCode func(error) string
+
+	// CallbackClient is used to deliver callbacks. Because callback URLs are
+	// provided by clients, its transport should only allow connections to
+	// safe addresses.
+	CallbackClient *http.Client
+
+	// CallbackSecret is used to sign callback requests. If empty, jobs with
+	// callback URLs are rejected.
+	CallbackSecret string
+
+	// CallbackAttempts is the max number of attempts to deliver a callback.
+	CallbackAttempts int
+
+	// CallbackBackoff is the delay before the first retry of a failed
+	// callback, which doubles after each subsequent attempt.
+	CallbackBackoff time.Duration
+}
+
+// Runner resolves jobs in the background.
+type Runner struct {
+	resolver urlresolver.Interface
+	store    Store
+	opts     Options
+
+	queue chan *Job
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	// closing is closed when shutdown starts, to cut callback retries short
+	closing chan struct{}
+
+	// ctx is canceled when shutdown times out, to abandon running jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// running holds a copy of each running job as it was submitted, so that
+	// it may be marked failed if shutdown times out. runningMu is held while
+	// running jobs are saved, so that a job marked failed is not then
+	// overwritten by its worker.
+	runningMu sync.Mutex
+	running   map[string]Job
+}
+
+// NewRunner creates a new Runner that resolves jobs using the given resolver
+// and records their state in the given store.
+func NewRunner(resolver urlresolver.Interface, store Store, opts Options) *Runner {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.URLConcurrency < 1 {
+		opts.URLConcurrency = 1
+	}
+	if opts.MapError == nil {
+		opts.MapError = func(err error) error { return err }
+	}
+	if opts.ErrorCode == nil {
+		opts.ErrorCode = func(error) string { return "" }
+	}
+	if opts.CallbackClient == nil {
+		opts.CallbackClient = http.DefaultClient
+	}
+	if opts.CallbackAttempts < 1 {
+		opts.CallbackAttempts = 1
+	}
+
+	r := &Runner{
+		resolver: resolver,
+		store:    store,
+		opts:     opts,
+		queue:    make(chan *Job, opts.QueueSize),
+		closing:  make(chan struct{}),
+		running:  make(map[string]Job),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// Submit validates and saves a new job and queues it to run in the
+// background, returning the pending job.
+func (r *Runner) Submit(ctx context.Context, urls []string, callbackURL string) (*Job, error) {
+	if len(urls) == 0 {
+		return nil, ErrNoURLs
+	}
+	if r.opts.MaxURLs > 0 && len(urls) > r.opts.MaxURLs {
+		return nil, fmt.Errorf("%w: max %d", ErrTooManyURLs, r.opts.MaxURLs)
+	}
+	if callbackURL != "" {
+		if r.opts.CallbackSecret == "" {
+			return nil, ErrCallbacksDisabled
+		}
+		if !isValidCallbackURL(callbackURL) {
+			return nil, ErrInvalidCallbackURL
+		}
+	}
+
+	job := &Job{
+		ID:          newJobID(),
+		Status:      StatusPending,
+		URLs:        urls,
+		Results:     []Result{},
+		CallbackURL: callbackURL,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	// the queued job will be modified by a worker, so the caller gets a copy
+	pending := job.clone()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrShuttingDown
+	}
+	if err := r.store.Save(ctx, job); err != nil {
+		return nil, err
+	}
+	select {
+	case r.queue <- job:
+	default:
+		// the job will never run, so don't leave it pending in the store
+		_ = r.store.Delete(ctx, job.ID)
+		return nil, ErrQueueFull
+	}
+
+	beeline.AddField(ctx, "job.id", job.ID)
+	beeline.AddField(ctx, "job.url_count", len(urls))
+	return &pending, nil
+}
+
+// Get returns the current state of a job, or ErrNotFound.
+func (r *Runner) Get(ctx context.Context, id string) (*Job, error) {
+	return r.store.Get(ctx, id)
+}
+
+// Shutdown stops accepting new jobs and waits for queued and running jobs
+// to finish, or for the given context to be done, whichever comes first. In
+// the latter case, running jobs are canceled and every unfinished job is
+// marked failed before returning.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+		close(r.closing)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	r.cancel()
+	// the jobs must still be recorded as failed after the deadline
+	saveCtx := context.WithoutCancel(ctx)
+	for job := range r.queue {
+		r.fail(saveCtx, job.clone())
+	}
+	r.runningMu.Lock()
+	defer r.runningMu.Unlock()
+	for id, job := range r.running {
+		r.fail(saveCtx, job)
+		delete(r.running, id)
+	}
+	return ctx.Err()
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for job := range r.queue {
+		r.run(job)
+	}
+}
+
+func (r *Runner) run(job *Job) {
+	ctx, span := beeline.StartSpan(r.ctx, "jobs.run")
+	span.AddField("job.id", job.ID)
+	span.AddField("job.url_count", len(job.URLs))
+	defer span.Send()
+
+	// the job's state is saved even if it is abandoned by shutdown
+	saveCtx := context.WithoutCancel(ctx)
+	if !r.start(saveCtx, job) {
+		span.AddField("job.abandoned", true)
+		return
+	}
+
+	job.Results = r.resolveAll(ctx, job.URLs)
+	job.Status = StatusCompleted
+	completedAt := time.Now().UTC()
+	job.CompletedAt = &completedAt
+	if job.CallbackURL != "" {
+		job.CallbackStatus = CallbackPending
+	}
+	if !r.finish(saveCtx, job) {
+		span.AddField("job.abandoned", true)
+		return
+	}
+
+	if job.CallbackURL == "" {
+		return
+	}
+	if err := r.deliverCallback(ctx, job); err != nil {
+		span.AddField("job.callback_error", err.Error())
+		job.CallbackStatus = CallbackFailed
+	} else {
+		job.CallbackStatus = CallbackDelivered
+	}
+	if err := r.store.Save(saveCtx, job); err != nil {
+		span.AddField("error", err.Error())
+	}
+}
+
+// start marks a job as running, unless shutdown has already timed out, in
+// which case the job is marked failed instead and false is returned.
+func (r *Runner) start(ctx context.Context, job *Job) bool {
+	r.runningMu.Lock()
+	defer r.runningMu.Unlock()
+
+	if r.ctx.Err() != nil {
+		r.fail(ctx, job.clone())
+		return false
+	}
+	r.running[job.ID] = job.clone()
+	job.Status = StatusRunning
+	if err := r.store.Save(ctx, job); err != nil {
+		beeline.AddField(ctx, "error", err.Error())
+	}
+	return true
+}
+
+// finish saves a completed job, unless shutdown timed out while it was
+// running, in which case it has already been marked failed and false is
+// returned.
+func (r *Runner) finish(ctx context.Context, job *Job) bool {
+	r.runningMu.Lock()
+	defer r.runningMu.Unlock()
+
+	if _, found := r.running[job.ID]; !found {
+		return false
+	}
+	delete(r.running, job.ID)
+	if err := r.store.Save(ctx, job); err != nil {
+		beeline.AddField(ctx, "error", err.Error())
+	}
+	return true
+}
+
+// fail marks a job that will never complete as failed.
+func (r *Runner) fail(ctx context.Context, job Job) {
+	job.Status = StatusFailed
+	job.Error = ErrShuttingDown.Error()
+	job.Results = []Result{}
+	completedAt := time.Now().UTC()
+	job.CompletedAt = &completedAt
+	if err := r.store.Save(ctx, &job); err != nil {
+		beeline.AddField(ctx, "error", err.Error())
+	}
+}
+
+func (r *Runner) resolveAll(ctx context.Context, urls []string) []Result {
+	results := make([]Result, len(urls))
+	sem := make(chan struct{}, r.opts.URLConcurrency)
+	var wg sync.WaitGroup
+	for i, givenURL := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, givenURL string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = r.resolve(ctx, givenURL)
+		}(i, givenURL)
+	}
+	wg.Wait()
+	return results
+}
+
+func (r *Runner) resolve(ctx context.Context, givenURL string) Result {
+	result, err := r.resolver.Resolve(ctx, givenURL)
+	res := Result{
+		GivenURL:         givenURL,
+		ResolvedURL:      result.ResolvedURL,
+		Title:            result.Title,
+		IntermediateURLs: result.IntermediateURLs,
+	}
+	if res.IntermediateURLs == nil {
+		res.IntermediateURLs = []string{}
+	}
+	if err != nil {
+		res.Error = r.opts.MapError(err).Error()
+		res.ErrorCode = r.opts.ErrorCode(err)
+	}
+	return res
+}
+
+func isValidCallbackURL(callbackURL string) bool {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return false
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return false
+	}
+	return parsed.Hostname() != ""
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,356 @@
+//nolint:errcheck
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+var errResolve = errors.New("resolve error")
+
+// stubResolver resolves every URL to itself, except for the error URL.
+type stubResolver struct{}
+
+func (stubResolver) Resolve(_ context.Context, givenURL string) (urlresolver.Result, error) {
+	if givenURL == "https://error.example/" {
+		return urlresolver.Result{ResolvedURL: givenURL}, errResolve
+	}
+	return urlresolver.Result{ResolvedURL: givenURL, Title: "title"}, nil
+}
+
+func TestRunner(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runner := NewRunner(stubResolver{}, NewMemoryStore(time.Hour), Options{
+		Workers:   2,
+		QueueSize: 10,
+		MaxURLs:   2,
+		MapError:  func(err error) error { return errors.New("mapped") },
+		ErrorCode: func(err error) string { return "mapped_code" },
+	})
+
+	job, err := runner.Submit(ctx, []string{"https://example.com/", "https://error.example/"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, job.Status)
+	assert.Len(t, job.ID, 32)
+
+	// shutdown drains the queue
+	assert.NoError(t, runner.Shutdown(ctx))
+
+	got, err := runner.Get(ctx, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, got.Status)
+	assert.NotNil(t, got.CompletedAt)
+	assert.Equal(t, []Result{
+		{GivenURL: "https://example.com/", ResolvedURL: "https://example.com/", Title: "title", IntermediateURLs: []string{}},
+		{GivenURL: "https://error.example/", ResolvedURL: "https://error.example/", IntermediateURLs: []string{}, Error: "mapped", ErrorCode: "mapped_code"},
+	}, got.Results)
+
+	_, err = runner.Get(ctx, "does-not-exist")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = runner.Submit(ctx, []string{"https://example.com/"}, "")
+	assert.ErrorIs(t, err, ErrShuttingDown)
+}
+
+func TestRunnerSubmitErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		opts        Options
+		urls        []string
+		callbackURL string
+		wantErr     error
+	}{
+		"no urls": {
+			wantErr: ErrNoURLs,
+		},
+		"too many urls": {
+			opts:    Options{MaxURLs: 1},
+			urls:    []string{"https://a.example/", "https://b.example/"},
+			wantErr: ErrTooManyURLs,
+		},
+		"callbacks disabled without secret": {
+			urls:        []string{"https://a.example/"},
+			callbackURL: "https://callback.example/",
+			wantErr:     ErrCallbacksDisabled,
+		},
+		"invalid callback url": {
+			opts:        Options{CallbackSecret: "secret"},
+			urls:        []string{"https://a.example/"},
+			callbackURL: "ftp://callback.example/",
+			wantErr:     ErrInvalidCallbackURL,
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			runner := NewRunner(stubResolver{}, NewMemoryStore(time.Hour), tc.opts)
+			defer runner.Shutdown(context.Background())
+			_, err := runner.Submit(context.Background(), tc.urls, tc.callbackURL)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+// savedIDsStore is a MemoryStore that records the IDs of the jobs it saves.
+type savedIDsStore struct {
+	*MemoryStore
+
+	mu  sync.Mutex
+	ids []string
+}
+
+func (s *savedIDsStore) Save(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	s.ids = append(s.ids, job.ID)
+	s.mu.Unlock()
+	return s.MemoryStore.Save(ctx, job)
+}
+
+type resolverFunc func(ctx context.Context, givenURL string) (urlresolver.Result, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	return f(ctx, givenURL)
+}
+
+func TestRunnerQueueFull(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	resolver := resolverFunc(func(_ context.Context, givenURL string) (urlresolver.Result, error) {
+		started <- struct{}{}
+		<-release
+		return urlresolver.Result{ResolvedURL: givenURL}, nil
+	})
+
+	ctx := context.Background()
+	store := &savedIDsStore{MemoryStore: NewMemoryStore(time.Hour)}
+	runner := NewRunner(resolver, store, Options{
+		Workers:   1,
+		QueueSize: 1,
+	})
+	defer runner.Shutdown(ctx)
+	defer close(release)
+
+	// the first job occupies the worker and the second fills the queue
+	_, err := runner.Submit(ctx, []string{"https://a.example/"}, "")
+	assert.NoError(t, err)
+	<-started
+	_, err = runner.Submit(ctx, []string{"https://b.example/"}, "")
+	assert.NoError(t, err)
+
+	_, err = runner.Submit(ctx, []string{"https://c.example/"}, "")
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	// the rejected job is not left pending in the store
+	store.mu.Lock()
+	rejectedID := store.ids[len(store.ids)-1]
+	store.mu.Unlock()
+	_, err = runner.Get(ctx, rejectedID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRunnerShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	canceled := make(chan struct{})
+	resolver := resolverFunc(func(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		close(canceled)
+		return urlresolver.Result{}, ctx.Err()
+	})
+
+	ctx := context.Background()
+	runner := NewRunner(resolver, NewMemoryStore(time.Hour), Options{
+		Workers:   1,
+		QueueSize: 1,
+	})
+
+	// the first job occupies the worker and the second waits in the queue
+	running, err := runner.Submit(ctx, []string{"https://a.example/"}, "")
+	assert.NoError(t, err)
+	<-started
+	queued, err := runner.Submit(ctx, []string{"https://b.example/"}, "")
+	assert.NoError(t, err)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, runner.Shutdown(shutdownCtx), context.DeadlineExceeded)
+
+	// neither job is left unfinished once shutdown returns
+	for _, job := range []*Job{running, queued} {
+		got, err := runner.Get(ctx, job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusFailed, got.Status)
+		assert.Equal(t, ErrShuttingDown.Error(), got.Error)
+		assert.NotNil(t, got.CompletedAt)
+	}
+
+	// the running job is canceled, and its worker does not overwrite the
+	// failed job when it returns
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("running job was not canceled")
+	}
+	runner.wg.Wait()
+	got, err := runner.Get(ctx, running.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFailed, got.Status)
+}
+
+func TestRunnerCallback(t *testing.T) {
+	t.Parallel()
+
+	const secret = "secret"
+
+	var attempts int64
+	delivered := make(chan Job, 1)
+	callbackSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt to exercise retries
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if !VerifySignature(secret, body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var job Job
+		json.Unmarshal(body, &job)
+		assert.Equal(t, job.ID, r.Header.Get(JobIDHeader))
+		delivered <- job
+	}))
+	defer callbackSrv.Close()
+
+	ctx := context.Background()
+	store := NewMemoryStore(time.Hour)
+	runner := NewRunner(stubResolver{}, store, Options{
+		QueueSize:        1,
+		CallbackSecret:   secret,
+		CallbackAttempts: 3,
+		CallbackBackoff:  time.Millisecond,
+	})
+
+	job, err := runner.Submit(ctx, []string{"https://example.com/"}, callbackSrv.URL)
+	assert.NoError(t, err)
+
+	select {
+	case got := <-delivered:
+		assert.Equal(t, job.ID, got.ID)
+		assert.Equal(t, StatusCompleted, got.Status)
+		assert.Equal(t, CallbackPending, got.CallbackStatus)
+		assert.Len(t, got.Results, 1)
+	case <-time.After(time.Second):
+		t.Fatal("callback not delivered")
+	}
+
+	assert.NoError(t, runner.Shutdown(ctx))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&attempts))
+
+	got, err := store.Get(ctx, job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, CallbackDelivered, got.CallbackStatus)
+}
+
+func TestRunnerCallbackFailure(t *testing.T) {
+	t.Parallel()
+
+	var attempts int64
+	callbackSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer callbackSrv.Close()
+
+	ctx := context.Background()
+	store := NewMemoryStore(time.Hour)
+	runner := NewRunner(stubResolver{}, store, Options{
+		QueueSize:        1,
+		CallbackSecret:   "secret",
+		CallbackAttempts: 3,
+		CallbackBackoff:  time.Millisecond,
+	})
+
+	job, err := runner.Submit(ctx, []string{"https://example.com/"}, callbackSrv.URL)
+	assert.NoError(t, err)
+
+	// wait for all attempts before shutting down, which would otherwise cut
+	// retries short
+	assert.Eventually(t, func() bool {
+		got, _ := store.Get(ctx, job.ID)
+		return got.CallbackStatus == CallbackFailed
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, runner.Shutdown(ctx))
+	assert.Equal(t, int64(3), atomic.LoadInt64(&attempts))
+}
+
+func TestSignature(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"id":"abc"}`)
+	sig := Sign("secret", body)
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, sig)
+	assert.True(t, VerifySignature("secret", body, sig))
+	assert.False(t, VerifySignature("other-secret", body, sig))
+	assert.False(t, VerifySignature("secret", []byte(`{"id":"xyz"}`), sig))
+}
+
+func TestRedisStore(t *testing.T) {
+	t.Parallel()
+
+	redisSrv, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer redisSrv.Close()
+
+	ctx := context.Background()
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: redisSrv.Addr()}), time.Minute)
+
+	job := &Job{
+		ID:        "abc",
+		Status:    StatusPending,
+		URLs:      []string{"https://example.com/"},
+		Results:   []Result{},
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	assert.NoError(t, store.Save(ctx, job))
+
+	got, err := store.Get(ctx, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, job, got)
+
+	// jobs expire after their TTL
+	redisSrv.FastForward(2 * time.Minute)
+	_, err = store.Get(ctx, "abc")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, store.Save(ctx, job))
+	assert.NoError(t, store.Delete(ctx, "abc"))
+	_, err = store.Get(ctx, "abc")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, store.Delete(ctx, "abc"))
+}
@@ -0,0 +1,137 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrNotFound is returned when a job does not exist or has expired.
+var ErrNotFound = errors.New("job not found")
+
+const redisJobVersion = "1"
+
+// Store persists job state, so that any instance can answer status queries
+// for any job.
+type Store interface {
+	Save(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id string) (*Job, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// RedisStore stores jobs in redis.
+type RedisStore struct {
+	client redis.Cmdable
+	ttl    time.Duration
+}
+
+var _ Store = &RedisStore{} // RedisStore implements Store
+
+// NewRedisStore creates a new RedisStore whose jobs expire after the given
+// TTL.
+func NewRedisStore(client redis.Cmdable, ttl time.Duration) *RedisStore {
+	return &RedisStore{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+// Save saves a job.
+func (s *RedisStore) Save(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, redisJobKey(job.ID), data, s.ttl).Err()
+}
+
+// Get gets a job, returning ErrNotFound if it does not exist.
+func (s *RedisStore) Get(ctx context.Context, id string) (*Job, error) {
+	data, err := s.client.Get(ctx, redisJobKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Delete deletes a job. Deleting a job that does not exist is not an error.
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return s.client.Del(ctx, redisJobKey(id)).Err()
+}
+
+func redisJobKey(id string) string {
+	return "job:" + redisJobVersion + ":" + id
+}
+
+// MemoryStore stores jobs in memory. It is only suitable for a single
+// instance, or for testing.
+type MemoryStore struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]memoryEntry
+}
+
+var _ Store = &MemoryStore{} // MemoryStore implements Store
+
+type memoryEntry struct {
+	job       Job
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a new MemoryStore whose jobs expire after the given
+// TTL.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:  ttl,
+		jobs: make(map[string]memoryEntry),
+	}
+}
+
+// Save saves a job.
+func (s *MemoryStore) Save(_ context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// evict expired jobs to bound memory usage
+	now := time.Now()
+	for id, entry := range s.jobs {
+		if now.After(entry.expiresAt) {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = memoryEntry{job: job.clone(), expiresAt: now.Add(s.ttl)}
+	return nil
+}
+
+// Get gets a job, returning ErrNotFound if it does not exist.
+func (s *MemoryStore) Get(_ context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.jobs[id]
+	if !found || time.Now().After(entry.expiresAt) {
+		return nil, ErrNotFound
+	}
+	job := entry.job.clone()
+	return &job, nil
+}
+
+// Delete deletes a job. Deleting a job that does not exist is not an error.
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
@@ -0,0 +1,172 @@
+/*
+Package pageinfo identifies the content type of resolved pages, and extracts
+a fallback title for pages that are not HTML and so have no <title>.
+
+The label depends on the content type:
+
+  - PDFs are labeled with their document title metadata
+  - images are labeled with their type and dimensions, e.g. "PNG image,
+    800×600"
+  - audio and video are labeled with their filename
+  - anything else is labeled with its Content-Disposition filename
+
+Transport sniffs the final response of each resolve, reading a bounded
+number of bytes from non-HTML responses, and Resolver uses the label as the
+title of results that have none, recording the content type in the
+context's resultmeta.Recorder:
+
+	transport = pageinfo.NewTransport(transport, pageinfo.Options{})
+	resolver := pageinfo.NewResolver(urlresolver.New(transport, timeout))
+
+Labels are only extracted from responses whose bodies were actually fetched,
+so options that skip fetching titles skip labels too.
+*/
+package pageinfo
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF decoder for image.DecodeConfig
+	_ "image/jpeg" // register JPEG decoder for image.DecodeConfig
+	_ "image/png"  // register PNG decoder for image.DecodeConfig
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultMaxBytes is the default for Options.MaxBytes.
+const DefaultMaxBytes = 1 << 20
+
+// maxLabelLength limits the length of labels, which may come from arbitrary
+// metadata.
+const maxLabelLength = 300
+
+// Options configure a Transport.
+type Options struct {
+	// MaxBytes is the maximum number of bytes read from a non-HTML response
+	// while extracting its label.
+	MaxBytes int64
+}
+
+// Info describes the final response of a resolve.
+type Info struct {
+	// ContentType is the media type of the response, without parameters,
+	// as given by its Content-Type header or sniffed from its body.
+	ContentType string
+
+	// Label is a fallback title for non-HTML responses, if one could be
+	// extracted.
+	Label string
+}
+
+// isHTML reports whether a content type is expected to have a <title>.
+func isHTML(contentType string) bool {
+	return contentType == "text/html" || contentType == "application/xhtml+xml"
+}
+
+// mediaType returns the lowercased media type from a Content-Type header,
+// without parameters.
+func mediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// extractLabel extracts a label from a response according to its content
+// type, reading at most maxBytes of its body.
+func extractLabel(contentType string, resp *http.Response, body io.Reader, maxBytes int64) string {
+	var label string
+	switch {
+	case contentType == "application/pdf":
+		buf, _ := io.ReadAll(io.LimitReader(body, maxBytes))
+		label = pdfTitle(buf)
+		if label == "" {
+			label = dispositionFilename(resp.Header)
+		}
+	case strings.HasPrefix(contentType, "image/"):
+		label = imageLabel(contentType, io.LimitReader(body, maxBytes))
+	case strings.HasPrefix(contentType, "audio/"), strings.HasPrefix(contentType, "video/"):
+		label = dispositionFilename(resp.Header)
+		if label == "" && resp.Request != nil {
+			label = urlFilename(resp.Request.URL)
+		}
+	default:
+		label = dispositionFilename(resp.Header)
+	}
+	return truncate(label)
+}
+
+// imageLabel describes an image by its type and, if it can be decoded,
+// its dimensions.
+func imageLabel(contentType string, r io.Reader) string {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		// e.g. "image/svg+xml" is described as an "SVG image"
+		format, _, _ = strings.Cut(strings.TrimPrefix(contentType, "image/"), "+")
+		return strings.ToUpper(format) + " image"
+	}
+	return fmt.Sprintf("%s image, %d×%d", strings.ToUpper(format), cfg.Width, cfg.Height)
+}
+
+// dispositionFilename returns the filename from a Content-Disposition
+// header, if any.
+func dispositionFilename(header http.Header) string {
+	_, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return cleanFilename(params["filename"])
+}
+
+// urlFilename returns the last segment of a URL's path, if it looks like a
+// filename.
+func urlFilename(u *url.URL) string {
+	name := path.Base(u.Path)
+	if !strings.Contains(name, ".") {
+		return ""
+	}
+	return cleanFilename(name)
+}
+
+func cleanFilename(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// truncate limits a label to maxLabelLength runes, collapsing whitespace.
+func truncate(label string) string {
+	label = strings.Join(strings.Fields(label), " ")
+	if runes := []rune(label); len(runes) > maxLabelLength {
+		label = string(runes[:maxLabelLength-1]) + "…"
+	}
+	return label
+}
+
+// readCloser combines a reader wrapping a response body with the body's
+// Close method.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sniffLen is the number of bytes used to sniff the content type of
+// responses without a Content-Type header.
+const sniffLen = 512
+
+// peek reads the first bytes of r for sniffing, returning them along with
+// a reader that still yields every byte of r.
+func peek(r io.Reader) ([]byte, io.Reader) {
+	buf := make([]byte, sniffLen)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	return buf, io.MultiReader(bytes.NewReader(buf), r)
+}
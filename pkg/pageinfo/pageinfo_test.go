@@ -0,0 +1,214 @@
+//nolint:errcheck
+package pageinfo
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+func TestPDFTitle(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		pdf  string
+		want string
+	}{
+		"literal string": {
+			pdf:  "%PDF-1.4\n1 0 obj\n<< /Title (Annual Report) /Author (Someone) >>\nendobj",
+			want: "Annual Report",
+		},
+		"escapes and nested parentheses": {
+			pdf:  `<</Title(Q3 \(draft\) results (final (really))\r\nnext line \101\102C)>>`,
+			want: "Q3 (draft) results (final (really))\r\nnext line ABC",
+		},
+		"hex string": {
+			pdf:  "<< /Title <416E6E75616C205265706F7274> >>",
+			want: "Annual Report",
+		},
+		"utf-16 hex string": {
+			pdf:  "<< /Title <FEFF00430061006600E9> >>",
+			want: "Café",
+		},
+		"latin-1 literal string": {
+			pdf:  "<< /Title (Caf\\351) >>",
+			want: "Café",
+		},
+		"empty title falls back to next title": {
+			pdf:  "<< /Title () >> << /Title (Second) >>",
+			want: "Second",
+		},
+		"xmp metadata": {
+			pdf:  `<x:xmpmeta><dc:title><rdf:Alt><rdf:li xml:lang="x-default">Fish &amp; Chips</rdf:li></rdf:Alt></dc:title></x:xmpmeta>`,
+			want: "Fish & Chips",
+		},
+		"info dictionary preferred": {
+			pdf:  `<dc:title><rdf:Alt><rdf:li>XMP</rdf:li></rdf:Alt></dc:title> << /Title (Info) >>`,
+			want: "Info",
+		},
+		"dictionary value is not a title": {
+			pdf:  "<< /Title << /Foo (Bar) >> >>",
+			want: "",
+		},
+		"no title": {
+			pdf:  "%PDF-1.4\n1 0 obj\n<< /Author (Someone) >>",
+			want: "",
+		},
+		"truncated": {
+			pdf:  "<< /Title (Unterminated",
+			want: "Unterminated",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, pdfTitle([]byte(tc.pdf)))
+		})
+	}
+}
+
+func pngBytes(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	pngImage := pngBytes(t, 800, 600)
+	bigPDF := "%PDF-1.4\n" + strings.Repeat("x", 2048) + "<< /Title (Too Far) >>"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<title>HTML title</title>"))
+	})
+	mux.HandleFunc("/untitled", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>no title</p>"))
+	})
+	mux.HandleFunc("/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4\n<< /Title (Annual Report) >>"))
+	})
+	mux.HandleFunc("/big.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `inline; filename="big-report.pdf"`)
+		w.Write([]byte(bigPDF))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngImage)
+	})
+	mux.HandleFunc("/sniffed", func(w http.ResponseWriter, r *http.Request) {
+		// prevent the server from sniffing the content type itself
+		w.Header()["Content-Type"] = nil
+		w.Write(pngImage)
+	})
+	mux.HandleFunc("/logo.svg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`))
+	})
+	mux.HandleFunc("/media/clip.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write([]byte("not really a video"))
+	})
+	mux.HandleFunc("/media/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''Caf%C3%A9%20song.mp3`)
+		w.Write([]byte("not really audio"))
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title": "not a title"}`))
+	})
+	mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="../data/export.json"`)
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/report.pdf", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	resolver := NewResolver(urlresolver.New(NewTransport(http.DefaultTransport, Options{MaxBytes: 1024}), 0))
+
+	testCases := []struct {
+		path            string
+		wantTitle       string
+		wantContentType string
+	}{
+		{"/page", "HTML title", "text/html"},
+		{"/untitled", "", "text/html"},
+		{"/report.pdf", "Annual Report", "application/pdf"},
+		{"/big.pdf", "big-report.pdf", "application/pdf"}, // title is beyond MaxBytes
+		{"/image", "PNG image, 800×600", "image/png"},
+		{"/sniffed", "PNG image, 800×600", "image/png"},
+		{"/logo.svg", "SVG image", "image/svg+xml"},
+		{"/media/clip.mp4", "clip.mp4", "video/mp4"},
+		{"/media/stream", "Café song.mp3", "audio/mpeg"},
+		{"/api", "", "application/json"},
+		{"/export", "export.json", "application/json"},
+		{"/redirect", "Annual Report", "application/pdf"},
+	}
+	for _, tc := range testCases {
+		ctx, rec := resultmeta.NewContext(context.Background())
+		result, err := resolver.Resolve(ctx, srv.URL+tc.path)
+		assert.NoError(t, err, tc.path)
+		assert.Equal(t, tc.wantTitle, result.Title, tc.path)
+		assert.Equal(t, tc.wantContentType, rec.Meta().ContentType, tc.path)
+	}
+
+	t.Run("no labels without bodies", func(t *testing.T) {
+		t.Parallel()
+
+		opts := resolveopts.Default()
+		opts.FetchTitle = false
+		resolver := NewResolver(urlresolver.New(NewTransport(resolveopts.NewTransport(http.DefaultTransport), Options{}), 0))
+
+		ctx, rec := resultmeta.NewContext(resolveopts.NewContext(context.Background(), opts))
+		result, err := resolver.Resolve(ctx, srv.URL+"/report.pdf")
+		assert.NoError(t, err)
+		assert.Equal(t, "", result.Title)
+		assert.Equal(t, "application/pdf", rec.Meta().ContentType)
+	})
+
+	t.Run("transport ignores requests made directly", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{Transport: NewTransport(http.DefaultTransport, Options{})}
+		resp, err := client.Get(srv.URL + "/image")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		assert.Equal(t, pngImage, buf.Bytes())
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "a b c", truncate(" a\n b\t\tc "))
+	long := truncate(strings.Repeat("é", maxLabelLength+10))
+	assert.Equal(t, maxLabelLength, len([]rune(long)))
+	assert.True(t, strings.HasSuffix(long, "…"))
+}
@@ -0,0 +1,160 @@
+package pageinfo
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// xmpTitleRe matches the title in a PDF's XMP metadata.
+var xmpTitleRe = regexp.MustCompile(`(?s)<dc:title>.*?<rdf:li[^>]*>(.*?)</rdf:li>`)
+
+// pdfTitle extracts the title from the (possibly truncated) contents of a
+// PDF, preferring the Title entry of its document information dictionary
+// and falling back to its XMP metadata. Metadata in compressed object
+// streams cannot be found.
+func pdfTitle(buf []byte) string {
+	for rest := buf; ; {
+		i := bytes.Index(rest, []byte("/Title"))
+		if i < 0 {
+			break
+		}
+		rest = rest[i+len("/Title"):]
+		if title := strings.TrimSpace(pdfString(bytes.TrimLeft(rest, " \t\r\n"))); title != "" {
+			return title
+		}
+	}
+	if m := xmpTitleRe.FindSubmatch(buf); m != nil {
+		return strings.TrimSpace(html.UnescapeString(string(m[1])))
+	}
+	return ""
+}
+
+// pdfString decodes the PDF string object at the start of buf, which may be
+// a literal string like (Title) or a hex string like <5469746C65>.
+func pdfString(buf []byte) string {
+	if len(buf) == 0 {
+		return ""
+	}
+	switch buf[0] {
+	case '(':
+		return decodePDFText(pdfLiteral(buf[1:]))
+	case '<':
+		end := bytes.IndexByte(buf, '>')
+		if end < 0 || bytes.HasPrefix(buf, []byte("<<")) {
+			return ""
+		}
+		return decodePDFText(pdfHex(buf[1:end]))
+	default:
+		return ""
+	}
+}
+
+// pdfLiteral decodes the body of a literal string, which ends at the first
+// unbalanced, unescaped closing parenthesis.
+func pdfLiteral(buf []byte) []byte {
+	var (
+		out   []byte
+		depth int
+	)
+	for i := 0; i < len(buf); i++ {
+		c := buf[i]
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return out
+			}
+			depth--
+		case '\\':
+			i++
+			if i >= len(buf) {
+				return out
+			}
+			switch c = buf[i]; c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// an escaped line break continues the string
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				// up to three octal digits
+				n := int(c - '0')
+				for j := 0; j < 2 && i+1 < len(buf) && buf[i+1] >= '0' && buf[i+1] <= '7'; j++ {
+					i++
+					n = n*8 + int(buf[i]-'0')
+				}
+				c = byte(n)
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// pdfHex decodes the body of a hex string, ignoring whitespace. A missing
+// final digit is assumed to be 0.
+func pdfHex(buf []byte) []byte {
+	var (
+		out  []byte
+		b    byte
+		high = true
+	)
+	for _, c := range buf {
+		var v byte
+		switch {
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		if high {
+			b = v << 4
+		} else {
+			out = append(out, b|v)
+		}
+		high = !high
+	}
+	if !high {
+		out = append(out, b)
+	}
+	return out
+}
+
+// decodePDFText decodes a PDF text string, which is either UTF-16BE with a
+// byte order mark, UTF-8 with a byte order mark, or PDFDocEncoding, which
+// is treated as Latin-1.
+func decodePDFText(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		b = b[2:]
+		units := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	case bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}):
+		return string(b[3:])
+	default:
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	}
+}
@@ -0,0 +1,72 @@
+package pageinfo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+// Resolver is a urlresolver.Interface implementation that tracks the Info
+// identified by Transport for the final response of each resolve. It uses
+// the Info's label as the title of results that have none, and records the
+// content type in the context's resultmeta.Recorder.
+type Resolver struct {
+	resolver urlresolver.Interface
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(resolver urlresolver.Interface) *Resolver {
+	return &Resolver{
+		resolver: resolver,
+	}
+}
+
+// Resolve resolves a URL, falling back to a label for its title.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	holder := &infoHolder{}
+	result, err := r.resolver.Resolve(context.WithValue(ctx, infoHolderKey, holder), givenURL)
+
+	info := holder.get()
+	if info.ContentType != "" {
+		resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.ContentType = info.ContentType })
+	}
+	if result.Title == "" && info.Label != "" {
+		beeline.AddField(ctx, "pageinfo.fallback_title", true)
+		result.Title = info.Label
+	}
+	return result, err
+}
+
+// infoHolder holds the Info for the most recent final response made while
+// resolving a single URL.
+type infoHolder struct {
+	mu   sync.Mutex
+	info Info
+}
+
+func (h *infoHolder) set(info Info) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.info = info
+}
+
+func (h *infoHolder) get() Info {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.info
+}
+
+type infoHolderKeyType int
+
+const infoHolderKey = infoHolderKeyType(1)
+
+// infoHolderFrom returns the infoHolder for the URL being resolved, or nil
+// if the request is not being made on behalf of a Resolver.
+func infoHolderFrom(ctx context.Context) *infoHolder {
+	holder, _ := ctx.Value(infoHolderKey).(*infoHolder)
+	return holder
+}
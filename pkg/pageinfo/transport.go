@@ -0,0 +1,67 @@
+package pageinfo
+
+import (
+	"net/http"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolverapi/pkg/response"
+)
+
+// Transport is an http.RoundTripper that identifies the content type of
+// every final (i.e. non-redirect) response made on behalf of a Resolver,
+// extracting a label from non-HTML responses.
+//
+// HTML responses are passed through untouched, so that their titles may be
+// extracted as usual. The bodies of other responses are replaced with an
+// empty body once their labels have been extracted.
+type Transport struct {
+	transport http.RoundTripper
+	opts      Options
+}
+
+// NewTransport creates a new Transport.
+func NewTransport(transport http.RoundTripper, opts Options) *Transport {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	return &Transport{
+		transport: transport,
+		opts:      opts,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || response.IsRedirect(resp.StatusCode) {
+		return resp, err
+	}
+	ctx := req.Context()
+	holder := infoHolderFrom(ctx)
+	if holder == nil {
+		return resp, nil
+	}
+
+	// A body that was never fetched (e.g. for a HEAD request, or because
+	// the title is not wanted) can neither be sniffed nor labeled.
+	fetched := resp.Body != http.NoBody
+	info := Info{ContentType: mediaType(resp.Header.Get("Content-Type"))}
+	if fetched && (info.ContentType == "" || info.ContentType == "application/octet-stream") {
+		sniffed, body := peek(resp.Body)
+		info.ContentType = mediaType(http.DetectContentType(sniffed))
+		resp.Body = readCloser{Reader: body, Closer: resp.Body}
+		beeline.AddField(ctx, "pageinfo.sniffed", true)
+	}
+	beeline.AddField(ctx, "pageinfo.content_type", info.ContentType)
+
+	if fetched && !isHTML(info.ContentType) {
+		info.Label = extractLabel(info.ContentType, resp, resp.Body, t.opts.MaxBytes)
+		beeline.AddField(ctx, "pageinfo.label", info.Label)
+
+		// nothing else in the body is of use to the resolver
+		response.DropBody(resp)
+	}
+	holder.set(info)
+	return resp, nil
+}
@@ -0,0 +1,188 @@
+/*
+Package parampolicy implements a policy that strips tracking and other junk
+query parameters from URLs, on top of the parameters already stripped by
+urlresolver.Canonicalize.
+
+Policies are loaded from a file containing one rule per line, where each rule
+is a hostmatch pattern followed by "strip" or "keep" and one or more parameter
+names, which may be path.Match glob patterns:
+
+	# strip these everywhere
+	*              strip  fbclid gclid msclkid
+	*              strip  mc_cid mc_eid _hs*
+
+	# strip a vendor's parameters on its own sites
+	shop.example   strip  ref ref_src
+
+	# this site needs gclid to find the right page
+	news.example   keep   gclid
+
+A parameter is stripped from a URL if any rule whose pattern matches the URL's
+host strips it, unless any matching rule keeps it. Parameter names are matched
+case insensitively.
+
+Policies are applied via Resolver, which canonicalizes the given URL before
+it is resolved, coalesced or cached, and the resolved URL before it is
+returned.
+*/
+package parampolicy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/rulefile"
+)
+
+// ErrInvalidRule is returned when a policy file contains an invalid rule.
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Rule strips or keeps query parameters on matching hosts.
+type Rule struct {
+	// Pattern determines which hosts the rule applies to.
+	Pattern hostmatch.Pattern
+
+	// Strip are parameter name patterns to strip.
+	Strip []string
+
+	// Keep are parameter name patterns to keep, even if another rule would
+	// strip them.
+	Keep []string
+}
+
+// Policy determines which query parameters are stripped from URLs.
+type Policy struct {
+	file *rulefile.File[[]Rule]
+}
+
+// New creates a new Policy from the given rules.
+func New(rules ...Rule) *Policy {
+	return &Policy{
+		file: rulefile.Static(rules),
+	}
+}
+
+// Load creates a new Policy from the rules in the file at path. The policy
+// may be reloaded later via Reload.
+func Load(path string) (*Policy, error) {
+	file, err := rulefile.Load(path, parseRules)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{file: file}, nil
+}
+
+// Reload re-reads the policy from the file it was loaded from. If the file
+// cannot be read or parsed, the existing policy is kept.
+func (p *Policy) Reload() error {
+	return p.file.Reload()
+}
+
+// Canonicalize canonicalizes a URL via urlresolver.Canonicalize, and then
+// strips any query parameters the policy strips.
+func (p *Policy) Canonicalize(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	canonical, err := url.Parse(urlresolver.Canonicalize(parsed))
+	if err != nil {
+		return "", err
+	}
+	stripped, _ := p.Strip(canonical)
+	return stripped.String(), nil
+}
+
+// Strip returns a copy of the given URL without any query parameters the
+// policy strips, along with the names of the stripped parameters.
+func (p *Policy) Strip(u *url.URL) (*url.URL, []string) {
+	stripped := *u
+	if u.RawQuery == "" {
+		return &stripped, nil
+	}
+
+	strip, keep := p.find(u.Hostname())
+	if len(strip) == 0 {
+		return &stripped, nil
+	}
+
+	var names []string
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if matchAny(strip, lower) && !matchAny(keep, lower) {
+			names = append(names, name)
+			query.Del(name)
+		}
+	}
+	if len(names) > 0 {
+		stripped.RawQuery = query.Encode()
+	}
+	return &stripped, names
+}
+
+// find returns the strip and keep patterns of every rule matching the given
+// host.
+func (p *Policy) find(host string) ([]string, []string) {
+	var strip, keep []string
+	for _, rule := range p.file.Rules() {
+		if rule.Pattern.Match(host) {
+			strip = append(strip, rule.Strip...)
+			keep = append(keep, rule.Keep...)
+		}
+	}
+	return strip, keep
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	err := rulefile.Scan(r, func(l rulefile.Line) error {
+		if len(l.Fields) < 3 {
+			return fmt.Errorf("%w: rule must be in \"pattern strip|keep param...\" format", ErrInvalidRule)
+		}
+
+		pattern, err := hostmatch.Parse(l.Fields[0])
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRule, err)
+		}
+		params := make([]string, 0, len(l.Fields)-2)
+		for _, param := range l.Fields[2:] {
+			param = strings.ToLower(param)
+			if _, err := path.Match(param, ""); err != nil {
+				return fmt.Errorf("%w: invalid parameter pattern %q: %w", ErrInvalidRule, param, err)
+			}
+			params = append(params, param)
+		}
+
+		rule := Rule{Pattern: pattern}
+		switch strings.ToLower(l.Fields[1]) {
+		case "strip":
+			rule.Strip = params
+		case "keep":
+			rule.Keep = params
+		default:
+			return fmt.Errorf("%w: unknown action %q", ErrInvalidRule, l.Fields[1])
+		}
+		rules = append(rules, rule)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,235 @@
+//nolint:errcheck
+package parampolicy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolvers/cached"
+	"github.com/mccutchen/urlresolverapi/pkg/resolvers/coalesced"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+const testPolicy = `
+# comments and blank lines are ignored
+
+*              strip  fbclid gclid
+*              strip  mc_cid mc_eid _hs*
+shop.example   strip  ref REF_SRC
+news.example   keep   gclid
+`
+
+func writePolicy(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "params.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	policy, err := Load(writePolicy(t, testPolicy))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := map[string]struct {
+		given string
+		want  string
+	}{
+		"global params stripped": {
+			given: "https://example.com/a?id=1&fbclid=abc&mc_eid=def",
+			want:  "https://example.com/a?id=1",
+		},
+		"glob params stripped": {
+			given: "https://example.com/a?_hsenc=abc&_hsmi=def&hs=1",
+			want:  "https://example.com/a?hs=1",
+		},
+		"names matched case insensitively": {
+			given: "https://example.com/a?FBCLID=abc",
+			want:  "https://example.com/a",
+		},
+		"per-domain params stripped": {
+			given: "https://www.shop.example/item?ref=x&ref_src=y&size=m",
+			want:  "https://www.shop.example/item?size=m",
+		},
+		"per-domain params only stripped on matching hosts": {
+			given: "https://example.com/item?ref=x",
+			want:  "https://example.com/item?ref=x",
+		},
+		"kept params not stripped": {
+			given: "https://news.example/a?gclid=abc&fbclid=def",
+			want:  "https://news.example/a?gclid=abc",
+		},
+		"library canonicalization applied first": {
+			given: "https://example.com/a?utm_source=x&fbclid=abc",
+			want:  "https://example.com/a",
+		},
+		"no query": {
+			given: "https://example.com/a",
+			want:  "https://example.com/a",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := policy.Canonicalize(tc.given)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err = policy.Canonicalize("http://[::1")
+	assert.Error(t, err)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"missing params":    "* strip",
+		"unknown action":    "* drop fbclid",
+		"invalid pattern":   "[example.com strip fbclid",
+		"invalid parameter": "* strip fb[clid",
+	}
+	for name, policy := range testCases {
+		policy := policy
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Load(writePolicy(t, policy))
+			assert.ErrorIs(t, err, ErrInvalidRule)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	path := writePolicy(t, "* strip fbclid\n")
+	policy, err := Load(path)
+	assert.NoError(t, err)
+
+	given := "https://example.com/?mc_eid=abc"
+	got, _ := policy.Canonicalize(given)
+	assert.Equal(t, given, got)
+
+	os.WriteFile(path, []byte("* strip fbclid mc_eid\n"), 0o600)
+	assert.NoError(t, policy.Reload())
+	got, _ = policy.Canonicalize(given)
+	assert.Equal(t, "https://example.com/", got)
+
+	// invalid policies are not applied
+	os.WriteFile(path, []byte("* strip\n"), 0o600)
+	assert.Error(t, policy.Reload())
+	got, _ = policy.Canonicalize(given)
+	assert.Equal(t, "https://example.com/", got)
+}
+
+// countingResolver resolves every URL to a fixed URL with tracking params,
+// counting the URLs it is asked to resolve.
+type countingResolver struct {
+	mu    sync.Mutex
+	given []string
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.given = append(r.given, givenURL)
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) {
+		m.OriginalResolvedURL = "https://amp.example.com/article?fbclid=xyz"
+	})
+	return urlresolver.Result{ResolvedURL: "https://example.com/article?fbclid=xyz&id=1"}, nil
+}
+
+type mapCache struct {
+	mu   sync.Mutex
+	data map[string]urlresolver.Result
+}
+
+func (c *mapCache) Add(_ context.Context, key string, value urlresolver.Result) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = value
+	return nil
+}
+
+func (c *mapCache) Get(_ context.Context, key string) (urlresolver.Result, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.data[key]
+	return value, ok, nil
+}
+
+func (c *mapCache) Name() string {
+	return "map"
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	policy, err := Load(writePolicy(t, testPolicy))
+	if !assert.NoError(t, err) {
+		return
+	}
+	inner := &countingResolver{}
+	resultCache := &mapCache{data: make(map[string]urlresolver.Result)}
+	resolver := NewResolver(policy, coalesced.New(cached.NewResolver(inner, resultCache)))
+
+	for _, given := range []string{
+		"https://t.co/abc?fbclid=1",
+		"https://t.co/abc?fbclid=2&utm_source=x",
+		"https://t.co/abc",
+	} {
+		ctx, rec := resultmeta.NewContext(context.Background())
+		result, err := resolver.Resolve(ctx, given)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/article?id=1", result.ResolvedURL, given)
+		if rec.Meta().CacheResult == resultmeta.CacheMiss {
+			assert.Equal(t, "https://amp.example.com/article", rec.Meta().OriginalResolvedURL, given)
+		}
+	}
+
+	// every variant shares the same cache entry
+	assert.Equal(t, []string{"https://t.co/abc"}, inner.given)
+	assert.Len(t, resultCache.data, 1)
+
+	t.Run("invalid url", func(t *testing.T) {
+		t.Parallel()
+		_, err := resolver.Resolve(context.Background(), "http://[::1")
+		assert.Error(t, err)
+	})
+
+	t.Run("original url cleared if only params differ", func(t *testing.T) {
+		t.Parallel()
+		resolver := NewResolver(policy, resolverFunc(func(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+			resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) {
+				m.OriginalResolvedURL = "https://example.com/?fbclid=abc"
+			})
+			return urlresolver.Result{ResolvedURL: "https://example.com/"}, nil
+		}))
+		ctx, rec := resultmeta.NewContext(context.Background())
+		_, err := resolver.Resolve(ctx, "https://example.com/")
+		assert.NoError(t, err)
+		assert.Equal(t, "", rec.Meta().OriginalResolvedURL)
+	})
+}
+
+type resolverFunc func(ctx context.Context, givenURL string) (urlresolver.Result, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	return f(ctx, givenURL)
+}
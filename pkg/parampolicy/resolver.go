@@ -0,0 +1,72 @@
+package parampolicy
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+// Resolver is a urlresolver.Interface implementation that applies a Policy to
+// the given URL before resolving it, so that the wrapped resolver (and any
+// coalescing or caching it does) only ever sees canonicalized URLs. The
+// policy is also applied to the resolved URL of every result, including
+// cached results resolved before the policy changed.
+type Resolver struct {
+	policy   *Policy
+	resolver urlresolver.Interface
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(policy *Policy, resolver urlresolver.Interface) *Resolver {
+	return &Resolver{
+		policy:   policy,
+		resolver: resolver,
+	}
+}
+
+// Resolve canonicalizes and resolves a URL.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	canonicalURL, err := r.policy.Canonicalize(givenURL)
+	if err != nil {
+		return urlresolver.Result{}, err
+	}
+
+	result, err := r.resolver.Resolve(ctx, canonicalURL)
+	if result.ResolvedURL != "" {
+		var stripped []string
+		result.ResolvedURL, stripped = r.strip(result.ResolvedURL)
+		if len(stripped) > 0 {
+			sort.Strings(stripped)
+			beeline.AddField(ctx, "param_policy.stripped", strings.Join(stripped, ","))
+		}
+	}
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) {
+		if m.OriginalResolvedURL == "" {
+			return
+		}
+		// the original URL may differ only by stripped parameters
+		if m.OriginalResolvedURL, _ = r.strip(m.OriginalResolvedURL); m.OriginalResolvedURL == result.ResolvedURL {
+			m.OriginalResolvedURL = ""
+		}
+	})
+	return result, err
+}
+
+// strip strips parameters from an already canonicalized URL.
+func (r *Resolver) strip(rawURL string) (string, []string) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL, nil
+	}
+	stripped, names := r.policy.Strip(parsed)
+	if len(names) == 0 {
+		return rawURL, nil
+	}
+	return stripped.String(), names
+}
@@ -0,0 +1,187 @@
+/*
+Package pinning guarantees that outgoing connections are only made to IP
+addresses that have been validated, closing the window in which a DNS
+rebinding attack could swap in a different address between validation and
+connection.
+
+A hostname is resolved exactly once per connection, and every address it
+resolves to is validated by a control function (typically
+safedialer.Control). If any address is unsafe, the connection is refused,
+even if other addresses are safe, because a host that resolves to a mix of
+safe and unsafe addresses is most likely an attempt to evade validation.
+
+The validated addresses are then dialed as IP literals, so no further DNS
+lookups can occur, and the dialer verifies at the socket level that it is
+connecting to one of the validated addresses:
+
+	dialer := pinning.NewDialer(net.DefaultResolver, safedialer.Control)
+	transport := &http.Transport{DialContext: dialer.DialContext}
+
+Callers that connect via some other mechanism (e.g. through a proxy) should
+use Resolve to obtain validated addresses, and must connect only to those
+addresses.
+*/
+package pinning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/honeycombio/beeline-go"
+)
+
+// ErrUnpinnedAddress is returned if a connection is attempted to an
+// address that was not validated. It indicates a bug rather than an attack.
+var ErrUnpinnedAddress = errors.New("connection to unvalidated address")
+
+// Control validates a network address before it is connected to, with the
+// same semantics as net.Dialer.Control.
+type Control func(network, address string, c syscall.RawConn) error
+
+// Resolver looks up the IP addresses of a host.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// UnsafeAddressError is returned when a host resolves to an address that
+// fails validation.
+type UnsafeAddressError struct {
+	Host string
+	Addr string
+	Err  error
+}
+
+func (e *UnsafeAddressError) Error() string {
+	return fmt.Sprintf("%s resolves to unsafe address %s: %s", e.Host, e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying validation error.
+func (e *UnsafeAddressError) Unwrap() error {
+	return e.Err
+}
+
+// Resolve resolves the host in the given host:port address and validates
+// every address it resolves to, returning them in host:port form. If any
+// address fails validation, an *UnsafeAddressError is returned.
+func Resolve(ctx context.Context, resolver Resolver, control Control, network string, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	targets := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		ipNetwork := ipNetwork(ip)
+		target := net.JoinHostPort(ip.String(), port)
+		if control != nil {
+			if err := control(ipNetwork, target, nil); err != nil {
+				beeline.AddField(ctx, "pinning.unsafe_host", host)
+				beeline.AddField(ctx, "pinning.unsafe_addr", target)
+				return nil, &UnsafeAddressError{Host: host, Addr: target, Err: err}
+			}
+		}
+		if matchesNetwork(network, ipNetwork) {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	return targets, nil
+}
+
+// Dialer makes connections only to validated addresses.
+type Dialer struct {
+	resolver Resolver
+	control  Control
+}
+
+// NewDialer creates a new Dialer that resolves hosts with the given
+// resolver and validates their addresses with the given control function.
+func NewDialer(resolver Resolver, control Control) *Dialer {
+	return &Dialer{
+		resolver: resolver,
+		control:  control,
+	}
+}
+
+// DialContext connects to the given address, trying each of its validated
+// IP addresses in turn until one succeeds. It may be used as an
+// http.Transport's DialContext.
+func (d *Dialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	targets, err := Resolve(ctx, d.resolver, d.control, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialErr error
+	for _, target := range targets {
+		dialer := &net.Dialer{Control: d.pin(target)}
+		conn, err := dialer.DialContext(ctx, network, target)
+		if err == nil {
+			return conn, nil
+		}
+		dialErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, dialErr
+}
+
+// pin returns a control function that refuses to connect to any address
+// other than the given validated target, which is validated again at the
+// socket level.
+func (d *Dialer) pin(target string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if !sameAddr(address, target) {
+			return fmt.Errorf("%w: %s (expected %s)", ErrUnpinnedAddress, address, target)
+		}
+		if d.control != nil {
+			return d.control(network, address, c)
+		}
+		return nil
+	}
+}
+
+func sameAddr(a, b string) bool {
+	aHost, aPort, aErr := net.SplitHostPort(a)
+	bHost, bPort, bErr := net.SplitHostPort(b)
+	if aErr != nil || bErr != nil || aPort != bPort {
+		return false
+	}
+	aIP, bIP := net.ParseIP(aHost), net.ParseIP(bHost)
+	return aIP != nil && aIP.Equal(bIP)
+}
+
+func ipNetwork(ip net.IP) string {
+	if ip.To4() != nil {
+		return "tcp4"
+	}
+	return "tcp6"
+}
+
+func matchesNetwork(network string, ipNetwork string) bool {
+	switch network {
+	case "tcp4", "tcp6":
+		return network == ipNetwork
+	default:
+		return true
+	}
+}
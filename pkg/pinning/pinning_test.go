@@ -0,0 +1,227 @@
+//nolint:errcheck
+package pinning
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/safedialer"
+	"github.com/mccutchen/urlresolver"
+)
+
+// stubResolver resolves hostnames to IP addresses that may be changed at
+// any time, to simulate DNS rebinding.
+type stubResolver struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+}
+
+func (r *stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ips, found := r.hosts[host]
+	if !found {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func (r *stubResolver) set(host string, ips ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = ips
+}
+
+// recordingControl treats only 127.0.0.1 as safe, standing in for
+// safedialer.Control (which would reject every address used in tests), and
+// records every address it checks.
+type recordingControl struct {
+	mu      sync.Mutex
+	checked []string
+}
+
+func (c *recordingControl) control(_ string, address string, _ syscall.RawConn) error {
+	c.mu.Lock()
+	c.checked = append(c.checked, address)
+	c.mu.Unlock()
+	if host, _, _ := net.SplitHostPort(address); host != "127.0.0.1" {
+		return safedialer.ErrUnsafeIP
+	}
+	return nil
+}
+
+func (c *recordingControl) reset() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	checked := c.checked
+	c.checked = nil
+	return checked
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	resolver := &stubResolver{hosts: map[string][]string{
+		"safe.test":   {"127.0.0.1"},
+		"mixed.test":  {"127.0.0.1", "10.0.0.1"},
+		"unsafe.test": {"10.0.0.1"},
+		"v6.test":     {"::1"},
+	}}
+	rc := &recordingControl{}
+
+	targets, err := Resolve(context.Background(), resolver, rc.control, "tcp", "safe.test:80")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:80"}, targets)
+
+	targets, err = Resolve(context.Background(), resolver, rc.control, "tcp", "127.0.0.1:443")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:443"}, targets)
+
+	// a single unsafe address taints the whole host
+	for _, addr := range []string{"mixed.test:80", "unsafe.test:80", "10.0.0.1:80"} {
+		_, err = Resolve(context.Background(), resolver, rc.control, "tcp", addr)
+		var unsafeErr *UnsafeAddressError
+		if assert.True(t, errors.As(err, &unsafeErr), "%s: expected UnsafeAddressError, got %v", addr, err) {
+			assert.Equal(t, "10.0.0.1:80", unsafeErr.Addr)
+		}
+		assert.ErrorIs(t, err, safedialer.ErrUnsafeIP)
+	}
+
+	// addresses that don't match the network are skipped, but still
+	// validated
+	_, err = Resolve(context.Background(), resolver, rc.control, "tcp6", "safe.test:80")
+	assert.Error(t, err)
+	_, err = Resolve(context.Background(), resolver, rc.control, "tcp4", "v6.test:80")
+	assert.ErrorIs(t, err, safedialer.ErrUnsafeIP)
+
+	_, err = Resolve(context.Background(), resolver, rc.control, "tcp", "missing.test:80")
+	var dnsErr *net.DNSError
+	assert.True(t, errors.As(err, &dnsErr) && dnsErr.IsNotFound)
+}
+
+func TestPin(t *testing.T) {
+	t.Parallel()
+
+	rc := &recordingControl{}
+	d := NewDialer(&stubResolver{}, rc.control)
+
+	assert.NoError(t, d.pin("127.0.0.1:80")("tcp4", "127.0.0.1:80", nil))
+	assert.ErrorIs(t, d.pin("127.0.0.1:80")("tcp4", "127.0.0.2:80", nil), ErrUnpinnedAddress)
+	assert.ErrorIs(t, d.pin("127.0.0.1:80")("tcp4", "127.0.0.1:443", nil), ErrUnpinnedAddress)
+
+	// the pinned address is validated again at the socket level
+	assert.ErrorIs(t, d.pin("10.0.0.1:80")("tcp4", "10.0.0.1:80", nil), safedialer.ErrUnsafeIP)
+}
+
+func TestDialerRebinding(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu          sync.Mutex
+		remoteAddrs []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remoteAddrs = append(remoteAddrs, r.RemoteAddr)
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	targetURL := "http://rebind.test:" + port + "/"
+
+	resolver := &stubResolver{hosts: map[string][]string{"rebind.test": {"127.0.0.1"}}}
+	rc := &recordingControl{}
+	transport := &http.Transport{DialContext: NewDialer(resolver, rc.control).DialContext}
+	client := &http.Client{Transport: transport}
+
+	get := func() error {
+		resp, err := client.Get(targetURL)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil
+	}
+
+	// the first connection is validated at resolution time and again when
+	// connecting, against the same address
+	assert.NoError(t, get())
+	assert.Equal(t, []string{"127.0.0.1:" + port, "127.0.0.1:" + port}, rc.reset())
+
+	// the host is rebound to an unsafe address, but the existing connection
+	// to the validated address is reused without another lookup
+	resolver.set("rebind.test", "10.0.0.1")
+	assert.NoError(t, get())
+	assert.Empty(t, rc.reset())
+	mu.Lock()
+	assert.Len(t, remoteAddrs, 2)
+	assert.Equal(t, remoteAddrs[0], remoteAddrs[1], "expected connection to be reused")
+	mu.Unlock()
+
+	// any new connection must be made to a validated address
+	transport.CloseIdleConnections()
+	err := get()
+	assert.ErrorIs(t, err, safedialer.ErrUnsafeIP)
+	var unsafeErr *UnsafeAddressError
+	assert.True(t, errors.As(err, &unsafeErr))
+	assert.Equal(t, []string{"10.0.0.1:" + port}, rc.reset())
+
+	// rebinding to a mix of safe and unsafe addresses is also refused
+	resolver.set("rebind.test", "127.0.0.1", "10.0.0.1")
+	assert.ErrorIs(t, get(), safedialer.ErrUnsafeIP)
+}
+
+func TestDialerRedirects(t *testing.T) {
+	t.Parallel()
+
+	var port string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host {
+		case "start.test:" + port:
+			http.Redirect(w, r, "http://middle.test:"+port+"/", http.StatusFound)
+		case "middle.test:" + port:
+			http.Redirect(w, r, "http://end.test:"+port+"/", http.StatusFound)
+		default:
+			w.Write([]byte("<title>end</title>"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	_, port, _ = net.SplitHostPort(srv.Listener.Addr().String())
+
+	resolver := &stubResolver{hosts: map[string][]string{
+		"start.test":  {"127.0.0.1"},
+		"middle.test": {"127.0.0.1"},
+		"end.test":    {"127.0.0.1"},
+	}}
+	rc := &recordingControl{}
+	newResolver := func() urlresolver.Interface {
+		return urlresolver.New(&http.Transport{DialContext: NewDialer(resolver, rc.control).DialContext}, 0)
+	}
+
+	// every hop's connection goes to a validated address
+	result, err := newResolver().Resolve(context.Background(), "http://start.test:"+port+"/")
+	assert.NoError(t, err)
+	assert.Equal(t, "end", result.Title)
+	assert.Len(t, result.IntermediateURLs, 2)
+	assert.Equal(t, 0, len(rc.reset())%2, "expected each address to be checked at resolution and connection time")
+
+	// an unsafe address on any hop fails the whole resolution
+	resolver.set("end.test", "127.0.0.1", "10.0.0.1")
+	_, err = newResolver().Resolve(context.Background(), "http://start.test:"+port+"/")
+	assert.ErrorIs(t, err, safedialer.ErrUnsafeIP)
+}
@@ -0,0 +1,96 @@
+/*
+Package problem implements RFC 7807 problem details, the format used for
+every error response from the HTTP API:
+
+	HTTP/1.1 400 Bad Request
+	Content-Type: application/problem+json
+
+	{
+	  "type": "about:blank",
+	  "title": "Bad Request",
+	  "status": 400,
+	  "detail": "Missing arg url",
+	  "code": "missing_url",
+	  "request_id": "5f0c9b1e6a3d4e2f8b7c6d5e4f3a2b1c"
+	}
+
+The code field is a stable, machine-readable identifier for the problem which
+clients should rely on instead of the human-readable detail.
+*/
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Stable problem codes.
+const (
+	CodeCallbacksDisabled  = "callbacks_disabled"
+	CodeInternalError      = "internal_error"
+	CodeInvalidCallbackURL = "invalid_callback_url"
+	CodeInvalidMode        = "invalid_mode"
+	CodeInvalidOption      = "invalid_option"
+	CodeInvalidRequest     = "invalid_request"
+	CodeInvalidURL         = "invalid_url"
+	CodeMissingURL         = "missing_url"
+	CodeNotAcceptable      = "not_acceptable"
+	CodeNotFound           = "not_found"
+	CodeRateLimited        = "rate_limited"
+	CodeTooManyURLs        = "too_many_urls"
+	CodeUnauthorized       = "unauthorized"
+	CodeUnavailable        = "unavailable"
+)
+
+// Stable codes for errors encountered while resolving a URL, which are
+// reported alongside partial results rather than as problems.
+const (
+	CodeBlockedURL      = "blocked_url"
+	CodeResolveError    = "resolve_error"
+	CodeUnsafeURL       = "unsafe_url"
+	CodeUpstreamTimeout = "upstream_timeout"
+)
+
+// Problem is an RFC 7807 problem details object, extended with a stable code
+// and the ID of the request that failed.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// New creates a new Problem, including the request ID carried by the given
+// context, if any.
+func New(ctx context.Context, status int, code string, detail string) Problem {
+	return Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: requestid.FromContext(ctx),
+	}
+}
+
+// Send writes a new Problem as the response to the given request.
+func Send(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	Write(w, New(r.Context(), status, code, detail))
+}
+
+// Write writes the given Problem as a response.
+func Write(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
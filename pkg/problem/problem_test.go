@@ -0,0 +1,36 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolverapi/pkg/requestid"
+)
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(requestid.NewContext(context.Background(), "request-id"))
+	w := httptest.NewRecorder()
+	Send(w, r, http.StatusBadRequest, CodeMissingURL, "Missing arg url")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+
+	var got Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Bad Request",
+		Status:    http.StatusBadRequest,
+		Detail:    "Missing arg url",
+		Code:      CodeMissingURL,
+		RequestID: "request-id",
+	}, got)
+}
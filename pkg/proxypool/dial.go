@@ -0,0 +1,123 @@
+package proxypool
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/honeycombio/beeline-go"
+	xproxy "golang.org/x/net/proxy"
+
+	"github.com/mccutchen/urlresolverapi/pkg/pinning"
+)
+
+// DialContext connects to the given address through a proxy chosen from
+// the pool. It may be used as an http.Transport's DialContext.
+func (p *Pool) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	// the proxy is only ever asked to connect to a validated IP address
+	targets, err := pinning.Resolve(ctx, p.opts.Resolver, p.opts.Control, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	target := targets[0]
+
+	host, _, _ := net.SplitHostPort(addr)
+	px := p.choose(host)
+	beeline.AddField(ctx, "proxy.proxy", px.String())
+
+	var conn net.Conn
+	switch px.url.Scheme {
+	case "socks5":
+		conn, err = dialSOCKS5(ctx, px, target)
+	default:
+		conn, err = dialConnect(ctx, px, target)
+	}
+	if err != nil {
+		// the caller giving up says nothing about the proxy's health
+		if ctx.Err() == nil {
+			p.markFailure(px)
+		}
+		beeline.AddField(ctx, "proxy.error", err.Error())
+		return nil, err
+	}
+	p.markSuccess(px)
+	return conn, nil
+}
+
+// dialConnect establishes a tunnel to target through an HTTP proxy.
+func dialConnect(ctx context.Context, px *proxy, target string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", px.url.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure that a hung proxy cannot outlast the caller's deadline
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if u := px.url.User; u != nil {
+		password, _ := u.Password()
+		req.SetBasicAuth(u.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s: %s", ErrProxyConnectFailed, px, resp.Status)
+	}
+	if !stop() {
+		// the context was canceled and the connection closed
+		return nil, ctx.Err()
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// dialSOCKS5 establishes a connection to target through a SOCKS5 proxy.
+func dialSOCKS5(ctx context.Context, px *proxy, target string) (net.Conn, error) {
+	var auth *xproxy.Auth
+	if u := px.url.User; u != nil {
+		password, _ := u.Password()
+		auth = &xproxy.Auth{User: u.Username(), Password: password}
+	}
+	dialer, err := xproxy.SOCKS5("tcp", px.url.Host, auth, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(xproxy.ContextDialer).DialContext(ctx, "tcp", target)
+}
+
+// bufferedConn is a net.Conn whose reads are served from a buffered reader
+// that may already hold data read from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
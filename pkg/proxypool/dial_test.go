@@ -0,0 +1,224 @@
+//nolint:errcheck
+package proxypool
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errUnsafe = errors.New("unsafe address")
+
+// allowPort only allows connections to the given port, standing in for
+// safedialer.Control, which would reject every address used in tests.
+func allowPort(port string) func(string, string, syscall.RawConn) error {
+	return func(_ string, address string, _ syscall.RawConn) error {
+		if _, p, _ := net.SplitHostPort(address); p != port {
+			return errUnsafe
+		}
+		return nil
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	t.Parallel()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from " + r.Host))
+	}))
+	t.Cleanup(backend.Close)
+	_, backendPort, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	backendURL := "http://localhost:" + backendPort + "/"
+
+	var (
+		mu             sync.Mutex
+		connectTargets []string
+	)
+	connectProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			http.Error(w, "auth required", http.StatusProxyAuthRequired)
+			return
+		}
+		mu.Lock()
+		connectTargets = append(connectTargets, r.Host)
+		mu.Unlock()
+		tunnel(w, r.Host)
+	}))
+	t.Cleanup(connectProxy.Close)
+
+	socksProxy := newSOCKS5Server(t)
+
+	testCases := map[string]struct {
+		proxy   string
+		control func(string, string, syscall.RawConn) error
+		wantErr error
+	}{
+		"http CONNECT proxy": {
+			proxy:   "http://user:pass@" + connectProxy.Listener.Addr().String(),
+			control: allowPort(backendPort),
+		},
+		"http CONNECT proxy auth failure": {
+			proxy:   "http://" + connectProxy.Listener.Addr().String(),
+			control: allowPort(backendPort),
+			wantErr: ErrProxyConnectFailed,
+		},
+		"socks5 proxy": {
+			proxy:   "socks5://" + socksProxy,
+			control: allowPort(backendPort),
+		},
+		"unsafe destination is never sent to proxy": {
+			proxy:   "http://user:pass@" + connectProxy.Listener.Addr().String(),
+			control: allowPort("443"),
+			wantErr: errUnsafe,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			pool, err := New([]string{tc.proxy}, Options{Control: tc.control})
+			if !assert.NoError(t, err) {
+				return
+			}
+			client := &http.Client{Transport: &http.Transport{DialContext: pool.DialContext}}
+			resp, err := client.Get(backendURL)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, "hello from localhost:"+backendPort, string(body))
+		})
+	}
+
+	// hostnames are resolved before connecting, and the proxy is only asked
+	// to connect to the validated IP address
+	mu.Lock()
+	defer mu.Unlock()
+	for _, target := range connectTargets {
+		host, _, _ := net.SplitHostPort(target)
+		assert.NotNil(t, net.ParseIP(host), "expected CONNECT to IP address, got %q", target)
+	}
+	assert.NotEmpty(t, connectTargets)
+}
+
+func TestDialContextFailures(t *testing.T) {
+	t.Parallel()
+
+	// a proxy that refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadProxy := "http://" + l.Addr().String()
+	l.Close()
+
+	pool, err := New([]string{deadProxy}, Options{FailureThreshold: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		_, err = pool.DialContext(context.Background(), "tcp", "127.0.0.1:80")
+		assert.Error(t, err)
+	}
+	assert.False(t, pool.Stats()[0].Healthy)
+
+	// canceled dials do not count against the proxy
+	pool, _ = New([]string{deadProxy}, Options{FailureThreshold: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.DialContext(ctx, "tcp", "127.0.0.1:80")
+	assert.Error(t, err)
+	assert.True(t, pool.Stats()[0].Healthy)
+}
+
+// tunnel hijacks a CONNECT request's connection and connects it to target.
+func tunnel(w http.ResponseWriter, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	pipe(conn, upstream)
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	go func() {
+		io.Copy(b, a)
+		b.Close()
+	}()
+}
+
+// newSOCKS5Server starts a minimal SOCKS5 server supporting only
+// unauthenticated CONNECT requests to IPv4 addresses, returning its address.
+func newSOCKS5Server(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// greeting: version, nmethods, methods
+				buf := make([]byte, 262)
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					conn.Close()
+					return
+				}
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 0})
+
+				// request: version, cmd, reserved, atyp, addr, port
+				if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[3] != 1 {
+					conn.Close()
+					return
+				}
+				io.ReadFull(conn, buf[:6])
+				target := net.JoinHostPort(net.IP(buf[:4]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(buf[4:6]))))
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				pipe(conn, upstream)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
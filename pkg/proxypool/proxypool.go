@@ -0,0 +1,304 @@
+/*
+Package proxypool routes outgoing connections through a pool of HTTP CONNECT
+or SOCKS5 egress proxies, so that upstream sites see a variety of source IPs.
+
+A proxy is chosen for each connection according to a Strategy, and proxies
+are marked unhealthy for a cooldown period after consecutive failures:
+
+	pool, err := proxypool.New([]string{"http://proxy1:3128", "socks5://proxy2:1080"}, proxypool.Options{
+		Strategy: proxypool.StrategySticky,
+		Control:  safedialer.Control,
+	})
+	transport := &http.Transport{DialContext: pool.DialContext}
+
+Destination hostnames are resolved locally, and every resolved IP address is
+validated via Options.Control (see pinning.Resolve) before the proxy is asked
+to connect to one of those IP addresses. The proxy never resolves hostnames
+itself, so the SSRF protections provided by safedialer are preserved.
+*/
+package proxypool
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mccutchen/urlresolverapi/pkg/pinning"
+)
+
+// Strategy determines how a proxy is chosen for each connection.
+type Strategy string
+
+// Supported strategies.
+const (
+	// StrategyRoundRobin cycles through healthy proxies in order.
+	StrategyRoundRobin Strategy = "round-robin"
+
+	// StrategySticky consistently uses the same proxy for a given
+	// destination host, as long as that proxy is healthy.
+	StrategySticky Strategy = "sticky"
+
+	// StrategyWeighted chooses proxies at random, weighted by their recent
+	// success rate.
+	StrategyWeighted Strategy = "weighted"
+)
+
+// Defaults for Options.
+const (
+	DefaultFailureThreshold = 3
+	DefaultCooldown         = 30 * time.Second
+)
+
+// Errors returned when creating a pool or dialing through it.
+var (
+	ErrNoProxies          = errors.New("no proxies configured")
+	ErrInvalidProxy       = errors.New("invalid proxy URL")
+	ErrInvalidStrategy    = errors.New("invalid proxy selection strategy")
+	ErrProxyConnectFailed = errors.New("proxy CONNECT failed")
+)
+
+// Options configure a Pool.
+type Options struct {
+	// Strategy determines how proxies are chosen. Defaults to
+	// StrategyRoundRobin.
+	Strategy Strategy
+
+	// FailureThreshold is the number of consecutive failures after which a
+	// proxy is marked unhealthy.
+	FailureThreshold int
+
+	// Cooldown is how long an unhealthy proxy is avoided before it is tried
+	// again.
+	Cooldown time.Duration
+
+	// Control validates each destination address before it is dialed
+	// through a proxy, with the same semantics as net.Dialer.Control
+	// (though it is always given a nil RawConn).
+	Control func(network, address string, c syscall.RawConn) error
+
+	// Resolver looks up destination hostnames. Defaults to
+	// net.DefaultResolver.
+	Resolver pinning.Resolver
+}
+
+// ParseStrategy parses a Strategy name.
+func ParseStrategy(s string) (Strategy, error) {
+	switch strategy := Strategy(strings.ToLower(strings.TrimSpace(s))); strategy {
+	case StrategyRoundRobin, StrategySticky, StrategyWeighted:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidStrategy, s)
+	}
+}
+
+// Pool is a pool of egress proxies.
+type Pool struct {
+	proxies []*proxy
+	opts    Options
+	next    atomic.Uint64
+	now     func() time.Time
+}
+
+// New creates a new Pool from the given proxy URLs, which must use the
+// http or socks5 scheme.
+func New(proxyURLs []string, opts Options) (*Pool, error) {
+	if opts.Strategy == "" {
+		opts.Strategy = StrategyRoundRobin
+	}
+	if _, err := ParseStrategy(string(opts.Strategy)); err != nil {
+		return nil, err
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultFailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCooldown
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+
+	p := &Pool{opts: opts, now: time.Now}
+	for _, raw := range proxyURLs {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		px, err := newProxy(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, px)
+	}
+	if len(p.proxies) == 0 {
+		return nil, ErrNoProxies
+	}
+	return p, nil
+}
+
+// Stats describes the state of a single proxy in the pool.
+type Stats struct {
+	Proxy    string  `json:"proxy"`
+	Healthy  bool    `json:"healthy"`
+	Failures int     `json:"consecutive_failures"`
+	Weight   float64 `json:"weight"`
+}
+
+// Stats returns the current state of every proxy in the pool.
+func (p *Pool) Stats() []Stats {
+	now := p.now()
+	stats := make([]Stats, 0, len(p.proxies))
+	for _, px := range p.proxies {
+		px.mu.Lock()
+		stats = append(stats, Stats{
+			Proxy:    px.String(),
+			Healthy:  px.healthyAt(now),
+			Failures: px.failures,
+			Weight:   px.weight,
+		})
+		px.mu.Unlock()
+	}
+	return stats
+}
+
+// choose selects a proxy for a connection to the given host. If no proxy is
+// healthy, every proxy is considered, so that a pool-wide outage does not
+// outlast the underlying problem by a cooldown period.
+func (p *Pool) choose(host string) *proxy {
+	now := p.now()
+	candidates := make([]*proxy, 0, len(p.proxies))
+	for _, px := range p.proxies {
+		if px.healthy(now) {
+			candidates = append(candidates, px)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.proxies
+	}
+
+	switch p.opts.Strategy {
+	case StrategySticky:
+		return chooseSticky(candidates, host)
+	case StrategyWeighted:
+		return chooseWeighted(candidates)
+	default:
+		return candidates[p.next.Add(1)%uint64(len(candidates))]
+	}
+}
+
+// chooseSticky uses rendezvous hashing, so that when a proxy becomes
+// unhealthy only the hosts assigned to it move to other proxies.
+func chooseSticky(candidates []*proxy, host string) *proxy {
+	var (
+		best      *proxy
+		bestScore uint64
+	)
+	for _, px := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(host))
+		h.Write([]byte{0})
+		h.Write([]byte(px.url.Host))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = px, score
+		}
+	}
+	return best
+}
+
+func chooseWeighted(candidates []*proxy) *proxy {
+	weights := make([]float64, len(candidates))
+	var total float64
+	for i, px := range candidates {
+		px.mu.Lock()
+		weights[i] = px.weight
+		px.mu.Unlock()
+		total += weights[i]
+	}
+	n := rand.Float64() * total
+	for i, w := range weights {
+		if n < w {
+			return candidates[i]
+		}
+		n -= w
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (p *Pool) markSuccess(px *proxy) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	px.failures = 0
+	px.unhealthyUntil = time.Time{}
+	px.weight = px.weight*(1-weightDecay) + weightDecay
+}
+
+func (p *Pool) markFailure(px *proxy) {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	px.failures++
+	if px.failures >= p.opts.FailureThreshold {
+		px.unhealthyUntil = p.now().Add(p.opts.Cooldown)
+	}
+	px.weight = max(px.weight*(1-weightDecay), minWeight)
+}
+
+const (
+	// weightDecay controls how quickly a proxy's weight tracks its recent
+	// success rate
+	weightDecay = 0.1
+
+	// minWeight ensures that every healthy proxy is occasionally chosen by
+	// the weighted strategy, so that it may recover
+	minWeight = 0.01
+)
+
+// proxy is a single proxy in a pool.
+type proxy struct {
+	url *url.URL
+
+	mu             sync.Mutex
+	failures       int
+	unhealthyUntil time.Time
+	weight         float64
+}
+
+func newProxy(raw string) (*proxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProxy, err)
+	}
+	switch u.Scheme {
+	case "http", "socks5":
+	default:
+		// socks5h is deliberately unsupported, because it would have the
+		// proxy resolve hostnames
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidProxy, u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("%w: host and port required: %q", ErrInvalidProxy, u.Redacted())
+	}
+	return &proxy{url: u, weight: 1}, nil
+}
+
+func (px *proxy) healthy(now time.Time) bool {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	return px.healthyAt(now)
+}
+
+func (px *proxy) healthyAt(now time.Time) bool {
+	return !now.Before(px.unhealthyUntil)
+}
+
+// String returns the proxy's URL without any credentials.
+func (px *proxy) String() string {
+	return px.url.Scheme + "://" + px.url.Host
+}
@@ -0,0 +1,61 @@
+// Package requestid assigns each request a unique ID, which is included in
+// logs, telemetry, and error responses so that a client's report of a failed
+// request can be matched up with the server's view of it.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header is the HTTP header used to propagate request IDs. A valid ID given
+// by the client is used instead of generating a new one.
+const Header = "X-Request-ID"
+
+// maxLength limits the length of client-provided request IDs.
+const maxLength = 128
+
+type contextKey struct{}
+
+// New generates a new random request ID.
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FromOrNew returns the given client-provided request ID if it is valid, or
+// a new request ID otherwise.
+func FromOrNew(given string) string {
+	if isValid(given) {
+		return given
+	}
+	return New()
+}
+
+// NewContext returns a new context carrying the given request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by the given context, or an
+// empty string if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// isValid reports whether a client-provided request ID is acceptable, which
+// guards against IDs that would bloat or corrupt logs.
+func isValid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
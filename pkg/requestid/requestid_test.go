@@ -0,0 +1,46 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromOrNew(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		given    string
+		wantKept bool
+	}{
+		"valid id kept":            {"abc-123_XYZ.456", true},
+		"empty id replaced":        {"", false},
+		"id with spaces replaced":  {"abc 123", false},
+		"id with newline replaced": {"abc\n123", false},
+		"non-ascii id replaced":    {"abc✓", false},
+		"too long id replaced":     {strings.Repeat("a", maxLength+1), false},
+		"max length id kept":       {strings.Repeat("a", maxLength), true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got := FromOrNew(tc.given)
+			if tc.wantKept {
+				assert.Equal(t, tc.given, got)
+			} else {
+				assert.Regexp(t, `^[0-9a-f]{32}$`, got)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "", FromContext(context.Background()))
+	assert.Equal(t, "abc", FromContext(NewContext(context.Background(), "abc")))
+	assert.NotEqual(t, New(), New())
+}
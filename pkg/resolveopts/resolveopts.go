@@ -0,0 +1,291 @@
+/*
+Package resolveopts implements per-request resolve options, which allow
+clients to trade completeness for speed (or vice versa) on a single request.
+
+Clients request options via a Request, which is clamped to the Limits that
+apply to that client by a Policy. The resulting Options are carried to the
+resolver and transport layers through the request context:
+
+	opts, err := policy.LimitsFor(clientID).Apply(req)
+	if err != nil {
+		...
+	}
+	result, err := resolver.Resolve(resolveopts.NewContext(ctx, opts), givenURL)
+
+Options are enforced by Resolver, which applies the timeout, and by
+Transport, which applies the remaining options to every redirect hop. Options
+that affect results must be included in cache keys (see CacheKey), and every
+option must be included in request coalescing keys (see CoalesceKey).
+*/
+package resolveopts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User agent profiles.
+const (
+	UserAgentBrowser = "browser" // a desktop web browser (the default)
+	UserAgentMobile  = "mobile"  // a mobile web browser
+	UserAgentBot     = "bot"     // an honest bot user agent identifying this service
+)
+
+// userAgents maps profiles to the User-Agent header sent on their behalf.
+// The browser profile uses whatever the underlying transport sends.
+var userAgents = map[string]string{
+	UserAgentBrowser: "",
+	UserAgentMobile:  "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+	UserAgentBot:     "urlresolverapi (+https://github.com/mccutchen/urlresolverapi)",
+}
+
+// DefaultMaxRedirects is the number of redirects followed unless a client
+// requests otherwise.
+const DefaultMaxRedirects = 10
+
+// AnyClient is the key for per-client Limits that apply to every
+// authenticated client without its own limits.
+const AnyClient = "*"
+
+// Errors that may be returned when applying or enforcing options.
+var (
+	ErrInvalidTimeout      = errors.New("invalid timeout")
+	ErrInvalidMaxRedirects = errors.New("invalid max redirects")
+	ErrInvalidUserAgent    = errors.New("invalid user agent profile")
+	ErrTooManyRedirects    = errors.New("too many redirects")
+)
+
+// Options are the fully resolved options for a single resolve request.
+type Options struct {
+	// Timeout bounds the time spent resolving, in addition to the server's
+	// overall request timeout, if non-zero.
+	Timeout time.Duration
+
+	// MaxRedirects is the maximum number of redirects to follow.
+	MaxRedirects int
+
+	// FetchTitle indicates whether the resolved URL's title should be
+	// fetched.
+	FetchTitle bool
+
+	// UserAgent is the user agent profile used for every request.
+	UserAgent string
+}
+
+// Default returns the options used when a client requests none.
+func Default() Options {
+	return Options{
+		MaxRedirects: DefaultMaxRedirects,
+		FetchTitle:   true,
+		UserAgent:    UserAgentBrowser,
+	}
+}
+
+// Request holds the options requested by a client, any of which may be
+// unset.
+type Request struct {
+	Timeout      time.Duration
+	MaxRedirects *int
+	FetchTitle   *bool
+	UserAgent    string
+}
+
+// ParseRequest parses a Request from string values as given in a query
+// string or request body. Empty values are left unset. Timeouts are given as
+// durations (e.g. "2s" or "500ms").
+func ParseRequest(timeout, maxRedirects, fetchTitle, userAgent string) (Request, error) {
+	req := Request{UserAgent: userAgent}
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return Request{}, fmt.Errorf("%w: %q", ErrInvalidTimeout, timeout)
+		}
+		req.Timeout = d
+	}
+	if maxRedirects != "" {
+		n, err := strconv.Atoi(maxRedirects)
+		if err != nil {
+			return Request{}, fmt.Errorf("%w: %q", ErrInvalidMaxRedirects, maxRedirects)
+		}
+		req.MaxRedirects = &n
+	}
+	if fetchTitle != "" {
+		b, err := strconv.ParseBool(fetchTitle)
+		if err != nil {
+			return Request{}, fmt.Errorf("invalid fetch title: %q", fetchTitle)
+		}
+		req.FetchTitle = &b
+	}
+	return req, nil
+}
+
+// Limits bound the options a client may request. Zero values impose no
+// limit.
+type Limits struct {
+	MaxTimeout   time.Duration
+	MaxRedirects int
+}
+
+// Apply returns the Options for a Request, clamping requested values to
+// the limits. An error is returned if a requested value is invalid, rather
+// than merely too large.
+func (l Limits) Apply(req Request) (Options, error) {
+	opts := Default()
+
+	if req.Timeout < 0 {
+		return Options{}, fmt.Errorf("%w: must not be negative", ErrInvalidTimeout)
+	}
+	opts.Timeout = req.Timeout
+	if l.MaxTimeout > 0 && (opts.Timeout == 0 || opts.Timeout > l.MaxTimeout) {
+		opts.Timeout = l.MaxTimeout
+	}
+
+	if req.MaxRedirects != nil {
+		if *req.MaxRedirects < 0 {
+			return Options{}, fmt.Errorf("%w: must not be negative", ErrInvalidMaxRedirects)
+		}
+		opts.MaxRedirects = *req.MaxRedirects
+	}
+	if l.MaxRedirects > 0 && opts.MaxRedirects > l.MaxRedirects {
+		opts.MaxRedirects = l.MaxRedirects
+	}
+
+	if req.FetchTitle != nil {
+		opts.FetchTitle = *req.FetchTitle
+	}
+
+	if req.UserAgent != "" {
+		if _, ok := userAgents[req.UserAgent]; !ok {
+			return Options{}, fmt.Errorf("%w: %q", ErrInvalidUserAgent, req.UserAgent)
+		}
+		opts.UserAgent = req.UserAgent
+	}
+
+	return opts, nil
+}
+
+// tighten returns limits that are at least as strict as both l and other.
+func (l Limits) tighten(other Limits) Limits {
+	if other.MaxTimeout > 0 && (l.MaxTimeout == 0 || other.MaxTimeout < l.MaxTimeout) {
+		l.MaxTimeout = other.MaxTimeout
+	}
+	if other.MaxRedirects > 0 && (l.MaxRedirects == 0 || other.MaxRedirects < l.MaxRedirects) {
+		l.MaxRedirects = other.MaxRedirects
+	}
+	return l
+}
+
+// Policy determines the Limits that apply to each client.
+type Policy struct {
+	// Limits apply to every client.
+	Limits Limits
+
+	// Clients holds stricter limits for specific authenticated clients,
+	// keyed by client ID or AnyClient.
+	Clients map[string]Limits
+}
+
+// LimitsFor returns the limits that apply to the given client, which may be
+// empty for anonymous clients.
+func (p Policy) LimitsFor(clientID string) Limits {
+	if clientID == "" {
+		return p.Limits
+	}
+	if limits, ok := p.Clients[clientID]; ok {
+		return p.Limits.tighten(limits)
+	}
+	return p.Limits.tighten(p.Clients[AnyClient])
+}
+
+// ParseClientLimits parses per-client Limits from a semicolon-separated list
+// of "client-id/limit=value" entries, where limit is "timeout" or
+// "redirects". The client ID may be AnyClient to apply to every
+// authenticated client without its own limits. For example:
+//
+//	*/timeout=5s;batch/timeout=2s;batch/redirects=3
+func ParseClientLimits(config string) (map[string]Limits, error) {
+	if len(strings.TrimSpace(config)) == 0 {
+		return nil, nil
+	}
+
+	clients := make(map[string]Limits)
+	for _, def := range strings.Split(config, ";") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		key, value, found := strings.Cut(def, "=")
+		clientID, limit, hasLimit := strings.Cut(strings.TrimSpace(key), "/")
+		if !found || !hasLimit {
+			return nil, fmt.Errorf(`invalid client limit %q, must be in "client-id/limit=value" format`, def)
+		}
+		if clientID == "" {
+			return nil, fmt.Errorf("client limit %q has empty client ID", def)
+		}
+
+		limits := clients[clientID]
+		value = strings.TrimSpace(value)
+		switch limit {
+		case "timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid client limit %q: timeout must be a positive duration", def)
+			}
+			limits.MaxTimeout = d
+		case "redirects":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid client limit %q: redirects must be a positive integer", def)
+			}
+			limits.MaxRedirects = n
+		default:
+			return nil, fmt.Errorf("invalid client limit %q: unknown limit %q", def, limit)
+		}
+		clients[clientID] = limits
+	}
+	return clients, nil
+}
+
+type optionsKeyType int
+
+const optionsKey = optionsKeyType(1)
+
+// NewContext returns a new context carrying the given Options.
+func NewContext(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, optionsKey, opts)
+}
+
+// FromContext returns the Options carried by the context, or the Default
+// options if there are none.
+func FromContext(ctx context.Context) Options {
+	if opts, ok := ctx.Value(optionsKey).(Options); ok {
+		return opts
+	}
+	return Default()
+}
+
+// CacheKey returns the cache key for the given key under the context's
+// Options. Only options that affect successful results are included, and
+// the key is unchanged under the default options, so that results cached
+// before options were introduced remain valid.
+func CacheKey(ctx context.Context, key string) string {
+	opts := FromContext(ctx)
+	if !opts.FetchTitle {
+		key += "#notitle"
+	}
+	if opts.UserAgent != UserAgentBrowser {
+		key += "#ua=" + opts.UserAgent
+	}
+	return key
+}
+
+// CoalesceKey returns the request coalescing key for the given key under
+// the context's Options. Every option is included, because coalesced
+// requests share a single result, which must satisfy each of them.
+func CoalesceKey(ctx context.Context, key string) string {
+	opts := FromContext(ctx)
+	return fmt.Sprintf("%s#timeout=%s,redirects=%d,title=%t,ua=%s", key, opts.Timeout, opts.MaxRedirects, opts.FetchTitle, opts.UserAgent)
+}
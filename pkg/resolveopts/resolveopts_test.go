@@ -0,0 +1,247 @@
+//nolint:errcheck
+package resolveopts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+func intPtr(n int) *int    { return &n }
+func boolPtr(b bool) *bool { return &b }
+
+func TestParseRequest(t *testing.T) {
+	t.Parallel()
+
+	req, err := ParseRequest("", "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, Request{}, req)
+
+	req, err = ParseRequest("1.5s", "3", "false", UserAgentMobile)
+	assert.NoError(t, err)
+	assert.Equal(t, Request{
+		Timeout:      1500 * time.Millisecond,
+		MaxRedirects: intPtr(3),
+		FetchTitle:   boolPtr(false),
+		UserAgent:    UserAgentMobile,
+	}, req)
+
+	_, err = ParseRequest("10", "", "", "")
+	assert.ErrorIs(t, err, ErrInvalidTimeout)
+	_, err = ParseRequest("", "three", "", "")
+	assert.ErrorIs(t, err, ErrInvalidMaxRedirects)
+	_, err = ParseRequest("", "", "nope", "")
+	assert.ErrorContains(t, err, "invalid fetch title")
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	limits := Limits{MaxTimeout: 10 * time.Second, MaxRedirects: 5}
+
+	testCases := map[string]struct {
+		limits  Limits
+		req     Request
+		want    Options
+		wantErr error
+	}{
+		"defaults without limits": {
+			want: Default(),
+		},
+		"defaults are clamped": {
+			limits: limits,
+			want:   Options{Timeout: 10 * time.Second, MaxRedirects: 5, FetchTitle: true, UserAgent: UserAgentBrowser},
+		},
+		"requested values within limits": {
+			limits: limits,
+			req:    Request{Timeout: time.Second, MaxRedirects: intPtr(0), FetchTitle: boolPtr(false), UserAgent: UserAgentBot},
+			want:   Options{Timeout: time.Second, MaxRedirects: 0, FetchTitle: false, UserAgent: UserAgentBot},
+		},
+		"requested values are clamped": {
+			limits: limits,
+			req:    Request{Timeout: time.Minute, MaxRedirects: intPtr(50)},
+			want:   Options{Timeout: 10 * time.Second, MaxRedirects: 5, FetchTitle: true, UserAgent: UserAgentBrowser},
+		},
+		"negative timeout": {
+			req:     Request{Timeout: -time.Second},
+			wantErr: ErrInvalidTimeout,
+		},
+		"negative redirects": {
+			req:     Request{MaxRedirects: intPtr(-1)},
+			wantErr: ErrInvalidMaxRedirects,
+		},
+		"unknown user agent": {
+			req:     Request{UserAgent: "netscape"},
+			wantErr: ErrInvalidUserAgent,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := tc.limits.Apply(tc.req)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	clients, err := ParseClientLimits("*/timeout=5s; slow/timeout=30s; slow/redirects=20 ;batch/redirects=2")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Limits{
+		AnyClient: {MaxTimeout: 5 * time.Second},
+		"slow":    {MaxTimeout: 30 * time.Second, MaxRedirects: 20},
+		"batch":   {MaxRedirects: 2},
+	}, clients)
+
+	policy := Policy{
+		Limits:  Limits{MaxTimeout: 10 * time.Second, MaxRedirects: 10},
+		Clients: clients,
+	}
+	// client limits may only be stricter than the server's
+	assert.Equal(t, Limits{MaxTimeout: 10 * time.Second, MaxRedirects: 10}, policy.LimitsFor(""))
+	assert.Equal(t, Limits{MaxTimeout: 5 * time.Second, MaxRedirects: 10}, policy.LimitsFor("other"))
+	assert.Equal(t, Limits{MaxTimeout: 10 * time.Second, MaxRedirects: 10}, policy.LimitsFor("slow"))
+	assert.Equal(t, Limits{MaxTimeout: 10 * time.Second, MaxRedirects: 2}, policy.LimitsFor("batch"))
+
+	for config, wantErr := range map[string]string{
+		"a=5s":            "must be in",
+		"/timeout=5s":     "empty client ID",
+		"a/timeout=fast":  "positive duration",
+		"a/redirects=0":   "positive integer",
+		"a/bandwidth=1mb": `unknown limit "bandwidth"`,
+	} {
+		_, err := ParseClientLimits(config)
+		assert.ErrorContains(t, err, wantErr, config)
+	}
+
+	clients, err = ParseClientLimits(" ")
+	assert.NoError(t, err)
+	assert.Nil(t, clients)
+}
+
+func TestKeys(t *testing.T) {
+	t.Parallel()
+
+	key := "https://example.com"
+	ctx := context.Background()
+	assert.Equal(t, key, CacheKey(ctx, key))
+	assert.Equal(t, key, CacheKey(NewContext(ctx, Default()), key))
+
+	// timeouts and redirect limits don't affect successful results
+	opts := Default()
+	opts.Timeout = time.Second
+	opts.MaxRedirects = 2
+	assert.Equal(t, key, CacheKey(NewContext(ctx, opts), key))
+	assert.NotEqual(t, CoalesceKey(ctx, key), CoalesceKey(NewContext(ctx, opts), key))
+
+	opts = Default()
+	opts.FetchTitle = false
+	assert.Equal(t, key+"#notitle", CacheKey(NewContext(ctx, opts), key))
+	assert.NotEqual(t, CoalesceKey(ctx, key), CoalesceKey(NewContext(ctx, opts), key))
+
+	opts = Default()
+	opts.UserAgent = UserAgentMobile
+	assert.Equal(t, key+"#ua=mobile", CacheKey(NewContext(ctx, opts), key))
+	assert.NotEqual(t, CoalesceKey(ctx, key), CoalesceKey(NewContext(ctx, opts), key))
+}
+
+func TestResolverAndTransport(t *testing.T) {
+	t.Parallel()
+
+	// /redirect/N redirects N more times before landing on a page with a
+	// title that echoes the request's User-Agent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/")); err == nil {
+			if n > 0 {
+				http.Redirect(w, r, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+				return
+			}
+			w.Write([]byte("<title>" + r.UserAgent() + "</title>"))
+			return
+		}
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+		w.Write([]byte("<title>title</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	resolver := NewResolver(urlresolver.New(NewTransport(http.DefaultTransport), 0))
+
+	resolve := func(opts Options, path string) (urlresolver.Result, error) {
+		return resolver.Resolve(NewContext(context.Background(), opts), srv.URL+path)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+		result, err := resolver.Resolve(context.Background(), srv.URL+"/redirect/3")
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/redirect/0", result.ResolvedURL)
+		assert.NotEqual(t, userAgents[UserAgentBot], result.Title)
+	})
+
+	t.Run("redirects within limit", func(t *testing.T) {
+		t.Parallel()
+		opts := Default()
+		opts.MaxRedirects = 3
+		result, err := resolve(opts, "/redirect/3")
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/redirect/0", result.ResolvedURL)
+	})
+
+	t.Run("too many redirects", func(t *testing.T) {
+		t.Parallel()
+		opts := Default()
+		opts.MaxRedirects = 2
+		_, err := resolve(opts, "/redirect/3")
+		assert.ErrorIs(t, err, ErrTooManyRedirects)
+	})
+
+	t.Run("no title", func(t *testing.T) {
+		t.Parallel()
+		opts := Default()
+		opts.FetchTitle = false
+		result, err := resolve(opts, "/redirect/1")
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/redirect/0", result.ResolvedURL)
+		assert.Equal(t, "", result.Title)
+	})
+
+	t.Run("user agent", func(t *testing.T) {
+		t.Parallel()
+		opts := Default()
+		opts.UserAgent = UserAgentBot
+		result, err := resolve(opts, "/redirect/1")
+		assert.NoError(t, err)
+		assert.Equal(t, userAgents[UserAgentBot], result.Title)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		opts := Default()
+		opts.Timeout = 50 * time.Millisecond
+		start := time.Now()
+		_, err := resolve(opts, "/slow")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+}
@@ -0,0 +1,52 @@
+package resolveopts
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+// Resolver is a urlresolver.Interface implementation that applies the
+// timeout from the context's Options, and tracks the requests made while
+// resolving so that Transport may enforce the redirect limit.
+type Resolver struct {
+	resolver urlresolver.Interface
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(resolver urlresolver.Interface) *Resolver {
+	return &Resolver{
+		resolver: resolver,
+	}
+}
+
+// Resolve resolves a URL according to the context's Options.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	opts := FromContext(ctx)
+	beeline.AddField(ctx, "resolve_opts.timeout_ms", opts.Timeout.Milliseconds())
+	beeline.AddField(ctx, "resolve_opts.max_redirects", opts.MaxRedirects)
+	beeline.AddField(ctx, "resolve_opts.fetch_title", opts.FetchTitle)
+	beeline.AddField(ctx, "resolve_opts.user_agent", opts.UserAgent)
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	ctx = context.WithValue(ctx, requestCounterKey, &atomic.Int64{})
+	return r.resolver.Resolve(ctx, givenURL)
+}
+
+type requestCounterKeyType int
+
+const requestCounterKey = requestCounterKeyType(1)
+
+// requestCounter returns the counter of requests made while resolving, or
+// nil if the request is not being made on behalf of a Resolver.
+func requestCounter(ctx context.Context) *atomic.Int64 {
+	counter, _ := ctx.Value(requestCounterKey).(*atomic.Int64)
+	return counter
+}
@@ -0,0 +1,55 @@
+package resolveopts
+
+import (
+	"net/http"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolverapi/pkg/response"
+)
+
+// Transport is an http.RoundTripper that applies the request context's
+// Options to every request, including each redirect hop.
+//
+// Transport should be wrapped by any transport that sets default request
+// headers, so that its user agent takes precedence.
+type Transport struct {
+	transport http.RoundTripper
+}
+
+// NewTransport creates a new Transport that applies Options before passing
+// requests on to the given transport.
+func NewTransport(transport http.RoundTripper) *Transport {
+	return &Transport{
+		transport: transport,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	opts := FromContext(ctx)
+
+	// the first request is not a redirect
+	if counter := requestCounter(ctx); counter != nil && counter.Add(1) > int64(opts.MaxRedirects)+1 {
+		beeline.AddField(ctx, "resolve_opts.too_many_redirects", true)
+		return nil, ErrTooManyRedirects
+	}
+
+	if ua := userAgents[opts.UserAgent]; ua != "" {
+		req = req.Clone(ctx)
+		req.Header.Set("User-Agent", ua)
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	// If the title is not wanted, there's no need to download the body of
+	// the final response.
+	if !opts.FetchTitle && !response.IsRedirect(resp.StatusCode) {
+		response.DropBody(resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,153 @@
+package cached
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+// Errors that may be returned by AsyncCache.Add.
+var (
+	ErrWriteQueueFull = errors.New("cache write queue full")
+	ErrCacheClosed    = errors.New("cache closed")
+)
+
+// AsyncCache is a Cache implementation that writes to an underlying Cache in
+// the background, using a bounded queue and a fixed pool of workers. Writes
+// are dropped when the queue is full. Reads pass straight through.
+type AsyncCache struct {
+	cache Cache
+	queue chan asyncWrite
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	dropped atomic.Int64
+	written atomic.Int64
+	failed  atomic.Int64
+}
+
+var _ Cache = &AsyncCache{} // AsyncCache implements Cache
+
+type asyncWrite struct {
+	ctx   context.Context
+	key   string
+	value urlresolver.Result
+}
+
+// AsyncCacheStats summarizes the writes handled by an AsyncCache.
+type AsyncCacheStats struct {
+	Pending int   `json:"pending"`
+	Dropped int64 `json:"dropped"`
+	Written int64 `json:"written"`
+	Failed  int64 `json:"failed"`
+}
+
+// NewAsyncCache creates a new AsyncCache that will queue up to queueSize
+// writes to the given Cache, to be processed by the given number of workers.
+func NewAsyncCache(cache Cache, workers int, queueSize int) *AsyncCache {
+	if workers < 1 {
+		workers = 1
+	}
+	c := &AsyncCache{
+		cache: cache,
+		queue: make(chan asyncWrite, queueSize),
+	}
+	c.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go c.work()
+	}
+	return c
+}
+
+// Add queues a Result to be added to the underlying cache, returning
+// ErrWriteQueueFull if the write was dropped.
+func (c *AsyncCache) Add(ctx context.Context, key string, value urlresolver.Result) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		c.dropped.Add(1)
+		beeline.AddField(ctx, "cache.write_dropped", true)
+		return ErrCacheClosed
+	}
+
+	// The write will outlive the request that triggered it, so it must not
+	// be canceled along with the request.
+	w := asyncWrite{
+		ctx:   context.WithoutCancel(ctx),
+		key:   key,
+		value: value,
+	}
+	select {
+	case c.queue <- w:
+		beeline.AddField(ctx, "cache.write_dropped", false)
+		return nil
+	default:
+		c.dropped.Add(1)
+		beeline.AddField(ctx, "cache.write_dropped", true)
+		return ErrWriteQueueFull
+	}
+}
+
+// Get gets a Result from the underlying cache.
+func (c *AsyncCache) Get(ctx context.Context, key string) (urlresolver.Result, bool, error) {
+	return c.cache.Get(ctx, key)
+}
+
+// Name returns the name of the underlying cache, for instrumentation
+// purposes.
+func (c *AsyncCache) Name() string {
+	return c.cache.Name()
+}
+
+// Stats returns a summary of the writes handled so far.
+func (c *AsyncCache) Stats() AsyncCacheStats {
+	return AsyncCacheStats{
+		Pending: len(c.queue),
+		Dropped: c.dropped.Load(),
+		Written: c.written.Load(),
+		Failed:  c.failed.Load(),
+	}
+}
+
+// Shutdown stops accepting new writes and waits for any pending writes to
+// be processed, or for the given context to be done, whichever comes first.
+func (c *AsyncCache) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *AsyncCache) work() {
+	defer c.wg.Done()
+	for w := range c.queue {
+		if err := c.cache.Add(w.ctx, w.key, w.value); err != nil {
+			c.failed.Add(1)
+		} else {
+			c.written.Add(1)
+		}
+	}
+}
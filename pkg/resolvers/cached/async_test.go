@@ -0,0 +1,86 @@
+package cached
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+func TestAsyncCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := &fakeCache{}
+	c := NewAsyncCache(backend, 2, 10)
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.NoError(t, c.Add(ctx, key, urlresolver.Result{ResolvedURL: key}))
+	}
+
+	// all pending writes are flushed on shutdown
+	assert.NoError(t, c.Shutdown(ctx))
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		result, ok, err := c.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, key, result.ResolvedURL)
+	}
+	assert.Equal(t, AsyncCacheStats{Written: 10}, c.Stats())
+
+	// writes after shutdown are dropped
+	assert.ErrorIs(t, c.Add(ctx, "late", urlresolver.Result{}), ErrCacheClosed)
+	assert.Equal(t, int64(1), c.Stats().Dropped)
+
+	// shutdown may be called more than once
+	assert.NoError(t, c.Shutdown(ctx))
+}
+
+func TestAsyncCacheQueueFull(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := &blockingCache{unblock: make(chan struct{}), started: make(chan struct{}, 1)}
+	c := NewAsyncCache(backend, 1, 1)
+
+	// first write is picked up by the single worker, which blocks
+	assert.NoError(t, c.Add(ctx, "a", urlresolver.Result{}))
+	<-backend.started
+
+	// second write fills the queue, third is dropped
+	assert.NoError(t, c.Add(ctx, "b", urlresolver.Result{}))
+	assert.ErrorIs(t, c.Add(ctx, "c", urlresolver.Result{}), ErrWriteQueueFull)
+	assert.Equal(t, AsyncCacheStats{Pending: 1, Dropped: 1}, c.Stats())
+
+	// shutdown gives up when its context is done
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Shutdown(shutdownCtx), context.DeadlineExceeded)
+
+	// and succeeds once pending writes can complete
+	close(backend.unblock)
+	assert.NoError(t, c.Shutdown(ctx))
+	assert.Equal(t, AsyncCacheStats{Dropped: 1, Written: 2}, c.Stats())
+}
+
+// blockingCache blocks all writes until its unblock channel is closed.
+type blockingCache struct {
+	fakeCache
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (c *blockingCache) Add(ctx context.Context, key string, value urlresolver.Result) error {
+	select {
+	case c.started <- struct{}{}:
+	default:
+	}
+	<-c.unblock
+	return c.fakeCache.Add(ctx, key, value)
+}
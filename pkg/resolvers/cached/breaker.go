@@ -0,0 +1,254 @@
+package cached
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+	"github.com/rs/zerolog"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+// ErrBreakerOpen is returned by a BreakerCache when its circuit breaker is
+// open and the underlying cache is being skipped.
+var ErrBreakerOpen = errors.New("cache circuit breaker open")
+
+// BreakerState is the state of a BreakerCache's circuit breaker.
+type BreakerState int
+
+// Breaker states.
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerOptions configures a BreakerCache.
+type BreakerOptions struct {
+	// ErrorRate is the fraction of failed operations within a Window that
+	// will trip the breaker.
+	ErrorRate float64
+
+	// MinRequests is the minimum number of operations that must be observed
+	// within a Window before the breaker may trip.
+	MinRequests int
+
+	// SlowThreshold, if non-zero, causes any operation that takes longer
+	// than this to be counted as a failure.
+	SlowThreshold time.Duration
+
+	// Window is the length of the interval over which error rates are
+	// calculated.
+	Window time.Duration
+
+	// OpenTimeout is how long the breaker stays open before it starts
+	// probing the underlying cache again.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of consecutive successful probes needed
+	// to close the breaker again.
+	HalfOpenProbes int
+}
+
+// BreakerCache is a Cache implementation that wraps another Cache with a
+// circuit breaker, so that a degraded cache backend is skipped entirely
+// instead of adding latency to every request.
+type BreakerCache struct {
+	cache  Cache
+	opts   BreakerOptions
+	logger zerolog.Logger
+	now    func() time.Time
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	probeOKs    int
+}
+
+var _ Cache = &BreakerCache{} // BreakerCache implements Cache
+
+// NewBreakerCache wraps the given Cache in a circuit breaker configured by
+// opts. Breaker state changes are logged to the given logger.
+func NewBreakerCache(cache Cache, opts BreakerOptions, logger zerolog.Logger) *BreakerCache {
+	if opts.MinRequests < 1 {
+		opts.MinRequests = 1
+	}
+	if opts.HalfOpenProbes < 1 {
+		opts.HalfOpenProbes = 1
+	}
+	return &BreakerCache{
+		cache:  cache,
+		opts:   opts,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Add adds a Result to the underlying cache, unless the breaker is open.
+func (c *BreakerCache) Add(ctx context.Context, key string, value urlresolver.Result) error {
+	probe, err := c.allow(ctx)
+	if err != nil {
+		return err
+	}
+	start := c.now()
+	err = c.cache.Add(ctx, key, value)
+	c.record(ctx, probe, c.now().Sub(start), err)
+	return err
+}
+
+// Get gets a Result from the underlying cache, unless the breaker is open,
+// in which case it is treated as a cache miss.
+func (c *BreakerCache) Get(ctx context.Context, key string) (urlresolver.Result, bool, error) {
+	probe, err := c.allow(ctx)
+	if err != nil {
+		return urlresolver.Result{}, false, err
+	}
+	start := c.now()
+	result, ok, err := c.cache.Get(ctx, key)
+	c.record(ctx, probe, c.now().Sub(start), err)
+	return result, ok, err
+}
+
+// Name returns the name of the underlying cache, for instrumentation
+// purposes.
+func (c *BreakerCache) Name() string {
+	return c.cache.Name()
+}
+
+// State returns the current state of the circuit breaker.
+func (c *BreakerCache) State() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// allow determines whether an operation may proceed against the underlying
+// cache, returning ErrBreakerOpen if not. The returned bool indicates
+// whether the operation is a half-open probe.
+func (c *BreakerCache) allow(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == BreakerOpen && c.now().Sub(c.openedAt) >= c.opts.OpenTimeout {
+		c.transition(ctx, BreakerHalfOpen)
+	}
+	beeline.AddField(ctx, "cache.breaker_state", c.state.String())
+
+	switch c.state {
+	case BreakerOpen:
+		return false, ErrBreakerOpen
+	case BreakerHalfOpen:
+		// Only allow a single probe in flight at a time
+		if c.probing {
+			return false, ErrBreakerOpen
+		}
+		c.probing = true
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// record records the outcome of an operation against the underlying cache,
+// tripping or resetting the breaker as necessary.
+//
+// Operations canceled by the caller (e.g. because a client disconnected) say
+// nothing about the health of the cache, so they are not counted at all.
+func (c *BreakerCache) record(ctx context.Context, probe bool, elapsed time.Duration, err error) {
+	canceled := errors.Is(err, context.Canceled)
+	failed := err != nil || (c.opts.SlowThreshold > 0 && elapsed > c.opts.SlowThreshold)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if canceled {
+		if probe {
+			// let another operation probe the cache instead
+			c.probing = false
+		}
+		return
+	}
+
+	if probe {
+		c.probing = false
+		if failed {
+			c.transition(ctx, BreakerOpen)
+			return
+		}
+		c.probeOKs++
+		if c.probeOKs >= c.opts.HalfOpenProbes {
+			c.transition(ctx, BreakerClosed)
+		}
+		return
+	}
+
+	// The breaker may have changed state while this operation was in flight,
+	// in which case its outcome no longer matters.
+	if c.state != BreakerClosed {
+		return
+	}
+
+	now := c.now()
+	if now.Sub(c.windowStart) >= c.opts.Window {
+		c.windowStart = now
+		c.requests = 0
+		c.failures = 0
+	}
+	c.requests++
+	if failed {
+		c.failures++
+	}
+	if c.requests >= c.opts.MinRequests && float64(c.failures)/float64(c.requests) >= c.opts.ErrorRate {
+		c.transition(ctx, BreakerOpen)
+	}
+}
+
+// transition moves the breaker into a new state. Must be called with c.mu
+// held.
+func (c *BreakerCache) transition(ctx context.Context, state BreakerState) {
+	prev := c.state
+	c.state = state
+	c.probing = false
+	c.probeOKs = 0
+	c.requests = 0
+	c.failures = 0
+	c.windowStart = c.now()
+	if state == BreakerOpen {
+		c.openedAt = c.windowStart
+	}
+
+	_, span := beeline.StartSpan(ctx, "cache.breaker_state_change")
+	span.AddField("cache.name", c.cache.Name())
+	span.AddField("cache.breaker_state_prev", prev.String())
+	span.AddField("cache.breaker_state", state.String())
+	span.Send()
+
+	evt := c.logger.Info()
+	if state == BreakerOpen {
+		evt = c.logger.Warn()
+	}
+	evt.Str("cache_name", c.cache.Name()).
+		Str("prev_state", prev.String()).
+		Str("state", state.String()).
+		Msg("cache circuit breaker state changed")
+}
@@ -0,0 +1,235 @@
+package cached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+)
+
+func TestBreakerCache(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx      = context.Background()
+		errCache = errors.New("cache error")
+		result   = urlresolver.Result{ResolvedURL: "https://example.com/"}
+	)
+
+	clock := &fakeClock{now: time.Now()}
+	backend := &fakeCache{}
+	c := NewBreakerCache(backend, BreakerOptions{
+		ErrorRate:      0.5,
+		MinRequests:    4,
+		SlowThreshold:  50 * time.Millisecond,
+		Window:         time.Minute,
+		OpenTimeout:    10 * time.Second,
+		HalfOpenProbes: 2,
+	}, zerolog.Nop())
+	c.now = clock.Now
+
+	// healthy backend, breaker stays closed
+	assert.NoError(t, c.Add(ctx, "key", result))
+	got, ok, err := c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, result, got)
+	assert.Equal(t, BreakerClosed, c.State())
+
+	// a failure rate of exactly 50% over the minimum request count trips the
+	// breaker
+	backend.setErr(errCache)
+	_, _, err = c.Get(ctx, "key")
+	assert.ErrorIs(t, err, errCache)
+	assert.Equal(t, BreakerClosed, c.State())
+	_, _, err = c.Get(ctx, "key")
+	assert.ErrorIs(t, err, errCache)
+	assert.Equal(t, BreakerOpen, c.State())
+
+	// while open, the backend is skipped entirely
+	calls := backend.callCount()
+	_, ok, err = c.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrBreakerOpen)
+	assert.False(t, ok)
+	assert.ErrorIs(t, c.Add(ctx, "key", result), ErrBreakerOpen)
+	assert.Equal(t, calls, backend.callCount(), "backend should not be called while breaker is open")
+
+	// after the open timeout, a failed probe re-opens the breaker
+	clock.Advance(10 * time.Second)
+	_, _, err = c.Get(ctx, "key")
+	assert.ErrorIs(t, err, errCache)
+	assert.Equal(t, BreakerOpen, c.State())
+
+	// after the open timeout, enough successful probes close the breaker
+	backend.setErr(nil)
+	clock.Advance(10 * time.Second)
+	_, ok, err = c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, BreakerHalfOpen, c.State())
+	_, ok, err = c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, BreakerClosed, c.State())
+}
+
+func TestBreakerCacheSlowOperations(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Now()}
+	backend := &fakeCache{clock: clock, delay: 100 * time.Millisecond}
+	c := NewBreakerCache(backend, BreakerOptions{
+		ErrorRate:     1,
+		MinRequests:   3,
+		SlowThreshold: 50 * time.Millisecond,
+		Window:        time.Minute,
+		OpenTimeout:   time.Second,
+	}, zerolog.Nop())
+	c.now = clock.Now
+
+	for i := 0; i < 3; i++ {
+		_, _, err := c.Get(context.Background(), "key")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, BreakerOpen, c.State(), "slow operations should trip the breaker")
+}
+
+func TestBreakerCacheWindowReset(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Now()}
+	backend := &fakeCache{err: errors.New("cache error")}
+	c := NewBreakerCache(backend, BreakerOptions{
+		ErrorRate:   0.5,
+		MinRequests: 2,
+		Window:      time.Second,
+		OpenTimeout: time.Second,
+	}, zerolog.Nop())
+	c.now = clock.Now
+
+	_, _, _ = c.Get(context.Background(), "key")
+	clock.Advance(time.Second)
+	_, _, _ = c.Get(context.Background(), "key")
+	assert.Equal(t, BreakerClosed, c.State(), "failures in previous window should not count")
+}
+
+func TestBreakerCacheCanceledOperations(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Now()}
+	backend := &fakeCache{err: context.Canceled}
+	c := NewBreakerCache(backend, BreakerOptions{
+		ErrorRate:   0.5,
+		MinRequests: 2,
+		Window:      time.Minute,
+		OpenTimeout: time.Second,
+	}, zerolog.Nop())
+	c.now = clock.Now
+
+	for i := 0; i < 3; i++ {
+		_, _, err := c.Get(context.Background(), "key")
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	assert.Equal(t, BreakerClosed, c.State(), "canceled operations should not trip the breaker")
+
+	// trip the breaker, then cancel the half-open probe
+	backend.setErr(errors.New("cache error"))
+	_, _, _ = c.Get(context.Background(), "key")
+	_, _, _ = c.Get(context.Background(), "key")
+	assert.Equal(t, BreakerOpen, c.State())
+
+	clock.Advance(time.Second)
+	backend.setErr(fmt.Errorf("redis: %w", context.Canceled))
+	_, _, err := c.Get(context.Background(), "key")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, BreakerHalfOpen, c.State(), "a canceled probe should neither open nor close the breaker")
+
+	// and the next operation may probe in its place
+	backend.setErr(nil)
+	_, _, err = c.Get(context.Background(), "key")
+	assert.NoError(t, err)
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeCache is an in-memory Cache that can be configured to fail or to
+// (artificially) take a long time.
+type fakeCache struct {
+	clock *fakeClock
+	delay time.Duration
+
+	mu    sync.Mutex
+	err   error
+	calls int
+	data  map[string]urlresolver.Result
+}
+
+func (c *fakeCache) Add(_ context.Context, key string, value urlresolver.Result) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tick()
+	if c.err != nil {
+		return c.err
+	}
+	if c.data == nil {
+		c.data = make(map[string]urlresolver.Result)
+	}
+	c.data[key] = value
+	return nil
+}
+
+func (c *fakeCache) Get(_ context.Context, key string) (urlresolver.Result, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tick()
+	if c.err != nil {
+		return urlresolver.Result{}, false, c.err
+	}
+	value, ok := c.data[key]
+	return value, ok, nil
+}
+
+func (c *fakeCache) Name() string {
+	return "fake"
+}
+
+func (c *fakeCache) tick() {
+	c.calls++
+	if c.clock != nil {
+		c.clock.Advance(c.delay)
+	}
+}
+
+func (c *fakeCache) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *fakeCache) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
@@ -10,14 +10,18 @@ import (
 	"github.com/honeycombio/beeline-go"
 
 	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
 )
 
 const redisCacheVersion = "1"
 
 // Cache is a generic cache interface.
+//
+// Errors returned by a Cache are informational: a failed Get should be
+// treated as a cache miss, and a failed Add may be ignored.
 type Cache interface {
-	Add(ctx context.Context, key string, value urlresolver.Result)
-	Get(ctx context.Context, key string) (value urlresolver.Result, ok bool)
+	Add(ctx context.Context, key string, value urlresolver.Result) error
+	Get(ctx context.Context, key string) (value urlresolver.Result, ok bool, err error)
 	Name() string
 }
 
@@ -25,6 +29,23 @@ type Cache interface {
 type RedisCache struct {
 	cache *cache.Cache
 	ttl   time.Duration
+	now   func() time.Time
+}
+
+// redisCacheEntry is the value stored in redis for each result, along with
+// the resultmeta.Meta describing it that must survive a round trip through
+// the cache.
+//
+// The embedded result's fields are inlined when encoded, so entries remain
+// compatible with the bare urlresolver.Result values stored before metadata
+// was cached: old entries decode without metadata, and new entries decode as
+// results for instances that are not yet aware of it.
+type redisCacheEntry struct {
+	urlresolver.Result
+	StoredAt            time.Time
+	OriginalResolvedURL string
+	ContentType         string
+	Interstitial        bool
 }
 
 var _ Cache = &RedisCache{} // RedisCache implements Cache
@@ -35,43 +56,61 @@ func NewRedisCache(cache *cache.Cache, ttl time.Duration) *RedisCache {
 	return &RedisCache{
 		cache: cache,
 		ttl:   ttl,
+		now:   time.Now,
 	}
 }
 
-// Add adds a Result to the cache.
-func (c *RedisCache) Add(ctx context.Context, key string, value urlresolver.Result) {
+// Add adds a Result to the cache, along with the metadata describing it that
+// is recorded in the context's resultmeta.Recorder.
+func (c *RedisCache) Add(ctx context.Context, key string, value urlresolver.Result) error {
 	ctx, span := beeline.StartSpan(ctx, "cache.add")
 	span.AddField("cache.name", c.Name())
 	span.AddField("cache.key", key)
 	defer span.Send()
 
+	meta := resultmeta.FromContext(ctx).Meta()
 	err := c.cache.Set(&cache.Item{
-		Ctx:   ctx,
-		Key:   redisCacheKey(key),
-		Value: value,
-		TTL:   c.ttl,
+		Ctx: ctx,
+		Key: redisCacheKey(key),
+		Value: redisCacheEntry{
+			Result:              value,
+			StoredAt:            c.now(),
+			OriginalResolvedURL: meta.OriginalResolvedURL,
+			ContentType:         meta.ContentType,
+			Interstitial:        meta.Interstitial,
+		},
+		TTL: c.ttl,
 	})
 	if err != nil {
 		span.AddField("error", err.Error())
 	}
+	return err
 }
 
 // Get gets a Result from the cache, returning a bool indicating whether it was
-// present.
-func (c *RedisCache) Get(ctx context.Context, key string) (urlresolver.Result, bool) {
+// present. The time at which the result was stored and the metadata describing
+// it are recorded in the context's resultmeta.Recorder.
+func (c *RedisCache) Get(ctx context.Context, key string) (urlresolver.Result, bool, error) {
 	ctx, span := beeline.StartSpan(ctx, "cache.get")
 	span.AddField("cache.name", c.Name())
 	span.AddField("cache.key", key)
 	defer span.Send()
 
-	var result urlresolver.Result
-	if err := c.cache.Get(ctx, redisCacheKey(key), &result); err != nil {
-		if err != cache.ErrCacheMiss {
-			span.AddField("error", err.Error())
+	var entry redisCacheEntry
+	if err := c.cache.Get(ctx, redisCacheKey(key), &entry); err != nil {
+		if err == cache.ErrCacheMiss {
+			return urlresolver.Result{}, false, nil
 		}
-		return urlresolver.Result{}, false
+		span.AddField("error", err.Error())
+		return urlresolver.Result{}, false, err
 	}
-	return result, true
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) {
+		m.StoredAt = entry.StoredAt
+		m.OriginalResolvedURL = entry.OriginalResolvedURL
+		m.ContentType = entry.ContentType
+		m.Interstitial = entry.Interstitial
+	})
+	return entry.Result, true, nil
 }
 
 // Name returns the name of the cache, for instrumentation purposes.
@@ -82,3 +121,41 @@ func (c *RedisCache) Name() string {
 func redisCacheKey(key string) string {
 	return fmt.Sprintf("cache:%s:%x", redisCacheVersion, sha256.Sum256([]byte(key)))
 }
+
+// NamespacedCache is a Cache implementation that prefixes every key with a
+// namespace, so that one underlying cache may be shared by several resolvers
+// whose results must be kept separate.
+type NamespacedCache struct {
+	cache     Cache
+	namespace string
+}
+
+var _ Cache = &NamespacedCache{} // NamespacedCache implements Cache
+
+// NewNamespacedCache creates a new NamespacedCache.
+func NewNamespacedCache(cache Cache, namespace string) *NamespacedCache {
+	return &NamespacedCache{
+		cache:     cache,
+		namespace: namespace,
+	}
+}
+
+// Add adds a Result to the underlying cache under the namespaced key.
+func (c *NamespacedCache) Add(ctx context.Context, key string, value urlresolver.Result) error {
+	return c.cache.Add(ctx, c.key(key), value)
+}
+
+// Get gets a Result from the underlying cache using the namespaced key.
+func (c *NamespacedCache) Get(ctx context.Context, key string) (urlresolver.Result, bool, error) {
+	return c.cache.Get(ctx, c.key(key))
+}
+
+// Name returns the name of the underlying cache, for instrumentation
+// purposes.
+func (c *NamespacedCache) Name() string {
+	return c.cache.Name()
+}
+
+func (c *NamespacedCache) key(key string) string {
+	return c.namespace + ":" + key
+}
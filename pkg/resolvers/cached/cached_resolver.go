@@ -6,6 +6,8 @@ import (
 	"github.com/honeycombio/beeline-go"
 
 	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
 )
 
 // Resolver is a Resolver implementation that caches its results.
@@ -26,16 +28,32 @@ func NewResolver(resolver urlresolver.Interface, cache Cache) *Resolver {
 func (c *Resolver) Resolve(ctx context.Context, url string) (urlresolver.Result, error) {
 	beeline.AddField(ctx, "resolver.cache_name", c.cache.Name())
 
-	if result, ok := c.cache.Get(ctx, url); ok {
-		beeline.AddField(ctx, "resolver.cache_result", "hit")
+	// results depend on some per-request options as well as the URL
+	key := resolveopts.CacheKey(ctx, url)
+
+	result, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		beeline.AddField(ctx, "resolver.cache_error", err.Error())
+	}
+	if ok {
+		beeline.AddField(ctx, "resolver.cache_result", resultmeta.CacheHit)
+		resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.CacheResult = resultmeta.CacheHit })
 		return result, nil
 	}
 
-	result, err := c.resolver.Resolve(ctx, url)
-	if err == nil {
-		c.cache.Add(ctx, url, result)
+	// a recorder is needed to tell whether the result may be cached
+	if resultmeta.FromContext(ctx) == nil {
+		ctx, _ = resultmeta.NewContext(ctx)
+	}
+	result, err = c.resolver.Resolve(ctx, url)
+
+	// a page disallowed by robots.txt is never fetched, so its result lacks
+	// a title that it would otherwise have had if robots.txt changes
+	if err == nil && !resultmeta.FromContext(ctx).Meta().RobotsDisallowed {
+		_ = c.cache.Add(ctx, key, result)
 	}
 
-	beeline.AddField(ctx, "resolver.cache_result", "miss")
+	beeline.AddField(ctx, "resolver.cache_result", resultmeta.CacheMiss)
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.CacheResult = resultmeta.CacheMiss })
 	return result, err
 }
@@ -15,6 +15,8 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
 )
 
 func TestCachedResolver(t *testing.T) {
@@ -53,3 +55,179 @@ func TestCachedResolver(t *testing.T) {
 	}
 	assert.Equal(t, int64(1), counter, "expected only 1 total request to upstream")
 }
+
+func TestCachedResolverOptions(t *testing.T) {
+	t.Parallel()
+
+	var counter int64
+	resolver := NewResolver(countingResolver{&counter}, &fakeCache{})
+
+	noTitle := resolveopts.Default()
+	noTitle.FetchTitle = false
+	shortTimeout := resolveopts.Default()
+	shortTimeout.Timeout = time.Second
+
+	ctx := context.Background()
+	for _, ctx := range []context.Context{
+		ctx,
+		resolveopts.NewContext(ctx, noTitle),
+		// options that don't affect results share cache entries
+		resolveopts.NewContext(ctx, shortTimeout),
+		ctx,
+		resolveopts.NewContext(ctx, noTitle),
+	} {
+		_, err := resolver.Resolve(ctx, "https://example.com")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&counter), "expected results to be cached separately per options")
+}
+
+// countingResolver counts the number of times it is called.
+type countingResolver struct {
+	counter *int64
+}
+
+func (r countingResolver) Resolve(_ context.Context, givenURL string) (urlresolver.Result, error) {
+	atomic.AddInt64(r.counter, 1)
+	return urlresolver.Result{ResolvedURL: givenURL}, nil
+}
+
+func TestCachedResolverRobotsDisallowed(t *testing.T) {
+	t.Parallel()
+
+	var counter int64
+	resolver := NewResolver(disallowedResolver{countingResolver{&counter}}, &fakeCache{})
+
+	// results for pages disallowed by robots.txt lack titles, so they are
+	// never cached, with or without a recorder in the context
+	for i := 0; i < 2; i++ {
+		ctx, rec := resultmeta.NewContext(context.Background())
+		_, err := resolver.Resolve(ctx, "https://example.com")
+		assert.NoError(t, err)
+		assert.True(t, rec.Meta().RobotsDisallowed)
+		_, err = resolver.Resolve(context.Background(), "https://example.com")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(4), atomic.LoadInt64(&counter), "expected disallowed results not to be cached")
+}
+
+// disallowedResolver records that every result is disallowed by robots.txt.
+type disallowedResolver struct {
+	resolver urlresolver.Interface
+}
+
+func (r disallowedResolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.RobotsDisallowed = true })
+	return r.resolver.Resolve(ctx, givenURL)
+}
+
+func TestRedisCacheStoredAt(t *testing.T) {
+	t.Parallel()
+
+	redisSrv, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer redisSrv.Close()
+
+	redisClient := redis.NewClient(&redis.Options{Addr: redisSrv.Addr()})
+	c := NewRedisCache(cache.New(&cache.Options{Redis: redisClient}), 10*time.Minute)
+	storedAt := time.Date(2024, 3, 1, 12, 30, 15, 0, time.UTC)
+	c.now = func() time.Time { return storedAt }
+
+	value := urlresolver.Result{ResolvedURL: "https://example.com", Title: "title"}
+	assert.NoError(t, c.Add(context.Background(), "key", value))
+
+	ctx, rec := resultmeta.NewContext(context.Background())
+	result, ok, err := c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, value, result)
+	assert.True(t, storedAt.Equal(rec.Meta().StoredAt))
+
+	// misses record nothing
+	ctx, rec = resultmeta.NewContext(context.Background())
+	_, ok, err = c.Get(ctx, "missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.True(t, rec.Meta().StoredAt.IsZero())
+}
+
+func TestRedisCacheLegacyEntries(t *testing.T) {
+	t.Parallel()
+
+	redisSrv, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer redisSrv.Close()
+
+	redisCache := cache.New(&cache.Options{Redis: redis.NewClient(&redis.Options{Addr: redisSrv.Addr()})})
+	c := NewRedisCache(redisCache, 10*time.Minute)
+	ctx := context.Background()
+	value := urlresolver.Result{ResolvedURL: "https://example.com", Title: "title", IntermediateURLs: []string{"https://t.co/foo"}}
+
+	// bare results stored before metadata was cached are still hits
+	assert.NoError(t, redisCache.Set(&cache.Item{Key: redisCacheKey("legacy"), Value: value}))
+	ctx, rec := resultmeta.NewContext(ctx)
+	result, ok, err := c.Get(ctx, "legacy")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, value, result)
+	assert.True(t, rec.Meta().StoredAt.IsZero())
+
+	// and new entries may be read as bare results
+	assert.NoError(t, c.Add(ctx, "new", value))
+	var legacy urlresolver.Result
+	assert.NoError(t, redisCache.Get(ctx, redisCacheKey("new"), &legacy))
+	assert.Equal(t, value, legacy)
+}
+
+func TestRedisCacheMeta(t *testing.T) {
+	t.Parallel()
+
+	redisSrv, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer redisSrv.Close()
+
+	redisClient := redis.NewClient(&redis.Options{Addr: redisSrv.Addr()})
+	c := NewRedisCache(cache.New(&cache.Options{Redis: redisClient}), 10*time.Minute)
+
+	value := urlresolver.Result{ResolvedURL: "https://example.com/report.pdf", Title: "Annual Report"}
+	ctx, rec := resultmeta.NewContext(context.Background())
+	rec.Update(func(m *resultmeta.Meta) {
+		m.OriginalResolvedURL = "https://example.com/amp/report.pdf"
+		m.ContentType = "application/pdf"
+		m.Interstitial = true
+	})
+	assert.NoError(t, c.Add(ctx, "key", value))
+
+	ctx, rec = resultmeta.NewContext(context.Background())
+	result, ok, err := c.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, value, result)
+	assert.Equal(t, "https://example.com/amp/report.pdf", rec.Meta().OriginalResolvedURL)
+	assert.Equal(t, "application/pdf", rec.Meta().ContentType)
+	assert.True(t, rec.Meta().Interstitial)
+}
+
+func TestNamespacedCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := &fakeCache{}
+	a := NewNamespacedCache(backend, "a")
+	b := NewNamespacedCache(backend, "b")
+
+	assert.NoError(t, a.Add(ctx, "key", urlresolver.Result{ResolvedURL: "a"}))
+	assert.NoError(t, b.Add(ctx, "key", urlresolver.Result{ResolvedURL: "b"}))
+
+	result, ok, err := a.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", result.ResolvedURL)
+
+	result, ok, err = b.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "b", result.ResolvedURL)
+
+	assert.Equal(t, "fake", a.Name())
+}
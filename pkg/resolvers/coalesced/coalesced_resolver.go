@@ -8,6 +8,8 @@ import (
 	"golang.org/x/sync/singleflight"
 
 	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
 )
 
 // Resolver is a urlresolver.Interface implementation that coalesces concurrent
@@ -35,12 +37,26 @@ func (c *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Re
 		return urlresolver.Result{}, err
 	}
 
-	result, err, shared := c.group.Do(canonicalURL, func() (interface{}, error) {
-		return c.resolver.Resolve(ctx, canonicalURL)
+	// only requests with identical options may share a result
+	key := resolveopts.CoalesceKey(ctx, canonicalURL)
+
+	v, err, shared := c.group.Do(key, func() (interface{}, error) {
+		// Record metadata separately from the caller's context, so that it
+		// can be shared with every coalesced caller.
+		ctx, rec := resultmeta.NewContext(ctx)
+		result, err := c.resolver.Resolve(ctx, canonicalURL)
+		return coalescedResult{result, rec.Meta()}, err
 	})
+	cr := v.(coalescedResult)
 
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { *m = cr.meta })
 	beeline.AddField(ctx, "resolver.request_coalesced", shared)
-	return result.(urlresolver.Result), err
+	return cr.result, err
+}
+
+type coalescedResult struct {
+	result urlresolver.Result
+	meta   resultmeta.Meta
 }
 
 func canonicalize(givenURL string) (string, error) {
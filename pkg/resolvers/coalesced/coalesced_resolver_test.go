@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
 )
 
 func TestSingleFlightResolver(t *testing.T) {
@@ -49,6 +50,36 @@ func TestSingleFlightResolver(t *testing.T) {
 	assert.Equal(t, int64(1), counter, "expected only 1 total request to upstream")
 }
 
+func TestSingleFlightResolverOptions(t *testing.T) {
+	t.Parallel()
+
+	var counter int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&counter, 1)
+		<-time.After(25 * time.Millisecond)
+		w.Write([]byte(`<title>title</title>`))
+	}))
+	defer srv.Close()
+
+	resolver := New(urlresolver.New(http.DefaultTransport, 0))
+
+	// concurrent requests with different options must not share results
+	shortTimeout := resolveopts.Default()
+	shortTimeout.Timeout = time.Second
+	var wg sync.WaitGroup
+	for _, opts := range []resolveopts.Options{resolveopts.Default(), shortTimeout} {
+		ctx := resolveopts.NewContext(context.Background(), opts)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := resolver.Resolve(ctx, srv.URL)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(2), atomic.LoadInt64(&counter), "expected requests with different options not to be coalesced")
+}
+
 func TestSingleFlightResolverInvalidURL(t *testing.T) {
 	t.Parallel()
 	resolver := New(urlresolver.New(http.DefaultTransport, 0))
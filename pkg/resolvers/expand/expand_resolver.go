@@ -0,0 +1,132 @@
+package expand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+	"github.com/mccutchen/urlresolverapi/pkg/response"
+)
+
+// DefaultShorteners is a list of well-known URL shortener domains.
+var DefaultShorteners = []string{
+	"bit.ly",
+	"buff.ly",
+	"dlvr.it",
+	"fb.me",
+	"goo.gl",
+	"ift.tt",
+	"is.gd",
+	"lnkd.in",
+	"nyti.ms",
+	"ow.ly",
+	"t.co",
+	"tinyurl.com",
+	"trib.al",
+	"wp.me",
+}
+
+// ErrTooManyRedirects is returned when a URL redirects through more
+// shorteners than allowed by the request's resolveopts.Options.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// Resolver is a urlresolver.Interface implementation that only follows
+// redirects through known URL shortener domains. It stops at the first URL
+// that is not on a shortener domain, without fetching it, and never fetches
+// a title.
+type Resolver struct {
+	client     *http.Client
+	shorteners hostmatch.List
+	timeout    time.Duration
+}
+
+var _ urlresolver.Interface = &Resolver{} // Resolver implements urlresolver.Interface
+
+// New creates a new expand Resolver that uses the given transport to follow
+// redirects through the given shortener domains, with an overall timeout.
+func New(transport http.RoundTripper, shorteners hostmatch.List, timeout time.Duration) *Resolver {
+	return &Resolver{
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				// redirects are followed manually, one hop at a time
+				return http.ErrUseLastResponse
+			},
+		},
+		shorteners: shorteners,
+		timeout:    timeout,
+	}
+}
+
+// Resolve expands a URL by following redirects through known shorteners.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	current, err := url.Parse(givenURL)
+	if err != nil {
+		return urlresolver.Result{}, err
+	}
+
+	maxRedirects := resolveopts.FromContext(ctx).MaxRedirects
+
+	var intermediateURLs []string
+	for r.shorteners.Match(current.Hostname()) {
+		if len(intermediateURLs) >= maxRedirects {
+			err = ErrTooManyRedirects
+			break
+		}
+
+		var next *url.URL
+		next, err = r.nextHop(ctx, current)
+		if err != nil || next == nil {
+			break
+		}
+		intermediateURLs = append(intermediateURLs, current.String())
+		current = next
+	}
+
+	beeline.AddField(ctx, "expand.hop_count", len(intermediateURLs))
+	return urlresolver.Result{
+		ResolvedURL:      urlresolver.Canonicalize(current),
+		IntermediateURLs: intermediateURLs,
+	}, err
+}
+
+// nextHop requests the given URL and returns the URL it redirects to, or nil
+// if it does not redirect.
+func (r *Resolver) nextHop(ctx context.Context, u *url.URL) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// drain a bounded amount of the body to allow connection reuse, but
+	// otherwise ignore it
+	_, _ = io.CopyN(io.Discard, resp.Body, 4*1024)
+	resp.Body.Close()
+
+	if !response.IsRedirect(resp.StatusCode) {
+		return nil, nil
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect from %s: %w", u, err)
+	}
+	return loc, nil
+}
@@ -0,0 +1,102 @@
+//nolint:errcheck
+package expand
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/hostmatch"
+	"github.com/mccutchen/urlresolverapi/pkg/resolveopts"
+)
+
+func TestExpandResolver(t *testing.T) {
+	t.Parallel()
+
+	// the destination server is addressed as "localhost", which is not a
+	// known shortener, so it should never receive a request
+	var destCounter int64
+	destSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&destCounter, 1)
+		w.Write([]byte(`<title>title</title>`))
+	}))
+	defer destSrv.Close()
+	destURL := strings.Replace(destSrv.URL, "127.0.0.1", "localhost", 1)
+
+	// the shortener server is addressed as "127.0.0.1"
+	shortSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, destURL+"/article?utm_source=foo", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/slow":
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-r.Context().Done():
+			}
+		default:
+			w.Write([]byte(`<title>not found</title>`))
+		}
+	}))
+	defer shortSrv.Close()
+
+	resolver := New(http.DefaultTransport, hostmatch.List{hostmatch.MustParse("127.0.0.1")}, 25*time.Millisecond)
+
+	t.Run("follows redirects through shorteners only", func(t *testing.T) {
+		result, err := resolver.Resolve(context.Background(), shortSrv.URL+"/a")
+		assert.NoError(t, err)
+		assert.Equal(t, urlresolver.Result{
+			ResolvedURL:      destURL + "/article",
+			IntermediateURLs: []string{shortSrv.URL + "/a", shortSrv.URL + "/b"},
+		}, result)
+		assert.Equal(t, int64(0), atomic.LoadInt64(&destCounter))
+	})
+
+	t.Run("non-shortener urls are not fetched", func(t *testing.T) {
+		result, err := resolver.Resolve(context.Background(), destURL+"/foo?utm_source=bar")
+		assert.NoError(t, err)
+		assert.Equal(t, urlresolver.Result{ResolvedURL: destURL + "/foo"}, result)
+		assert.Equal(t, int64(0), atomic.LoadInt64(&destCounter))
+	})
+
+	t.Run("shortener url that does not redirect", func(t *testing.T) {
+		result, err := resolver.Resolve(context.Background(), shortSrv.URL+"/missing")
+		assert.NoError(t, err)
+		assert.Equal(t, urlresolver.Result{ResolvedURL: shortSrv.URL + "/missing"}, result)
+	})
+
+	t.Run("redirect loops are stopped", func(t *testing.T) {
+		result, err := resolver.Resolve(context.Background(), shortSrv.URL+"/loop")
+		assert.ErrorIs(t, err, ErrTooManyRedirects)
+		assert.Equal(t, shortSrv.URL+"/loop", result.ResolvedURL)
+		assert.Len(t, result.IntermediateURLs, resolveopts.DefaultMaxRedirects)
+	})
+
+	t.Run("redirect limit from options", func(t *testing.T) {
+		ctx := resolveopts.NewContext(context.Background(), resolveopts.Options{MaxRedirects: 1})
+		result, err := resolver.Resolve(ctx, shortSrv.URL+"/loop")
+		assert.ErrorIs(t, err, ErrTooManyRedirects)
+		assert.Len(t, result.IntermediateURLs, 1)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		result, err := resolver.Resolve(context.Background(), shortSrv.URL+"/slow")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, shortSrv.URL+"/slow", result.ResolvedURL)
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		_, err := resolver.Resolve(context.Background(), "%%")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,24 @@
+// Package response implements helpers for inspecting and trimming the HTTP
+// responses seen by the transports that make up the resolver chain.
+package response
+
+import "net/http"
+
+// IsRedirect reports whether the given status code is a redirect that is
+// followed while resolving a URL.
+func IsRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// DropBody closes the response's body and replaces it with http.NoBody, for
+// responses whose bodies are of no further use to the resolver.
+func DropBody(resp *http.Response) {
+	resp.Body.Close()
+	resp.Body = http.NoBody
+	resp.ContentLength = 0
+}
@@ -0,0 +1,49 @@
+package response
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRedirect(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[int]bool{
+		http.StatusOK:                false,
+		http.StatusMovedPermanently:  true,
+		http.StatusFound:             true,
+		http.StatusSeeOther:          true,
+		http.StatusNotModified:       false,
+		http.StatusTemporaryRedirect: true,
+		http.StatusPermanentRedirect: true,
+		http.StatusNotFound:          false,
+	}
+	for code, want := range testCases {
+		assert.Equal(t, want, IsRedirect(code), "status %d", code)
+	}
+}
+
+func TestDropBody(t *testing.T) {
+	t.Parallel()
+
+	body := &closeRecorder{Reader: strings.NewReader("body")}
+	resp := &http.Response{Body: body, ContentLength: 4}
+	DropBody(resp)
+	assert.True(t, body.closed)
+	assert.Equal(t, http.NoBody, resp.Body)
+	assert.Equal(t, int64(0), resp.ContentLength)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
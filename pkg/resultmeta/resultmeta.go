@@ -0,0 +1,100 @@
+/*
+Package resultmeta carries information about how a resolve result was
+produced through the request context, so that resolvers deep in the resolver
+chain can report it to callers further up the chain without changing the
+urlresolver.Interface signature.
+
+Callers that care about the metadata create a Recorder with NewContext before
+calling Resolve, and then inspect it afterwards:
+
+	ctx, rec := resultmeta.NewContext(ctx)
+	result, err := resolver.Resolve(ctx, givenURL)
+	if rec.Meta().CacheResult == resultmeta.CacheHit {
+		...
+	}
+
+Resolvers record metadata via FromContext, which is safe to use even when no
+Recorder was added to the context.
+*/
+package resultmeta
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache results.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Meta describes how a resolve result was produced.
+type Meta struct {
+	// CacheResult is CacheHit or CacheMiss if the result passed through a
+	// cache, or empty otherwise.
+	CacheResult string
+
+	// StoredAt is when a cached result was originally stored, if known.
+	StoredAt time.Time
+
+	// ContentType is the media type of the final response, if known.
+	ContentType string
+
+	// OriginalResolvedURL is the resolved URL before it was mapped to a
+	// canonical URL, if it was.
+	OriginalResolvedURL string
+
+	// Interstitial indicates that the result stops short of an interstitial
+	// (e.g. a cookie consent wall) that could not be bypassed.
+	Interstitial bool
+
+	// RobotsDisallowed indicates that the title was omitted because the
+	// resolved URL is disallowed by its host's robots.txt.
+	RobotsDisallowed bool
+}
+
+// Recorder records Meta for a single resolve request. A nil *Recorder is
+// valid and discards all updates.
+type Recorder struct {
+	mu   sync.Mutex
+	meta Meta
+}
+
+type recorderKeyType int
+
+const recorderKey = recorderKeyType(1)
+
+// NewContext returns a new context carrying a new Recorder.
+func NewContext(ctx context.Context) (context.Context, *Recorder) {
+	rec := &Recorder{}
+	return context.WithValue(ctx, recorderKey, rec), rec
+}
+
+// FromContext returns the Recorder carried by the context, or nil if there
+// is none.
+func FromContext(ctx context.Context) *Recorder {
+	rec, _ := ctx.Value(recorderKey).(*Recorder)
+	return rec
+}
+
+// Update calls fn to modify the recorded Meta.
+func (r *Recorder) Update(fn func(*Meta)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.meta)
+}
+
+// Meta returns a copy of the recorded Meta.
+func (r *Recorder) Meta() Meta {
+	if r == nil {
+		return Meta{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.meta
+}
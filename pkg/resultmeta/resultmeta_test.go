@@ -0,0 +1,29 @@
+package resultmeta
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	ctx, rec := NewContext(context.Background())
+	assert.Same(t, rec, FromContext(ctx))
+
+	FromContext(ctx).Update(func(m *Meta) { m.CacheResult = CacheHit })
+	assert.Equal(t, Meta{CacheResult: CacheHit}, rec.Meta())
+}
+
+func TestNilRecorder(t *testing.T) {
+	t.Parallel()
+
+	rec := FromContext(context.Background())
+	assert.Nil(t, rec)
+
+	// updates are safely discarded
+	rec.Update(func(m *Meta) { m.CacheResult = CacheHit })
+	assert.Equal(t, Meta{}, rec.Meta())
+}
@@ -0,0 +1,347 @@
+/*
+Package retrytransport implements an http.RoundTripper that makes outgoing
+requests more resilient to transient upstream failures.
+
+Idempotent requests that fail with a connection error or a 502, 503, or 504
+response are retried with jittered exponential backoff, as long as the retry
+can be made before the request's deadline.
+
+Optionally, if a request takes longer than a given percentile of recent
+request latencies, a hedged duplicate request is sent, and whichever request
+succeeds first wins.
+*/
+package retrytransport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+	"github.com/honeycombio/beeline-go/trace"
+)
+
+// Defaults for Options.
+const (
+	DefaultMaxRetries    = 2
+	DefaultBackoff       = 100 * time.Millisecond
+	DefaultMaxBackoff    = 2 * time.Second
+	DefaultHedgeMinDelay = 50 * time.Millisecond
+)
+
+const (
+	// latencyWindow is the number of recent request latencies used to
+	// compute the hedging threshold
+	latencyWindow = 256
+
+	// minLatencySamples is the number of request latencies required before
+	// requests are hedged
+	minLatencySamples = 20
+)
+
+// Options configure a Transport.
+type Options struct {
+	// MaxRetries is the maximum number of times a request is retried. If 0,
+	// requests are not retried.
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, which is doubled after
+	// each subsequent retry up to MaxBackoff. Delays are jittered.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// HedgePercentile is the percentile of recent request latencies (e.g.
+	// 0.95) after which a hedged request is sent. If 0, requests are not
+	// hedged.
+	HedgePercentile float64
+
+	// HedgeMinDelay is the minimum time to wait before sending a hedged
+	// request.
+	HedgeMinDelay time.Duration
+}
+
+// Transport is an http.RoundTripper that retries and optionally hedges
+// idempotent requests.
+type Transport struct {
+	transport http.RoundTripper
+	opts      Options
+	latencies *latencyTracker
+}
+
+// New creates a new Transport that passes requests on to the given
+// transport. Zero backoff options are replaced with their defaults.
+func New(transport http.RoundTripper, opts Options) *Transport {
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.HedgeMinDelay <= 0 {
+		opts.HedgeMinDelay = DefaultHedgeMinDelay
+	}
+	return &Transport{
+		transport: transport,
+		opts:      opts,
+		latencies: &latencyTracker{},
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) {
+		return t.transport.RoundTrip(req)
+	}
+
+	ctx, span := beeline.StartSpan(req.Context(), "retrytransport.round_trip")
+	defer span.Send()
+	req = req.WithContext(ctx)
+
+	for attempt := 0; ; attempt++ {
+		span.AddField("retry.attempts", attempt+1)
+
+		resp, err := t.roundTrip(req, span)
+		if attempt >= t.opts.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		// give up if the retry could not be made before the deadline
+		wait := t.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			span.AddField("retry.deadline_exceeded", true)
+			return resp, err
+		}
+		if resp != nil {
+			discard(resp)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// roundTrip makes a single attempt at the request, which may involve a
+// hedged duplicate request.
+func (t *Transport) roundTrip(req *http.Request, span *trace.Span) (*http.Response, error) {
+	hedgeDelay, hedge := t.hedgeDelay()
+	if !hedge {
+		return t.timedRoundTrip(req)
+	}
+
+	type result struct {
+		resp   *http.Response
+		err    error
+		hedged bool
+	}
+	results := make(chan result, 2)
+	cancels := make(map[bool]context.CancelFunc, 2)
+	send := func(hedged bool) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[hedged] = cancel
+		go func() {
+			resp, err := t.timedRoundTrip(req.Clone(ctx))
+			results <- result{resp, err, hedged}
+		}()
+	}
+
+	send(false)
+	inflight := 1
+	timer := time.NewTimer(hedgeDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			span.AddField("retry.hedged", true)
+			span.AddField("retry.hedge_delay_ms", hedgeDelay.Milliseconds())
+			send(true)
+			inflight++
+		case res := <-results:
+			inflight--
+			if inflight > 0 && shouldRetry(res.resp, res.err) {
+				// the other request may yet succeed
+				if res.resp != nil {
+					discard(res.resp)
+				}
+				cancels[res.hedged]()
+				continue
+			}
+
+			// cancel any request still in flight, and clean up after it
+			// in the background
+			for hedged, cancel := range cancels {
+				if hedged != res.hedged {
+					cancel()
+				}
+			}
+			if inflight > 0 {
+				go func() {
+					if other := <-results; other.resp != nil {
+						discard(other.resp)
+					}
+				}()
+			}
+
+			if len(cancels) > 1 {
+				span.AddField("retry.hedge_won", res.hedged)
+			}
+			cancel := cancels[res.hedged]
+			if res.resp == nil {
+				cancel()
+				return nil, res.err
+			}
+			// the winning request's context must remain valid until its
+			// response body has been read
+			res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, cancel: cancel}
+			return res.resp, res.err
+		}
+	}
+}
+
+// timedRoundTrip makes a request, recording its latency if it succeeds.
+func (t *Transport) timedRoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	if err == nil && !isRetryableStatus(resp.StatusCode) && t.opts.HedgePercentile > 0 {
+		t.latencies.add(time.Since(start))
+	}
+	return resp, err
+}
+
+// hedgeDelay returns how long to wait before sending a hedged request, and
+// whether requests should be hedged at all.
+func (t *Transport) hedgeDelay() (time.Duration, bool) {
+	if t.opts.HedgePercentile <= 0 {
+		return 0, false
+	}
+	delay, ok := t.latencies.percentile(t.opts.HedgePercentile)
+	if !ok {
+		return 0, false
+	}
+	if delay < t.opts.HedgeMinDelay {
+		delay = t.opts.HedgeMinDelay
+	}
+	return delay, true
+}
+
+// backoff returns the jittered delay before the given retry attempt.
+func (t *Transport) backoff(attempt int) time.Duration {
+	wait := t.opts.Backoff << attempt
+	if wait <= 0 || wait > t.opts.MaxBackoff {
+		wait = t.opts.MaxBackoff
+	}
+	return wait/2 + time.Duration(rand.Int64N(int64(wait)/2+1))
+}
+
+// isIdempotent reports whether a request may safely be sent more than once.
+func isIdempotent(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		(req.Body == nil || req.Body == http.NoBody)
+}
+
+// shouldRetry reports whether a request with the given outcome should be
+// retried.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return isRetryableError(err)
+	}
+	return isRetryableStatus(resp.StatusCode)
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableError reports whether an error indicates a transient connection
+// failure. Errors like timeouts and refusals to connect to unsafe addresses
+// are not retried.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// discard drains a bounded amount of a response body, to allow connection
+// reuse, and closes it.
+func discard(resp *http.Response) {
+	_, _ = io.CopyN(io.Discard, resp.Body, 4*1024)
+	resp.Body.Close()
+}
+
+// cancelOnClose cancels a request's context when its response body is
+// closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// latencyTracker tracks the latencies of recent requests.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencyWindow]time.Duration
+	next    int
+	count   int
+}
+
+func (l *latencyTracker) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencyWindow
+	if l.count < latencyWindow {
+		l.count++
+	}
+}
+
+// percentile returns the given percentile of recent latencies, or false if
+// there are not yet enough samples.
+func (l *latencyTracker) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	if l.count < minLatencySamples {
+		l.mu.Unlock()
+		return 0, false
+	}
+	samples := make([]time.Duration, l.count)
+	copy(samples, l.samples[:l.count])
+	l.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(p * float64(len(samples)-1))
+	if idx >= len(samples) {
+		idx = len(samples) - 1
+	}
+	return samples[idx], true
+}
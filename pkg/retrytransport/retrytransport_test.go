@@ -0,0 +1,219 @@
+//nolint:errcheck
+package retrytransport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTripFunc adapts a function into an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newResponse(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     http.Header{},
+	}
+}
+
+// sequenceTransport returns the given outcomes in order, counting requests.
+func sequenceTransport(count *int32, outcomes ...interface{}) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n := atomic.AddInt32(count, 1)
+		switch outcome := outcomes[int(n)-1].(type) {
+		case int:
+			return newResponse(outcome, ""), nil
+		case error:
+			return nil, outcome
+		default:
+			panic("invalid outcome")
+		}
+	})
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{MaxRetries: 2, Backoff: time.Millisecond}
+	connReset := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+
+	testCases := map[string]struct {
+		method       string
+		outcomes     []interface{}
+		wantCode     int
+		wantErr      error
+		wantRequests int32
+	}{
+		"success is not retried": {
+			outcomes:     []interface{}{200},
+			wantCode:     200,
+			wantRequests: 1,
+		},
+		"503 is retried": {
+			outcomes:     []interface{}{503, 200},
+			wantCode:     200,
+			wantRequests: 2,
+		},
+		"connection reset is retried": {
+			outcomes:     []interface{}{connReset, 502, 200},
+			wantCode:     200,
+			wantRequests: 3,
+		},
+		"retries are limited": {
+			outcomes:     []interface{}{504, 504, 504},
+			wantCode:     504,
+			wantRequests: 3,
+		},
+		"client errors are not retried": {
+			outcomes:     []interface{}{404},
+			wantCode:     404,
+			wantRequests: 1,
+		},
+		"500 is not retried": {
+			outcomes:     []interface{}{500},
+			wantCode:     500,
+			wantRequests: 1,
+		},
+		"timeouts are not retried": {
+			outcomes:     []interface{}{context.DeadlineExceeded},
+			wantErr:      context.DeadlineExceeded,
+			wantRequests: 1,
+		},
+		"other errors are not retried": {
+			outcomes:     []interface{}{errors.New("unsafe URL")},
+			wantErr:      errors.New("unsafe URL"),
+			wantRequests: 1,
+		},
+		"POST requests are not retried": {
+			method:       http.MethodPost,
+			outcomes:     []interface{}{503},
+			wantCode:     503,
+			wantRequests: 1,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var count int32
+			transport := New(sequenceTransport(&count, tc.outcomes...), opts)
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, _ := http.NewRequest(method, "http://example.com", nil)
+			resp, err := transport.RoundTrip(req)
+			if tc.wantErr != nil {
+				assert.Equal(t, tc.wantErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantCode, resp.StatusCode)
+			}
+			assert.Equal(t, tc.wantRequests, atomic.LoadInt32(&count))
+		})
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	t.Parallel()
+
+	var count int32
+	transport := New(sequenceTransport(&count, 503, 200), Options{
+		MaxRetries: 1,
+		Backoff:    time.Second,
+	})
+
+	// the backoff before the retry would exceed the deadline, so the
+	// original response is returned immediately
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+	resp, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+func TestHedging(t *testing.T) {
+	t.Parallel()
+
+	var (
+		count    int32
+		canceled int32
+	)
+	transport := New(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		// the first request hangs until canceled, the hedged request
+		// succeeds immediately
+		if atomic.AddInt32(&count, 1) == 1 {
+			<-req.Context().Done()
+			atomic.AddInt32(&canceled, 1)
+			return nil, req.Context().Err()
+		}
+		return newResponse(200, "hedged"), nil
+	}), Options{
+		HedgePercentile: 0.9,
+		HedgeMinDelay:   10 * time.Millisecond,
+	})
+
+	// requests are not hedged until enough latencies are recorded
+	_, ok := transport.hedgeDelay()
+	assert.False(t, ok)
+	for i := 0; i < minLatencySamples; i++ {
+		transport.latencies.add(time.Millisecond)
+	}
+	delay, ok := transport.hedgeDelay()
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, delay)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	resp, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "hedged", string(body))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	// the losing request is canceled
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&canceled) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	t.Parallel()
+
+	l := &latencyTracker{}
+	for i := 1; i <= 100; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	p50, ok := l.percentile(0.5)
+	assert.True(t, ok)
+	assert.Equal(t, 50*time.Millisecond, p50)
+	p99, _ := l.percentile(0.99)
+	assert.Equal(t, 99*time.Millisecond, p99)
+
+	// only the most recent samples are considered
+	for i := 0; i < latencyWindow; i++ {
+		l.add(time.Second)
+	}
+	p50, _ = l.percentile(0.5)
+	assert.Equal(t, time.Second, p50)
+}
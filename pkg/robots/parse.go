@@ -0,0 +1,156 @@
+package robots
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxRobotsSize is the maximum size of a robots.txt file that will be
+// parsed, per RFC 9309.
+const maxRobotsSize = 500 << 10
+
+// rules are the allow and disallow rules from a robots.txt file that apply
+// to a single user agent.
+type rules struct {
+	allow    []string
+	disallow []string
+}
+
+// allowAll and disallowAll are used when a robots.txt file is missing or
+// unavailable.
+var (
+	allowAll    = &rules{}
+	disallowAll = &rules{disallow: []string{"/"}}
+)
+
+// parse parses a robots.txt file, returning the rules that apply to the
+// given product token. Rules from every group naming the token are merged,
+// and the rules for "*" are used only if no group names it.
+func parse(r io.Reader, token string) *rules {
+	var (
+		matched, fallback rules
+		foundMatch        bool
+
+		// the user agents of the current group, which is complete once
+		// its first rule is seen
+		agents      []string
+		inAgentList bool
+	)
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxRobotsSize))
+	scanner.Buffer(make([]byte, 0, 4096), maxRobotsSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgentList {
+				agents = agents[:0]
+				inAgentList = true
+			}
+			agents = append(agents, productToken(value))
+		case "allow", "disallow":
+			inAgentList = false
+			if value == "" {
+				// an empty disallow rule allows everything, which is
+				// already the default
+				continue
+			}
+			for _, agent := range agents {
+				var target *rules
+				switch agent {
+				case token:
+					target = &matched
+					foundMatch = true
+				case "*":
+					target = &fallback
+				default:
+					continue
+				}
+				if key == "allow" {
+					target.allow = append(target.allow, value)
+				} else {
+					target.disallow = append(target.disallow, value)
+				}
+			}
+		}
+	}
+
+	if foundMatch {
+		return &matched
+	}
+	return &fallback
+}
+
+// productToken returns the lowercased product token from a user agent,
+// e.g. "examplebot" from "ExampleBot/1.0 (+https://example.com)".
+func productToken(userAgent string) string {
+	token, _, _ := strings.Cut(strings.TrimSpace(userAgent), " ")
+	token, _, _ = strings.Cut(token, "/")
+	return strings.ToLower(token)
+}
+
+// allowed reports whether the given path (including any query string) may
+// be fetched. The longest matching rule wins, and allow rules win ties.
+func (r *rules) allowed(path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+	allowLen, disallowLen := -1, -1
+	for _, pattern := range r.allow {
+		if len(pattern) > allowLen && match(pattern, path) {
+			allowLen = len(pattern)
+		}
+	}
+	for _, pattern := range r.disallow {
+		if len(pattern) > disallowLen && match(pattern, path) {
+			disallowLen = len(pattern)
+		}
+	}
+	return allowLen >= disallowLen
+}
+
+// match reports whether a robots.txt path pattern matches a path. Patterns
+// match path prefixes, may contain * wildcards, and may end with $ to match
+// the end of the path.
+func match(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || path == ""
+	}
+
+	// each wildcard may match any number of characters, so each remaining
+	// part matches at its first occurrence, except that an anchored
+	// pattern's final part must match the end of the path
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(path, part)
+		if i < 0 {
+			return false
+		}
+		path = path[i+len(part):]
+	}
+	if anchored {
+		return strings.HasSuffix(path, parts[last])
+	}
+	return strings.Contains(path, parts[last])
+}
@@ -0,0 +1,53 @@
+package robots
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+// Resolver is a urlresolver.Interface implementation that removes the title
+// from results whose resolved URL is disallowed by its host's robots.txt,
+// recording that it did so in the context's resultmeta.Recorder. It checks
+// every result, so that results resolved (and perhaps cached) before a page
+// was disallowed do not include its title afterwards.
+type Resolver struct {
+	checker  *Checker
+	resolver urlresolver.Interface
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(checker *Checker, resolver urlresolver.Interface) *Resolver {
+	return &Resolver{
+		checker:  checker,
+		resolver: resolver,
+	}
+}
+
+// Resolve resolves a URL, omitting the title if the resolved URL may not be
+// fetched.
+func (r *Resolver) Resolve(ctx context.Context, givenURL string) (urlresolver.Result, error) {
+	result, err := r.resolver.Resolve(ctx, givenURL)
+	if err != nil {
+		return result, err
+	}
+
+	parsed, parseErr := url.Parse(result.ResolvedURL)
+	if parseErr != nil {
+		return result, nil
+	}
+	// if robots.txt cannot be checked in time, err on the side of caution
+	allowed, checkErr := r.checker.Allowed(ctx, parsed)
+	if checkErr == nil && allowed {
+		return result, nil
+	}
+
+	beeline.AddField(ctx, "robots.disallowed", true)
+	resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.RobotsDisallowed = true })
+	result.Title = ""
+	return result, nil
+}
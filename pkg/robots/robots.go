@@ -0,0 +1,239 @@
+/*
+Package robots implements an opt-in compliance mode in which the final page
+of a resolved URL is only fetched for its title if the destination's
+robots.txt allows it.
+
+A Checker fetches, parses, and caches robots.txt files per host, following
+RFC 9309: a missing robots.txt (any 4xx response) allows everything, while
+an unreachable one (a 5xx response or network error) disallows everything
+until it can be fetched again.
+
+Redirects are always followed. When a page is disallowed, Transport makes a
+HEAD request instead of a GET, so that redirects are still discovered
+without fetching the page itself, and Resolver removes the title from the
+result and records the reason in the context's resultmeta.Recorder:
+
+	checker := robots.New(transport, robots.Options{UserAgent: "ExampleBot/1.0"})
+	resolver := robots.NewResolver(checker, urlresolver.New(robots.NewTransport(checker, transport), timeout))
+*/
+package robots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+	"golang.org/x/sync/singleflight"
+)
+
+// Defaults for Options.
+const (
+	DefaultTTL        = 24 * time.Hour
+	DefaultErrorTTL   = 10 * time.Minute
+	DefaultTimeout    = 5 * time.Second
+	DefaultMaxEntries = 10000
+)
+
+// maxRedirects is the maximum number of redirects followed when fetching a
+// robots.txt file, per RFC 9309.
+const maxRedirects = 5
+
+// ErrNoUserAgent is returned if a Checker is created without a user agent.
+var ErrNoUserAgent = errors.New("robots: user agent required")
+
+// Options configure a Checker.
+type Options struct {
+	// UserAgent is sent when fetching robots.txt files, and its product
+	// token (e.g. "examplebot" for "ExampleBot/1.0") selects the rules that
+	// apply.
+	UserAgent string
+
+	// TTL is how long a fetched (or missing) robots.txt file is cached.
+	TTL time.Duration
+
+	// ErrorTTL is how long an unreachable robots.txt file is cached, during
+	// which every page on the host is disallowed.
+	ErrorTTL time.Duration
+
+	// Timeout bounds each robots.txt fetch.
+	Timeout time.Duration
+
+	// MaxEntries is the maximum number of hosts cached.
+	MaxEntries int
+}
+
+// Checker checks URLs against their hosts' robots.txt files.
+type Checker struct {
+	client *http.Client
+	opts   Options
+	token  string
+	group  singleflight.Group
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	rules   *rules
+	expires time.Time
+}
+
+// New creates a new Checker that fetches robots.txt files using the given
+// transport.
+func New(transport http.RoundTripper, opts Options) (*Checker, error) {
+	if opts.UserAgent == "" {
+		return nil, ErrNoUserAgent
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.ErrorTTL <= 0 {
+		opts.ErrorTTL = DefaultErrorTTL
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	return &Checker{
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return http.ErrUseLastResponse
+				}
+				req.Header.Set("User-Agent", opts.UserAgent)
+				return nil
+			},
+		},
+		opts:    opts,
+		token:   productToken(opts.UserAgent),
+		now:     time.Now,
+		entries: make(map[string]entry),
+	}, nil
+}
+
+// Allowed reports whether the given URL may be fetched according to its
+// host's robots.txt. An error is returned only if the context is done
+// before the robots.txt could be fetched.
+func (c *Checker) Allowed(ctx context.Context, u *url.URL) (bool, error) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return true, nil
+	}
+
+	key := u.Scheme + "://" + u.Host
+	e, hit := c.get(key)
+	beeline.AddField(ctx, "robots.cache_hit", hit)
+	if !hit {
+		// the fetch must not be canceled just because the first of several
+		// coalesced callers gives up
+		ch := c.group.DoChan(key, func() (interface{}, error) {
+			return c.fill(context.WithoutCancel(ctx), u), nil
+		})
+		select {
+		case res := <-ch:
+			e = res.Val.(entry)
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allowed := e.rules.allowed(path)
+	if !allowed {
+		beeline.AddField(ctx, "robots.disallowed_url", u.String())
+	}
+	return allowed, nil
+}
+
+func (c *Checker) get(key string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[key]
+	if !found || !c.now().Before(e.expires) {
+		return entry{}, false
+	}
+	return e, true
+}
+
+// fill fetches a host's robots.txt and caches the result.
+func (c *Checker) fill(ctx context.Context, u *url.URL) entry {
+	ctx, span := beeline.StartSpan(ctx, "robots.fetch")
+	defer span.Send()
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	robotsURL := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	span.AddField("robots.url", robotsURL.String())
+
+	r, ttl, err := c.fetch(ctx, robotsURL)
+	if err != nil {
+		span.AddField("error", err.Error())
+	}
+
+	e := entry{rules: r, expires: c.now().Add(ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.opts.MaxEntries {
+		c.evict()
+	}
+	c.entries[u.Scheme+"://"+u.Host] = e
+	return e
+}
+
+// fetch fetches and parses a robots.txt file, returning the rules along with
+// how long they should be cached. Unreachable files disallow everything.
+func (c *Checker) fetch(ctx context.Context, robotsURL *url.URL) (*rules, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL.String(), nil)
+	if err != nil {
+		return disallowAll, c.opts.ErrorTTL, err
+	}
+	req.Header.Set("User-Agent", c.opts.UserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return disallowAll, c.opts.ErrorTTL, err
+	}
+	defer resp.Body.Close()
+	beeline.AddField(ctx, "robots.status", resp.StatusCode)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return parse(resp.Body, c.token), c.opts.TTL, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return disallowAll, c.opts.ErrorTTL, fmt.Errorf("robots.txt unavailable: status %d", resp.StatusCode)
+	default:
+		// missing files, including unfollowed redirects, allow everything
+		return allowAll, c.opts.TTL, nil
+	}
+}
+
+// evict removes expired entries, falling back to removing arbitrary
+// entries if the cache is still full. Must be called with the lock held.
+func (c *Checker) evict() {
+	now := c.now()
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.opts.MaxEntries {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
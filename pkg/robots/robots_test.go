@@ -0,0 +1,357 @@
+//nolint:errcheck
+package robots
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mccutchen/urlresolver"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+const testRobots = `
+# comments are ignored
+User-agent: *
+Disallow: /private
+Allow: /private/public
+
+User-agent: OtherBot
+User-Agent: examplebot  # groups may name several agents
+Disallow: /
+Allow: /$
+Allow: /*.html$
+Disallow: /search*q=
+
+Sitemap: https://example.com/sitemap.xml
+
+user-agent: ExampleBot
+disallow: /secret
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		token   string
+		allowed []string
+		denied  []string
+	}{
+		"matching groups are merged": {
+			token:   "examplebot",
+			allowed: []string{"/", "/page.html", "/dir/page.html", "/secret.html", "/robots.txt"},
+			denied:  []string{"/page", "/page.html?x=1", "/secret", "/search?q=foo", "/index.htm"},
+		},
+		"falls back to wildcard group": {
+			token:   "somebot",
+			allowed: []string{"/", "/public", "/private/public/page", "/secret"},
+			denied:  []string{"/private", "/private/page", "/privateer"},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := parse(strings.NewReader(testRobots), tc.token)
+			for _, path := range tc.allowed {
+				assert.True(t, r.allowed(path), "expected %s to be allowed", path)
+			}
+			for _, path := range tc.denied {
+				assert.False(t, r.allowed(path), "expected %s to be disallowed", path)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/foo", "/foobar", true},
+		{"/foo", "/bar/foo", false},
+		{"/foo$", "/foo", true},
+		{"/foo$", "/foo/", false},
+		{"/*.php", "/dir/index.php?x=1", true},
+		{"/*.php$", "/dir/index.php?x=1", false},
+		{"/*.php$", "/dir/index.php", true},
+		{"/a*b*c", "/a-b-c-d", true},
+		{"/a*b*c", "/a-c-b", false},
+		{"*", "/", true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, match(tc.pattern, tc.path), "match(%q, %q)", tc.pattern, tc.path)
+	}
+}
+
+func TestProductToken(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "examplebot", productToken("ExampleBot/1.0 (+https://example.com/bot)"))
+	assert.Equal(t, "examplebot", productToken("ExampleBot"))
+	assert.Equal(t, "*", productToken(" * "))
+}
+
+// robotsServer serves the given robots.txt response for each host, counting
+// robots.txt fetches and recording the method and path of other requests.
+type robotsServer struct {
+	mu       sync.Mutex
+	status   int
+	body     string
+	fetches  int
+	requests []string
+	agents   []string
+}
+
+func (s *robotsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == "/robots.txt" {
+		s.fetches++
+		s.agents = append(s.agents, r.UserAgent())
+		w.WriteHeader(s.status)
+		w.Write([]byte(s.body))
+		return
+	}
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	switch r.URL.Path {
+	case "/redirect":
+		http.Redirect(w, r, "/private/page", http.StatusFound)
+	case "/redirect-public":
+		http.Redirect(w, r, "/public", http.StatusFound)
+	default:
+		w.Write([]byte("<title>title</title>"))
+	}
+}
+
+func (s *robotsServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func (s *robotsServer) takeRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestChecker(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		status      int
+		body        string
+		wantAllowed bool
+		wantTTL     time.Duration
+	}{
+		"disallowed": {
+			status:      http.StatusOK,
+			body:        "User-agent: *\nDisallow: /private\n",
+			wantAllowed: false,
+			wantTTL:     time.Hour,
+		},
+		"allowed": {
+			status:      http.StatusOK,
+			body:        "User-agent: *\nDisallow: /elsewhere\n",
+			wantAllowed: true,
+			wantTTL:     time.Hour,
+		},
+		"missing robots.txt allows everything": {
+			status:      http.StatusNotFound,
+			wantAllowed: true,
+			wantTTL:     time.Hour,
+		},
+		"unavailable robots.txt disallows everything": {
+			status:      http.StatusServiceUnavailable,
+			wantAllowed: false,
+			wantTTL:     time.Minute,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			remote := &robotsServer{status: tc.status, body: tc.body}
+			srv := httptest.NewServer(remote)
+			t.Cleanup(srv.Close)
+
+			checker, err := New(http.DefaultTransport, Options{UserAgent: "ExampleBot/1.0", TTL: time.Hour, ErrorTTL: time.Minute})
+			assert.NoError(t, err)
+			now := time.Now()
+			checker.now = func() time.Time { return now }
+
+			u := mustParseURL(t, srv.URL+"/private/page")
+			for i := 0; i < 3; i++ {
+				allowed, err := checker.Allowed(context.Background(), u)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantAllowed, allowed)
+			}
+			assert.Equal(t, 1, remote.fetchCount(), "expected robots.txt to be cached")
+			assert.Equal(t, []string{"ExampleBot/1.0"}, remote.agents)
+
+			now = now.Add(tc.wantTTL - time.Millisecond)
+			checker.Allowed(context.Background(), u)
+			assert.Equal(t, 1, remote.fetchCount(), "expected cached robots.txt before TTL")
+
+			now = now.Add(time.Millisecond)
+			checker.Allowed(context.Background(), u)
+			assert.Equal(t, 2, remote.fetchCount(), "expected robots.txt to be fetched again after TTL")
+		})
+	}
+
+	t.Run("unreachable robots.txt disallows everything", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+
+		checker, err := New(http.DefaultTransport, Options{UserAgent: "ExampleBot"})
+		assert.NoError(t, err)
+		allowed, err := checker.Allowed(context.Background(), mustParseURL(t, srv.URL+"/"))
+		assert.NoError(t, err)
+		assert.False(t, allowed)
+	})
+
+	t.Run("user agent required", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := New(http.DefaultTransport, Options{})
+		assert.ErrorIs(t, err, ErrNoUserAgent)
+	})
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	remote := &robotsServer{status: http.StatusOK, body: "User-agent: *\nDisallow: /private\n"}
+	srv := httptest.NewServer(remote)
+	t.Cleanup(srv.Close)
+
+	checker, err := New(http.DefaultTransport, Options{UserAgent: "ExampleBot"})
+	assert.NoError(t, err)
+	resolver := NewResolver(checker, urlresolver.New(NewTransport(checker, http.DefaultTransport), 0))
+
+	testCases := []struct {
+		path           string
+		wantResolved   string
+		wantTitle      string
+		wantDisallowed bool
+		wantRequests   []string
+	}{
+		{
+			path:         "/public",
+			wantResolved: srv.URL + "/public",
+			wantTitle:    "title",
+			wantRequests: []string{"GET /public"},
+		},
+		{
+			path:           "/private/page",
+			wantResolved:   srv.URL + "/private/page",
+			wantDisallowed: true,
+			wantRequests:   []string{"HEAD /private/page"},
+		},
+		{
+			// redirects are followed to a disallowed page
+			path:           "/redirect",
+			wantResolved:   srv.URL + "/private/page",
+			wantDisallowed: true,
+			wantRequests:   []string{"GET /redirect", "HEAD /private/page"},
+		},
+		{
+			path:         "/redirect-public",
+			wantResolved: srv.URL + "/public",
+			wantTitle:    "title",
+			wantRequests: []string{"GET /redirect-public", "GET /public"},
+		},
+	}
+	for _, tc := range testCases {
+		ctx, rec := resultmeta.NewContext(context.Background())
+		result, err := resolver.Resolve(ctx, srv.URL+tc.path)
+		assert.NoError(t, err, tc.path)
+		assert.Equal(t, tc.wantResolved, result.ResolvedURL, tc.path)
+		assert.Equal(t, tc.wantTitle, result.Title, tc.path)
+		assert.Equal(t, tc.wantDisallowed, rec.Meta().RobotsDisallowed, tc.path)
+		assert.Equal(t, tc.wantRequests, remote.takeRequests(), tc.path)
+	}
+	assert.Equal(t, 1, remote.fetchCount())
+}
+
+// stubResolver returns a fixed result, as if from a cache.
+type stubResolver struct {
+	result urlresolver.Result
+}
+
+func (r stubResolver) Resolve(context.Context, string) (urlresolver.Result, error) {
+	return r.result, nil
+}
+
+func TestResolverCachedResults(t *testing.T) {
+	t.Parallel()
+
+	remote := &robotsServer{status: http.StatusOK, body: "User-agent: *\nDisallow: /private\n"}
+	srv := httptest.NewServer(remote)
+	t.Cleanup(srv.Close)
+
+	checker, err := New(http.DefaultTransport, Options{UserAgent: "ExampleBot"})
+	assert.NoError(t, err)
+
+	// titles resolved before a page was disallowed are not returned
+	resolver := NewResolver(checker, stubResolver{result: urlresolver.Result{ResolvedURL: srv.URL + "/private/page", Title: "cached title"}})
+	ctx, rec := resultmeta.NewContext(context.Background())
+	result, err := resolver.Resolve(ctx, srv.URL+"/private/page")
+	assert.NoError(t, err)
+	assert.Equal(t, "", result.Title)
+	assert.True(t, rec.Meta().RobotsDisallowed)
+	assert.Empty(t, remote.takeRequests())
+
+	resolver = NewResolver(checker, stubResolver{result: urlresolver.Result{ResolvedURL: srv.URL + "/public", Title: "cached title"}})
+	ctx, rec = resultmeta.NewContext(context.Background())
+	result, err = resolver.Resolve(ctx, srv.URL+"/public")
+	assert.NoError(t, err)
+	assert.Equal(t, "cached title", result.Title)
+	assert.False(t, rec.Meta().RobotsDisallowed)
+}
+
+func TestTransportNonGET(t *testing.T) {
+	t.Parallel()
+
+	remote := &robotsServer{status: http.StatusOK, body: "User-agent: *\nDisallow: /\n"}
+	srv := httptest.NewServer(remote)
+	t.Cleanup(srv.Close)
+
+	checker, err := New(http.DefaultTransport, Options{UserAgent: "ExampleBot"})
+	assert.NoError(t, err)
+	client := &http.Client{Transport: NewTransport(checker, http.DefaultTransport)}
+
+	resp, err := client.Post(srv.URL+"/page", "text/plain", strings.NewReader("body"))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "<title>title</title>", string(body))
+	assert.Equal(t, 0, remote.fetchCount())
+}
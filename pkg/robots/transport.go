@@ -0,0 +1,60 @@
+package robots
+
+import (
+	"net/http"
+
+	"github.com/honeycombio/beeline-go"
+
+	"github.com/mccutchen/urlresolverapi/pkg/response"
+	"github.com/mccutchen/urlresolverapi/pkg/resultmeta"
+)
+
+// Transport is an http.RoundTripper that does not fetch pages disallowed by
+// their hosts' robots.txt, checking each redirect hop against the robots.txt
+// of the host it points to.
+//
+// A disallowed GET request is sent as a HEAD request instead, so that a
+// redirect response is still followed, while any other response is
+// returned without a body and recorded in the context's resultmeta.Recorder.
+type Transport struct {
+	checker   *Checker
+	transport http.RoundTripper
+}
+
+// NewTransport creates a new Transport that checks requests with the given
+// Checker before passing them on to the given transport.
+func NewTransport(checker *Checker, transport http.RoundTripper) *Transport {
+	return &Transport{
+		checker:   checker,
+		transport: transport,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.transport.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	allowed, err := t.checker.Allowed(ctx, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if allowed {
+		return t.transport.RoundTrip(req)
+	}
+
+	beeline.AddField(ctx, "robots.head_url", req.URL.String())
+	headReq := req.Clone(ctx)
+	headReq.Method = http.MethodHead
+	resp, err := t.transport.RoundTrip(headReq)
+	if err != nil {
+		return resp, err
+	}
+	if !response.IsRedirect(resp.StatusCode) {
+		resultmeta.FromContext(ctx).Update(func(m *resultmeta.Meta) { m.RobotsDisallowed = true })
+		response.DropBody(resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,164 @@
+/*
+Package rulefile implements the parts shared by the line-oriented rule files
+used to configure resolution (e.g. host policies, domain rules, and
+interstitial rules): scanning lines, parsing directives and headers, and
+holding the parsed rules so that they may be reloaded at runtime.
+
+Rule files contain one rule per line. Blank lines and lines starting with
+"#" are ignored. Many rule files contain directives, where each line is a
+key (e.g. a hostmatch pattern or a rule name) followed by a directive name
+and a value, which is the remainder of the line and may contain spaces:
+
+	# key        name    value
+	news.example header  Accept-Language: en-US,en;q=0.9
+*/
+package rulefile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrMissingValue is returned when a directive has no name or value.
+var ErrMissingValue = errors.New("directive must be in \"key name value\" format")
+
+// Line is a single non-empty, non-comment line of a rule file.
+type Line struct {
+	// Num is the 1-based line number.
+	Num int
+
+	// Text is the line, without leading or trailing space.
+	Text string
+
+	// Fields are the line's whitespace-separated fields.
+	Fields []string
+}
+
+// Rest returns the remainder of the line after its first n fields, without
+// leading or trailing space.
+func (l Line) Rest(n int) string {
+	rest := l.Text
+	for _, field := range l.Fields[:min(n, len(l.Fields))] {
+		rest = strings.TrimSpace(rest)[len(field):]
+	}
+	return strings.TrimSpace(rest)
+}
+
+// Scan calls fn with each rule line read from r, in order. Errors returned by
+// fn are annotated with the line number.
+func Scan(r io.Reader, fn func(Line) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := fn(Line{Num: lineNum, Text: text, Fields: strings.Fields(text)}); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Directive is a rule line in "key name value" format.
+type Directive struct {
+	// Key identifies what the directive applies to, e.g. a hostmatch
+	// pattern or a rule name.
+	Key string
+
+	// Name is the directive's name, in lower case.
+	Name string
+
+	// Value is the remainder of the line, which may contain spaces.
+	Value string
+}
+
+// ScanDirectives calls fn with each directive read from r, in order. Errors
+// returned by fn are annotated with the line number.
+func ScanDirectives(r io.Reader, fn func(Directive) error) error {
+	return Scan(r, func(l Line) error {
+		if len(l.Fields) < 3 {
+			return ErrMissingValue
+		}
+		return fn(Directive{
+			Key:   l.Fields[0],
+			Name:  strings.ToLower(l.Fields[1]),
+			Value: l.Rest(2),
+		})
+	})
+}
+
+// ParseHeader parses a header given in "name: value" format, returning its
+// canonical name and its value.
+func ParseHeader(value string) (string, string, error) {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("header must be in \"name: value\" format: %q", value)
+	}
+	return textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(val), nil
+}
+
+// File holds the rules parsed from a file, which may be reloaded at runtime.
+type File[T any] struct {
+	path  string
+	parse func(io.Reader) (T, error)
+
+	mu    sync.RWMutex
+	rules T
+}
+
+// Static creates a File holding the given rules, which is not backed by an
+// actual file and so is never reloaded.
+func Static[T any](rules T) *File[T] {
+	return &File[T]{rules: rules}
+}
+
+// Load creates a File holding the rules parsed from the file at path. The
+// rules may be reloaded later via Reload.
+func Load[T any](path string, parse func(io.Reader) (T, error)) (*File[T], error) {
+	f := &File[T]{path: path, parse: parse}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the rules from the file they were loaded from. If the
+// file cannot be read or parsed, the existing rules are kept.
+func (f *File[T]) Reload() error {
+	if f.path == "" {
+		return nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rules, err := f.parse(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+	return nil
+}
+
+// Rules returns the current rules.
+func (f *File[T]) Rules() T {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rules
+}
@@ -0,0 +1,185 @@
+//nolint:errcheck
+package rulefile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	var lines []Line
+	err := Scan(strings.NewReader(`
+# comments and blank lines are ignored
+
+  allow   example.com
+deny *.example.org   # not a comment
+`), func(l Line) error {
+		lines = append(lines, l)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Line{
+		{Num: 4, Text: "allow   example.com", Fields: []string{"allow", "example.com"}},
+		{Num: 5, Text: "deny *.example.org   # not a comment", Fields: []string{"deny", "*.example.org", "#", "not", "a", "comment"}},
+	}, lines)
+
+	errTest := errors.New("test error")
+	err = Scan(strings.NewReader("a\n\nb\n"), func(l Line) error {
+		if l.Text == "b" {
+			return errTest
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, errTest)
+	assert.ErrorContains(t, err, "line 3: ")
+}
+
+func TestLineRest(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		text string
+		n    int
+		want string
+	}{
+		"remainder":          {text: "a  b   c d  e", n: 2, want: "c d  e"},
+		"field repeated":     {text: "a a a b", n: 2, want: "a b"},
+		"all fields":         {text: "a b", n: 2, want: ""},
+		"more than fields":   {text: "a b", n: 5, want: ""},
+		"no fields consumed": {text: "a b", n: 0, want: "a b"},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			l := Line{Text: tc.text, Fields: strings.Fields(tc.text)}
+			assert.Equal(t, tc.want, l.Rest(tc.n))
+		})
+	}
+}
+
+func TestScanDirectives(t *testing.T) {
+	t.Parallel()
+
+	var directives []Directive
+	err := ScanDirectives(strings.NewReader(`
+news.example  User-Agent  test agent/1.0
+news.example  header      Accept-Language: en-US,en;q=0.9
+`), func(d Directive) error {
+		directives = append(directives, d)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Directive{
+		{Key: "news.example", Name: "user-agent", Value: "test agent/1.0"},
+		{Key: "news.example", Name: "header", Value: "Accept-Language: en-US,en;q=0.9"},
+	}, directives)
+
+	err = ScanDirectives(strings.NewReader("news.example timeout\n"), func(Directive) error { return nil })
+	assert.ErrorIs(t, err, ErrMissingValue)
+	assert.ErrorContains(t, err, "line 1: ")
+}
+
+func TestParseHeader(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		value     string
+		wantName  string
+		wantValue string
+		wantErr   bool
+	}{
+		"valid":            {value: "accept-language: en-US,en;q=0.9", wantName: "Accept-Language", wantValue: "en-US,en;q=0.9"},
+		"value with colon": {value: "X-Time: 12:30", wantName: "X-Time", wantValue: "12:30"},
+		"empty value":      {value: "X-Empty:", wantName: "X-Empty", wantValue: ""},
+		"missing colon":    {value: "X-Extra 1", wantErr: true},
+		"missing name":     {value: ": 1", wantErr: true},
+		"space in name":    {value: "X Extra: 1", wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			name, value, err := ParseHeader(tc.value)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantName, name)
+			assert.Equal(t, tc.wantValue, value)
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	parse := func(r io.Reader) ([]string, error) {
+		var rules []string
+		err := Scan(r, func(l Line) error {
+			if l.Text == "invalid" {
+				return errors.New("invalid rule")
+			}
+			rules = append(rules, l.Text)
+			return nil
+		})
+		return rules, err
+	}
+
+	t.Run("static", func(t *testing.T) {
+		t.Parallel()
+		f := Static([]string{"a"})
+		assert.NoError(t, f.Reload())
+		assert.Equal(t, []string{"a"}, f.Rules())
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Parallel()
+		_, err := Load(filepath.Join(t.TempDir(), "missing"), parse)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		t.Parallel()
+		path := writeRules(t, "invalid\n")
+		_, err := Load(path, parse)
+		assert.ErrorContains(t, err, path+": line 1: invalid rule")
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		t.Parallel()
+		path := writeRules(t, "a\n")
+		f, err := Load(path, parse)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []string{"a"}, f.Rules())
+
+		os.WriteFile(path, []byte("b\nc\n"), 0o600)
+		assert.NoError(t, f.Reload())
+		assert.Equal(t, []string{"b", "c"}, f.Rules())
+
+		// invalid rules are rejected and the existing rules kept
+		os.WriteFile(path, []byte("invalid\n"), 0o600)
+		assert.Error(t, f.Reload())
+		assert.Equal(t, []string{"b", "c"}, f.Rules())
+	})
+}
+
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
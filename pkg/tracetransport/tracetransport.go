@@ -10,6 +10,8 @@ import (
 	"github.com/honeycombio/beeline-go"
 	"github.com/honeycombio/beeline-go/trace"
 	"github.com/honeycombio/beeline-go/wrappers/hnynethttp"
+
+	"github.com/mccutchen/urlresolverapi/pkg/dnscache"
 )
 
 // New returns a new transport that adds detailed instrumentation to all
@@ -26,7 +28,11 @@ type traceTransport struct {
 
 func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := req.Context()
-	ctx = httptrace.WithClientTrace(ctx, newClientTrace(ctx))
+	tracer := &tracer{
+		ctx: ctx,
+	}
+	ctx = httptrace.WithClientTrace(ctx, newClientTrace(tracer))
+	ctx = dnscache.WithLookupTrace(ctx, tracer.DNSLookup)
 	req = req.WithContext(ctx)
 
 	resp, err := t.transport.RoundTrip(req)
@@ -34,10 +40,7 @@ func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, err
 }
 
-func newClientTrace(ctx context.Context) *httptrace.ClientTrace {
-	tracer := &tracer{
-		ctx: ctx,
-	}
+func newClientTrace(tracer *tracer) *httptrace.ClientTrace {
 	return &httptrace.ClientTrace{
 		DNSDone:              tracer.DNSDone,
 		DNSStart:             tracer.DNSStart,
@@ -87,8 +90,19 @@ func (t *tracer) DNSStart(info httptrace.DNSStartInfo) {
 	t.dnsSpan.AddField("net.host.name", info.Host)
 }
 
+// DNSLookup is called by a dnscache.Cache with additional details about a
+// DNS lookup, after DNSStart and before DNSDone.
+func (t *tracer) DNSLookup(info dnscache.LookupInfo) {
+	t.dnsSpan.AddField("net.dns.cache_hit", info.CacheHit)
+	t.dnsSpan.AddField("net.dns.cache_ttl_ms", info.TTL.Milliseconds())
+}
+
 // DNSDone is called when a DNS lookup ends.
 func (t *tracer) DNSDone(info httptrace.DNSDoneInfo) {
+	t.dnsSpan.AddField("net.dns.coalesced", info.Coalesced)
+	if info.Err != nil {
+		t.dnsSpan.AddField("error", info.Err.Error())
+	}
 	t.dnsSpan.Send()
 }
 